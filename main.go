@@ -1,27 +1,24 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"strconv"
 
 	"github.com/Posrabi/godb/src"
 	"github.com/chzyer/readline"
 	"github.com/olekukonko/tablewriter"
 )
 
-func doSelect(mb src.Backend, slct *src.SelectStatement) error {
-	results, err := mb.Select(slct)
+func doSelect(mb src.Backend, tx *src.Transaction, slct *src.SelectStatement) error {
+	results, err := mb.Select(tx, slct)
 	if err != nil {
 		return err
 	}
 
-	if len(results.Rows) == 0 {
-		log.Println("(no results)")
-		return nil
-	}
-
 	table := tablewriter.NewWriter(os.Stdout)
 	header := []string{}
 	for _, col := range results.Columns {
@@ -37,11 +34,28 @@ func doSelect(mb src.Backend, slct *src.SelectStatement) error {
 			typ := results.Columns[i].Type
 			s := ""
 
+			if cell.IsNull() {
+				row = append(row, "NULL")
+				continue
+			}
+
 			switch typ {
 			case src.IntType:
 				s = fmt.Sprintf("%d", cell.AsInt())
+			case src.BigIntType:
+				s = fmt.Sprintf("%d", cell.AsInt64())
+			case src.FloatType:
+				s = strconv.FormatFloat(cell.AsFloat(), 'g', -1, 64)
 			case src.TextType:
 				s = cell.AsText()
+			case src.DateType:
+				s = cell.AsTime().Format(src.DateFormat)
+			case src.TimeType:
+				s = cell.AsTime().Format(src.TimeFormat)
+			case src.TimestampType:
+				s = cell.AsTime().Format(src.TimestampFormat)
+			case src.IntervalType:
+				s = cell.AsInterval().String()
 			case src.BoolType:
 				s = "true"
 				if !cell.AsBool() {
@@ -68,7 +82,22 @@ func doSelect(mb src.Backend, slct *src.SelectStatement) error {
 }
 
 func main() {
-	mb := src.NewMemoryBackend()
+	dataFile := flag.String("data", "", "Path of the data file, tables are kept in memory when empty")
+	flag.Parse()
+
+	var mb src.Backend = src.NewMemoryBackend()
+	if *dataFile != "" {
+		db, err := src.NewDiskBackend(*dataFile)
+		if err != nil {
+			log.Fatalln("Error while opening data file:", err)
+		}
+		defer func() {
+			if err := db.Close(); err != nil {
+				log.Println("Error while closing data file:", err)
+			}
+		}()
+		mb = db
+	}
 
 	l, err := readline.NewEx(&readline.Config{
 		Prompt:          "# ",
@@ -83,6 +112,16 @@ func main() {
 
 	fmt.Println("Welcome")
 
+	// The transaction opened by BEGIN, until COMMIT or ROLLBACK. Outside of
+	// it every statement is committed on its own.
+	var tx *src.Transaction
+	var settings src.Settings
+	defer func() {
+		if tx != nil {
+			mb.Rollback(tx)
+		}
+	}()
+
 repl:
 	for {
 		fmt.Print("$ ")
@@ -116,26 +155,102 @@ repl:
 			continue repl
 		}
 
+		// Like in PostgreSQL, a BEGIN, COMMIT or ROLLBACK that has
+		// nothing to do is warned about and skipped, without an "ok"
 		for _, stmt := range ast.Statements {
 			switch stmt.Kind {
-			case src.CreateAstKind:
-				err = mb.CreateTable(stmt.Create)
+			case src.BeginAstKind:
+				if tx != nil {
+					log.Println("There is already a transaction in progress")
+					continue
+				}
+
+				tx, err = mb.Begin()
 				if err != nil {
-					log.Println("Error creating table:", err)
+					log.Println("Error beginning transaction:", err)
 					continue repl
 				}
+				tx.LockTimeout = settings.LockTimeout
+
+			case src.CommitAstKind:
+				if tx == nil {
+					log.Println("There is no transaction in progress")
+					continue
+				}
 
-			case src.InsertAstKind:
-				err = mb.Insert(stmt.Insert)
+				err = mb.Commit(tx)
+				tx = nil
 				if err != nil {
-					log.Println("Error inserting value:", err)
+					log.Println("Error committing transaction:", err)
 					continue repl
 				}
 
-			case src.SelectAstKind:
-				err := doSelect(mb, stmt.Select)
+			case src.RollbackAstKind:
+				if tx == nil {
+					log.Println("There is no transaction in progress")
+					continue
+				}
+
+				err = mb.Rollback(tx)
+				tx = nil
 				if err != nil {
-					log.Println("Error selecting values:", err)
+					log.Println("Error rolling back transaction:", err)
+					continue repl
+				}
+
+			case src.SavepointAstKind:
+				err = mb.Savepoint(tx, stmt.Savepoint)
+				if err != nil {
+					log.Println("Error setting savepoint:", err)
+					continue repl
+				}
+
+			case src.RollbackToSavepointAstKind:
+				err = mb.RollbackToSavepoint(tx, stmt.Savepoint)
+				if err != nil {
+					log.Println("Error rolling back to savepoint:", err)
+					continue repl
+				}
+
+			case src.ReleaseSavepointAstKind:
+				err = mb.ReleaseSavepoint(tx, stmt.Savepoint)
+				if err != nil {
+					log.Println("Error releasing savepoint:", err)
+					continue repl
+				}
+
+			case src.SetAstKind:
+				err = settings.Set(stmt.Set)
+				if err != nil {
+					log.Println("Error changing setting:", err)
+					continue repl
+				}
+
+			default:
+				// Outside of a transaction block, statements get a
+				// transaction of their own, which waits for locks no longer
+				// than the session allows
+				stmtTx := tx
+				if tx == nil && settings.LockTimeout != 0 {
+					stmtTx, err = mb.Begin()
+					if err != nil {
+						log.Println("Error beginning transaction:", err)
+						continue repl
+					}
+					stmtTx.LockTimeout = settings.LockTimeout
+				}
+
+				ok := doStatement(mb, stmtTx, stmt)
+				if stmtTx != tx {
+					if !ok {
+						mb.Rollback(stmtTx)
+					} else if err := mb.Commit(stmtTx); err != nil {
+						log.Println("Error committing transaction:", err)
+						ok = false
+					}
+				}
+
+				if !ok {
 					continue repl
 				}
 			}
@@ -143,3 +258,87 @@ repl:
 		}
 	}
 }
+
+// doStatement runs a statement other than those that control transactions
+// and settings, and reports whether it succeeded.
+func doStatement(mb src.Backend, tx *src.Transaction, stmt *src.Statement) bool {
+	var err error
+	switch stmt.Kind {
+	case src.CreateAstKind:
+		err = mb.CreateTable(tx, stmt.Create)
+		if err != nil {
+			log.Println("Error creating table:", err)
+			return false
+		}
+
+	case src.CreateIndexAstKind:
+		err = mb.CreateIndex(tx, stmt.CreateIndex)
+		if err != nil {
+			log.Println("Error creating index:", err)
+			return false
+		}
+
+	case src.InsertAstKind:
+		err = mb.Insert(tx, stmt.Insert)
+		if err != nil {
+			log.Println("Error inserting value:", err)
+			return false
+		}
+
+	case src.UpdateAstKind:
+		count, err := mb.Update(tx, stmt.Update)
+		if err != nil {
+			log.Println("Error updating values:", err)
+			return false
+		}
+
+		if count == 1 {
+			log.Println("(1 row updated)")
+		} else {
+			log.Printf("(%d rows updated)", count)
+		}
+
+	case src.DeleteAstKind:
+		count, err := mb.Delete(tx, stmt.Delete)
+		if err != nil {
+			log.Println("Error deleting values:", err)
+			return false
+		}
+
+		if count == 1 {
+			log.Println("(1 row deleted)")
+		} else {
+			log.Printf("(%d rows deleted)", count)
+		}
+
+	case src.DropTableAstKind:
+		err = mb.DropTable(tx, stmt.DropTable)
+		if err != nil {
+			log.Println("Error dropping table:", err)
+			return false
+		}
+
+	case src.DropIndexAstKind:
+		err = mb.DropIndex(tx, stmt.DropIndex)
+		if err != nil {
+			log.Println("Error dropping index:", err)
+			return false
+		}
+
+	case src.TruncateAstKind:
+		err = mb.Truncate(tx, stmt.Truncate)
+		if err != nil {
+			log.Println("Error truncating table:", err)
+			return false
+		}
+
+	case src.SelectAstKind:
+		err := doSelect(mb, tx, stmt.Select)
+		if err != nil {
+			log.Println("Error selecting values:", err)
+			return false
+		}
+	}
+
+	return true
+}
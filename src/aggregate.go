@@ -0,0 +1,527 @@
+package src
+
+import (
+	"encoding/binary"
+	"math"
+	"strconv"
+)
+
+// Aggregate functions are computed over every row of a group, rather than
+// over a single row.
+var aggregateFunctions = map[string]bool{
+	"count": true,
+	"sum":   true,
+	"min":   true,
+	"max":   true,
+	"avg":   true,
+}
+
+func isAggregate(exp expression) bool {
+	return exp.kind == functionKind && aggregateFunctions[exp.function.name.value]
+}
+
+func containsAggregate(exp expression) bool {
+	switch exp.kind {
+	case binaryKind:
+		return containsAggregate(exp.binary.a) || containsAggregate(exp.binary.b)
+	case unaryKind:
+		return containsAggregate(exp.unary.a)
+	case functionKind:
+		if isAggregate(exp) {
+			return true
+		}
+
+		for _, arg := range exp.function.args {
+			if containsAggregate(arg) {
+				return true
+			}
+		}
+	case subqueryKind:
+		// Aggregates of the subquery itself are computed by it
+		return exp.subquery.a != nil && containsAggregate(*exp.subquery.a)
+	}
+
+	return false
+}
+
+// isGroupedSelect tells whether a SELECT goes through the grouping stage,
+// which is the case as soon as it aggregates anything.
+func isGroupedSelect(slct *SelectStatement) bool {
+	if slct.groupBy != nil || slct.having != nil {
+		return true
+	}
+
+	if slct.item != nil {
+		for _, item := range *slct.item {
+			if !item.asterisk && containsAggregate(*item.exp) {
+				return true
+			}
+		}
+	}
+
+	if slct.orderBy != nil {
+		for _, o := range *slct.orderBy {
+			if containsAggregate(o.exp) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// checkGrouped makes sure an expression evaluated once per group only refers
+// to columns through GROUP BY expressions or aggregate calls.
+func checkGrouped(exp expression, groupCodes map[string]bool) error {
+	if groupCodes[exp.generateCode()] {
+		return nil
+	}
+
+	switch exp.kind {
+	case literal:
+		if exp.literal.kind == IdentifierKind {
+			return ColumnNotGrouped
+		}
+	case binaryKind:
+		err := checkGrouped(exp.binary.a, groupCodes)
+		if err != nil {
+			return err
+		}
+
+		return checkGrouped(exp.binary.b, groupCodes)
+	case unaryKind:
+		return checkGrouped(exp.unary.a, groupCodes)
+	case functionKind:
+		if isAggregate(exp) {
+			// Aggregates cannot be nested
+			for _, arg := range exp.function.args {
+				if containsAggregate(arg) {
+					return AggregateNotAllowed
+				}
+			}
+
+			return nil
+		}
+
+		for _, arg := range exp.function.args {
+			err := checkGrouped(arg, groupCodes)
+			if err != nil {
+				return err
+			}
+		}
+	case subqueryKind:
+		if exp.subquery.a != nil {
+			return checkGrouped(*exp.subquery.a, groupCodes)
+		}
+	}
+
+	return nil
+}
+
+// collectAggregates appends the aggregate calls of an expression that are not
+// in calls yet.
+func collectAggregates(exp expression, calls []functionCall) []functionCall {
+	switch exp.kind {
+	case binaryKind:
+		calls = collectAggregates(exp.binary.a, calls)
+		return collectAggregates(exp.binary.b, calls)
+	case unaryKind:
+		return collectAggregates(exp.unary.a, calls)
+	case functionKind:
+		if !isAggregate(exp) {
+			for _, arg := range exp.function.args {
+				calls = collectAggregates(arg, calls)
+			}
+
+			return calls
+		}
+
+		code := exp.generateCode()
+		for _, call := range calls {
+			if call.generateCode() == code {
+				return calls
+			}
+		}
+
+		return append(calls, *exp.function)
+	case subqueryKind:
+		if exp.subquery.a != nil {
+			return collectAggregates(*exp.subquery.a, calls)
+		}
+	}
+
+	return calls
+}
+
+// group runs the grouping stage of a SELECT. It filters the rows visible to
+// tx by WHERE, and returns a grouped table with a row per group, on which the
+// select items, HAVING and ORDER BY are evaluated instead.
+func (t *table) group(tx *Transaction, slct *SelectStatement) (*table, error) {
+	grouped := &table{grouped: true, scope: t.scope}
+
+	var groupBy []expression
+	groupCodes := map[string]bool{}
+	if slct.groupBy != nil {
+		for _, exp := range *slct.groupBy {
+			exp, err := t.resolveGroupBy(*exp, slct.item)
+			if err != nil {
+				return nil, err
+			}
+
+			if containsAggregate(exp) {
+				return nil, AggregateNotAllowed
+			}
+
+			typ, err := t.expressionType(exp)
+			if err != nil {
+				return nil, err
+			}
+
+			code := exp.generateCode()
+			groupBy = append(groupBy, exp)
+			groupCodes[code] = true
+			grouped.columns = append(grouped.columns, code)
+			grouped.columnTypes = append(grouped.columnTypes, typ)
+		}
+	}
+
+	var exps []expression
+	aliases := map[string]bool{}
+	if slct.item != nil {
+		for _, item := range *slct.item {
+			if item.asterisk {
+				return nil, ColumnNotGrouped
+			}
+
+			exps = append(exps, *item.exp)
+			if item.as != nil {
+				aliases[item.as.value] = true
+			}
+		}
+	}
+
+	if slct.having != nil {
+		exps = append(exps, *slct.having)
+	}
+
+	if slct.orderBy != nil {
+		for _, o := range *slct.orderBy {
+			if o.exp.kind == literal && o.exp.literal.kind == IdentifierKind && aliases[o.exp.literal.value] {
+				continue
+			}
+
+			exps = append(exps, o.exp)
+		}
+	}
+
+	var calls []functionCall
+	for _, exp := range exps {
+		err := checkGrouped(exp, groupCodes)
+		if err != nil {
+			return nil, err
+		}
+
+		calls = collectAggregates(exp, calls)
+	}
+
+	for _, call := range calls {
+		typ, err := t.aggregateType(call)
+		if err != nil {
+			return nil, err
+		}
+
+		grouped.columns = append(grouped.columns, call.generateCode())
+		grouped.columnTypes = append(grouped.columnTypes, typ)
+	}
+
+	groups := map[string]int{}
+	var aggregators [][]*aggregator
+	rowIndexes, err := t.candidateRows(tx, slct.where)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, i := range rowIndexes {
+		if slct.where != nil {
+			val, _, _, err := t.evaluateCell(i, *slct.where)
+			if err != nil {
+				return nil, err
+			}
+
+			if !val.AsBool() {
+				continue
+			}
+		}
+
+		key := []byte{}
+		values := []memoryCell{}
+		for _, exp := range groupBy {
+			value, _, _, err := t.evaluateCell(i, exp)
+			if err != nil {
+				return nil, err
+			}
+
+			key = appendGroupKey(key, value)
+			values = append(values, value)
+		}
+
+		g, ok := groups[string(key)]
+		if !ok {
+			g = len(grouped.rows)
+			groups[string(key)] = g
+			grouped.rows = append(grouped.rows, values)
+			aggregators = append(aggregators, newAggregators(calls, grouped.columnTypes[len(groupBy):]))
+		}
+
+		for _, a := range aggregators[g] {
+			err := a.add(t, i)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Without GROUP BY there is exactly one group, even when no row matches
+	if len(groupBy) == 0 && len(grouped.rows) == 0 {
+		grouped.rows = append(grouped.rows, []memoryCell{})
+		aggregators = append(aggregators, newAggregators(calls, grouped.columnTypes))
+	}
+
+	for g := range grouped.rows {
+		for _, a := range aggregators[g] {
+			value, err := a.result()
+			if err != nil {
+				return nil, err
+			}
+
+			grouped.rows[g] = append(grouped.rows[g], value)
+		}
+	}
+
+	return grouped, nil
+}
+
+// resolveGroupBy returns the expression a GROUP BY item groups by. Like in
+// PostgreSQL, a number is the position of a select item, and a bare name
+// that is not a column of the table is the alias of a select item.
+func (t *table) resolveGroupBy(exp expression, items *[]*selectItem) (expression, error) {
+	if exp.kind != literal {
+		return exp, nil
+	}
+
+	switch exp.literal.kind {
+	case NumericKind:
+		position, err := strconv.Atoi(exp.literal.value)
+		if err != nil || items == nil || position < 1 || position > len(*items) || (*items)[position-1].asterisk {
+			return exp, InvalidGroupByPosition
+		}
+
+		return *(*items)[position-1].exp, nil
+	case IdentifierKind:
+		if _, err := t.columnIndex(exp.literal.value); err != ColumnDoesNotExist || items == nil {
+			return exp, nil
+		}
+
+		for _, item := range *items {
+			if item.as != nil && item.as.value == exp.literal.value {
+				return *item.exp, nil
+			}
+		}
+	}
+
+	return exp, nil
+}
+
+// appendGroupKey encodes a GROUP BY value so that NULLs, which are all in the
+// same group, do not collide with any other value.
+func appendGroupKey(key []byte, value memoryCell) []byte {
+	if value.IsNull() {
+		return append(key, 0)
+	}
+
+	length := make([]byte, 4)
+	binary.BigEndian.PutUint32(length, uint32(len(value)))
+	key = append(key, 1)
+	key = append(key, length...)
+	return append(key, value...)
+}
+
+// aggregateType checks the arguments of an aggregate call, and returns the
+// type of its result.
+func (t *table) aggregateType(call functionCall) (columnType, error) {
+	name := call.name.value
+	if call.star {
+		if name != "count" {
+			return 0, FunctionDoesNotExist
+		}
+
+		return BigIntType, nil
+	}
+
+	if len(call.args) != 1 {
+		return 0, FunctionDoesNotExist
+	}
+
+	typ, err := t.expressionType(call.args[0])
+	if err != nil {
+		return 0, err
+	}
+
+	switch name {
+	case "count":
+		return BigIntType, nil
+	case "sum", "avg":
+		if typ == IntervalType {
+			return IntervalType, nil
+		}
+
+		if !isNumeric(typ) && typ != NullType {
+			return 0, InvalidOperands
+		}
+
+		// Integers are summed as bigints, and averaged as doubles
+		if typ == FloatType || name == "avg" {
+			return FloatType, nil
+		}
+
+		return BigIntType, nil
+	}
+
+	// min and max
+	if typ == BoolType {
+		return 0, InvalidOperands
+	}
+
+	return typ, nil
+}
+
+// expressionType evaluates an expression on a row of NULLs, which yields a
+// NULL of the right type without depending on the contents of the table.
+// Its subqueries only return their columns.
+func (t *table) expressionType(exp expression) (columnType, error) {
+	probe := &table{
+		name:        t.name,
+		columns:     t.columns,
+		columnTypes: t.columnTypes,
+		rows:        [][]memoryCell{make([]memoryCell, len(t.columns))},
+		grouped:     t.grouped,
+		qualifiers:  t.qualifiers,
+		scope:       t.scope.probing(),
+	}
+
+	_, _, typ, err := probe.evaluateCell(0, exp)
+	return typ, err
+}
+
+type aggregator struct {
+	call  functionCall
+	typ   columnType
+	count int64
+	sum   int64
+	fsum  float64
+	isum  Interval
+	value memoryCell
+}
+
+func newAggregators(calls []functionCall, types []columnType) []*aggregator {
+	var aggregators []*aggregator
+	for i, call := range calls {
+		aggregators = append(aggregators, &aggregator{call: call, typ: types[i]})
+	}
+
+	return aggregators
+}
+
+// add accumulates a row of the group. NULLs are ignored by every aggregate
+// but count(*).
+func (a *aggregator) add(t *table, rowIndex uint) error {
+	if a.call.star {
+		a.count++
+		return nil
+	}
+
+	value, _, typ, err := t.evaluateCell(rowIndex, a.call.args[0])
+	if err != nil {
+		return err
+	}
+
+	if value.IsNull() {
+		return nil
+	}
+
+	a.count++
+	switch a.call.name.value {
+	case "sum", "avg":
+		if typ == FloatType {
+			a.fsum += value.AsFloat()
+			break
+		}
+
+		if typ == IntervalType {
+			a.isum, err = a.isum.add(value.AsInterval())
+			return err
+		}
+
+		i := value.AsInt64()
+		a.fsum += float64(i)
+		if a.typ == BigIntType && ((i > 0 && a.sum > math.MaxInt64-i) || (i < 0 && a.sum < math.MinInt64-i)) {
+			return IntegerOutOfRange
+		}
+		a.sum += i
+	case "min":
+		if a.value == nil || value.compare(a.value, a.typ) < 0 {
+			a.value = value
+		}
+	case "max":
+		if a.value == nil || value.compare(a.value, a.typ) > 0 {
+			a.value = value
+		}
+	}
+
+	return nil
+}
+
+// result returns the value of the aggregate over the group. Only count is
+// not NULL for a group without values.
+func (a *aggregator) result() (memoryCell, error) {
+	name := a.call.name.value
+	if name == "count" {
+		return bigIntCell(a.count), nil
+	}
+
+	if name != "sum" && name != "avg" {
+		return a.value, nil
+	}
+
+	if a.count == 0 {
+		return nil, nil
+	}
+
+	if a.typ == BigIntType {
+		return bigIntCell(a.sum), nil
+	}
+
+	if a.typ == IntervalType {
+		res := a.isum
+		if name == "avg" {
+			var err error
+			res, err = res.mul(1 / float64(a.count))
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return intervalCell(res), nil
+	}
+
+	res := a.fsum
+	if name == "avg" {
+		res /= float64(a.count)
+	}
+
+	if math.IsInf(res, 0) {
+		return nil, FloatOutOfRange
+	}
+
+	return floatCell(res), nil
+}
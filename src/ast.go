@@ -1,6 +1,9 @@
 package src
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 type ast struct {
 	Statements []*Statement
@@ -12,18 +15,115 @@ const (
 	SelectAstKind astKind = iota
 	CreateAstKind
 	InsertAstKind
+	UpdateAstKind
+	DeleteAstKind
+	DropTableAstKind
+	DropIndexAstKind
+	TruncateAstKind
+	CreateIndexAstKind
+	BeginAstKind
+	CommitAstKind
+	RollbackAstKind
+	SavepointAstKind
+	RollbackToSavepointAstKind
+	ReleaseSavepointAstKind
+	SetAstKind
 )
 
 type Statement struct {
-	Select *SelectStatement
-	Create *CreateTableStatement
-	Insert *InsertStatement
-	Kind   astKind
+	Select      *SelectStatement
+	Create      *CreateTableStatement
+	Insert      *InsertStatement
+	Update      *UpdateStatement
+	Delete      *DeleteStatement
+	DropTable   *DropTableStatement
+	DropIndex   *DropIndexStatement
+	Truncate    *TruncateStatement
+	CreateIndex *CreateIndexStatement
+	Savepoint   *SavepointStatement
+	Set         *SetStatement
+	Kind        astKind
 }
 
+// generateCode turns a statement that changes the definition of the tables
+// back into SQL that parses to the same statement, for the write-ahead log.
+// Rows are logged by value instead.
+func (s Statement) generateCode() string {
+	switch s.Kind {
+	case CreateAstKind:
+		return s.Create.generateCode()
+	case DropTableAstKind:
+		return s.DropTable.generateCode()
+	case DropIndexAstKind:
+		return s.DropIndex.generateCode()
+	case CreateIndexAstKind:
+		return s.CreateIndex.generateCode()
+	}
+
+	return ""
+}
+
+// An InsertStatement takes its rows either from values or from selection.
+// When columns is nil, values are given for every column in order.
 type InsertStatement struct {
-	table  token
-	values *[]*expression
+	table     token
+	columns   *[]*token
+	values    *[][]*expression
+	selection *SelectStatement
+}
+
+type UpdateStatement struct {
+	table token
+	set   *[]*setItem
+	where *expression
+}
+
+// A SavepointStatement names the savepoint that SAVEPOINT, ROLLBACK TO
+// SAVEPOINT and RELEASE SAVEPOINT act on.
+type SavepointStatement struct {
+	name token
+}
+
+// A SetStatement changes a setting of the session to a literal, or back to
+// its default with the DEFAULT keyword.
+type SetStatement struct {
+	name  token
+	value token
+}
+
+type DeleteStatement struct {
+	table token
+	where *expression
+}
+
+type DropTableStatement struct {
+	name     token
+	ifExists bool
+}
+
+func (dt DropTableStatement) generateCode() string {
+	if dt.ifExists {
+		return "DROP TABLE IF EXISTS " + quoteIdentifier(dt.name.value)
+	}
+
+	return "DROP TABLE " + quoteIdentifier(dt.name.value)
+}
+
+type DropIndexStatement struct {
+	name     token
+	ifExists bool
+}
+
+func (di DropIndexStatement) generateCode() string {
+	if di.ifExists {
+		return "DROP INDEX IF EXISTS " + quoteIdentifier(di.name.value)
+	}
+
+	return "DROP INDEX " + quoteIdentifier(di.name.value)
+}
+
+type TruncateStatement struct {
+	table token
 }
 
 type CreateTableStatement struct {
@@ -31,6 +131,17 @@ type CreateTableStatement struct {
 	cols *[]*columnDefinition
 }
 
+func (ct CreateTableStatement) generateCode() string {
+	cols := []string{}
+	if ct.cols != nil {
+		for _, col := range *ct.cols {
+			cols = append(cols, col.generateCode())
+		}
+	}
+
+	return fmt.Sprintf("CREATE TABLE %s (%s)", quoteIdentifier(ct.name.value), strings.Join(cols, ", "))
+}
+
 type CreateIndexStatement struct {
 	table      token
 	name       token
@@ -39,10 +150,80 @@ type CreateIndexStatement struct {
 	exp        expression
 }
 
+func (ci CreateIndexStatement) generateCode() string {
+	unique := ""
+	if ci.unique {
+		unique = " UNIQUE"
+	}
+
+	return fmt.Sprintf("CREATE%s INDEX %s ON %s (%s)", unique, quoteIdentifier(ci.name.value), quoteIdentifier(ci.table.value), ci.exp.generateCode())
+}
+
 type SelectStatement struct {
-	item  *[]*selectItem
-	from  *token
-	where *expression
+	item    *[]*selectItem
+	from    *fromItem
+	where   *expression
+	groupBy *[]*expression
+	having  *expression
+	orderBy *[]*orderByItem
+	limit   *expression
+	offset  *expression
+
+	// Mode of the row locks taken by FOR UPDATE or FOR SHARE, if any
+	lock lockMode
+}
+
+// generateCode turns a SELECT back into SQL, for the subqueries of the
+// expressions that are turned back into code.
+func (ss SelectStatement) generateCode() string {
+	items := []string{}
+	if ss.item != nil {
+		for _, item := range *ss.item {
+			items = append(items, item.generateCode())
+		}
+	}
+
+	code := "SELECT " + strings.Join(items, ", ")
+	if ss.from != nil {
+		code += " FROM " + ss.from.generateCode()
+	}
+	if ss.where != nil {
+		code += " WHERE " + ss.where.generateCode()
+	}
+	if ss.groupBy != nil {
+		groupBy := []string{}
+		for _, exp := range *ss.groupBy {
+			groupBy = append(groupBy, exp.generateCode())
+		}
+
+		code += " GROUP BY " + strings.Join(groupBy, ", ")
+	}
+	if ss.having != nil {
+		code += " HAVING " + ss.having.generateCode()
+	}
+	if ss.orderBy != nil {
+		orderBy := []string{}
+		for _, o := range *ss.orderBy {
+			orderBy = append(orderBy, o.generateCode())
+		}
+
+		code += " ORDER BY " + strings.Join(orderBy, ", ")
+	}
+	if ss.limit != nil {
+		code += " LIMIT " + ss.limit.generateCode()
+	}
+	if ss.offset != nil {
+		code += " OFFSET " + ss.offset.generateCode()
+	}
+
+	switch ss.lock {
+	case exclusiveLock:
+		code += " FOR UPDATE"
+	case sharedLock:
+		code += " FOR SHARE"
+	}
+
+	return code
 }
 
 type expressionKind uint
@@ -50,6 +231,10 @@ type expressionKind uint
 const (
 	literal expressionKind = iota
 	binaryKind
+	unaryKind
+	functionKind
+	typedLiteralKind
+	subqueryKind
 )
 
 type binaryExpression struct {
@@ -62,10 +247,88 @@ func (be binaryExpression) generateCode() string {
 	return fmt.Sprintf("(%s %s %s)", be.a.generateCode(), be.op.value, be.b.generateCode())
 }
 
+type unaryExpression struct {
+	a  expression
+	op token
+}
+
+func (ue unaryExpression) generateCode() string {
+	return fmt.Sprintf("(%s %s)", ue.op.value, ue.a.generateCode())
+}
+
+// A star call like count(*) has no args.
+type functionCall struct {
+	name token
+	args []expression
+	star bool
+}
+
+func (fc functionCall) generateCode() string {
+	if fc.star {
+		return fmt.Sprintf("%s(*)", fc.name.value)
+	}
+
+	var args []string
+	for _, arg := range fc.args {
+		args = append(args, arg.generateCode())
+	}
+
+	return fmt.Sprintf("%s(%s)", fc.name.value, strings.Join(args, ", "))
+}
+
+// A typedLiteral is a string read as a value of the type it is prefixed
+// with, as in DATE '2024-01-01'.
+type typedLiteral struct {
+	typ   token
+	value token
+}
+
+func (tl typedLiteral) generateCode() string {
+	return fmt.Sprintf("%s '%s'", tl.typ.value, tl.value.value)
+}
+
+type subqueryForm uint
+
+const (
+	scalarSubquery subqueryForm = iota
+	existsSubquery
+	inSubquery
+)
+
+// A subquery is a SELECT used as the single value it returns, tested for
+// any row by EXISTS, or searched for the value of a by IN. NOT IN is kept as
+// the negation of IN.
+type subquery struct {
+	form subqueryForm
+	slct *SelectStatement
+	a    *expression
+}
+
+func (sq subquery) generateCode() string {
+	switch sq.form {
+	case existsSubquery:
+		return fmt.Sprintf("(exists (%s))", sq.slct.generateCode())
+	case inSubquery:
+		return fmt.Sprintf("(%s in (%s))", sq.a.generateCode(), sq.slct.generateCode())
+	}
+
+	return fmt.Sprintf("(%s)", sq.slct.generateCode())
+}
+
 type expression struct {
-	literal *token
-	binary  *binaryExpression
-	kind    expressionKind
+	literal  *token
+	binary   *binaryExpression
+	unary    *unaryExpression
+	function *functionCall
+	typed    *typedLiteral
+	subquery *subquery
+	kind     expressionKind
+}
+
+// quoteIdentifier writes a name as a quoted identifier, doubling the quotes
+// it contains.
+func quoteIdentifier(name string) string {
+	return "\"" + strings.ReplaceAll(name, "\"", "\"\"") + "\""
 }
 
 func (e expression) generateCode() string {
@@ -73,27 +336,165 @@ func (e expression) generateCode() string {
 	case literal:
 		switch e.literal.kind {
 		case IdentifierKind:
-			return fmt.Sprintf("\"%s\"", e.literal.value)
+			return quoteIdentifier(e.literal.value)
 		case StringKind:
-			return fmt.Sprintf("%s", e.literal.value)
+			return fmt.Sprintf("'%s'", strings.ReplaceAll(e.literal.value, "'", "''"))
 		default:
 			return fmt.Sprintf(e.literal.value)
 		}
 	case binaryKind:
 		return e.binary.generateCode()
+	case unaryKind:
+		return e.unary.generateCode()
+	case functionKind:
+		return e.function.generateCode()
+	case typedLiteralKind:
+		return e.typed.generateCode()
+	case subqueryKind:
+		return e.subquery.generateCode()
 	}
 
 	return ""
 }
 
 type columnDefinition struct {
-	name       token
-	dataType   token
-	primaryKey bool
+	name         token
+	dataType     token
+	length       *token
+	primaryKey   bool
+	notNull      bool
+	defaultValue *expression
+}
+
+func (cd columnDefinition) generateCode() string {
+	code := fmt.Sprintf("%s %s", quoteIdentifier(cd.name.value), cd.dataType.value)
+	if cd.length != nil {
+		code += fmt.Sprintf("(%s)", cd.length.value)
+	}
+	if cd.primaryKey {
+		code += " PRIMARY KEY"
+	}
+	if cd.notNull {
+		code += " NOT NULL"
+	}
+	if cd.defaultValue != nil {
+		code += " DEFAULT " + cd.defaultValue.generateCode()
+	}
+
+	return code
 }
 
+// An asterisk item has no exp, and is limited to the columns of table if set.
 type selectItem struct {
 	exp      *expression
 	asterisk bool
+	table    *token
 	as       *token
 }
+
+func (si selectItem) generateCode() string {
+	if si.asterisk {
+		if si.table != nil {
+			return quoteIdentifier(si.table.value) + ".*"
+		}
+
+		return "*"
+	}
+
+	code := si.exp.generateCode()
+	if si.as != nil {
+		code += " AS " + quoteIdentifier(si.as.value)
+	}
+
+	return code
+}
+
+// A fromItem is either a table, optionally renamed by an alias, the results
+// of a subquery, which must be named by an alias, or a join of two other
+// items.
+type fromItem struct {
+	table    *token
+	subquery *SelectStatement
+	as       *token
+	join     *joinExpression
+}
+
+func (fi fromItem) generateCode() string {
+	if fi.join != nil {
+		return fi.join.generateCode()
+	}
+
+	code := ""
+	if fi.subquery != nil {
+		code = fmt.Sprintf("(%s)", fi.subquery.generateCode())
+	} else {
+		code = quoteIdentifier(fi.table.value)
+	}
+
+	if fi.as != nil {
+		code += " AS " + quoteIdentifier(fi.as.value)
+	}
+
+	return code
+}
+
+type joinKind uint
+
+const (
+	innerJoin joinKind = iota
+	leftJoin
+	rightJoin
+	fullJoin
+	crossJoin
+)
+
+// A cross join, including the comma form, has no ON condition.
+type joinExpression struct {
+	a    fromItem
+	b    fromItem
+	kind joinKind
+	on   *expression
+}
+
+func (je joinExpression) generateCode() string {
+	kinds := map[joinKind]string{
+		innerJoin: "JOIN",
+		leftJoin:  "LEFT JOIN",
+		rightJoin: "RIGHT JOIN",
+		fullJoin:  "FULL JOIN",
+		crossJoin: "CROSS JOIN",
+	}
+
+	code := fmt.Sprintf("%s %s %s", je.a.generateCode(), kinds[je.kind], je.b.generateCode())
+	if je.on != nil {
+		code += " ON " + je.on.generateCode()
+	}
+
+	return code
+}
+
+// nullsFirst defaults to desc, so that NULLs sort as if larger than any
+// other value.
+type orderByItem struct {
+	exp        expression
+	desc       bool
+	nullsFirst bool
+}
+
+func (o orderByItem) generateCode() string {
+	code := o.exp.generateCode()
+	if o.desc {
+		code += " DESC"
+	}
+
+	if o.nullsFirst {
+		return code + " NULLS FIRST"
+	}
+
+	return code + " NULLS LAST"
+}
+
+type setItem struct {
+	column token
+	exp    expression
+}
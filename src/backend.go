@@ -2,6 +2,8 @@ package src
 
 import (
 	"errors"
+	"fmt"
+	"time"
 )
 
 type columnType uint
@@ -10,12 +12,51 @@ const (
 	TextType columnType = iota
 	IntType
 	BoolType
+	NullType // type of the NULL literal, which fits any column
+	BigIntType
+	FloatType
+	DateType
+	TimeType
+	TimestampType
+	IntervalType
 )
 
+func (c columnType) String() string {
+	switch c {
+	case TextType:
+		return "text"
+	case IntType:
+		return "int"
+	case BoolType:
+		return "boolean"
+	case NullType:
+		return "null"
+	case BigIntType:
+		return "bigint"
+	case FloatType:
+		return "double"
+	case DateType:
+		return "date"
+	case TimeType:
+		return "time"
+	case TimestampType:
+		return "timestamp"
+	case IntervalType:
+		return "interval"
+	}
+
+	return "unknown"
+}
+
 type Cell interface {
 	AsText() string
 	AsInt() int32
+	AsInt64() int64
+	AsFloat() float64
+	AsTime() time.Time
+	AsInterval() Interval
 	AsBool() bool
+	IsNull() bool
 }
 
 type Results struct {
@@ -35,16 +76,77 @@ var (
 	InvalidSelectItem         = errors.New("Select item is not valid")
 	InvalidDatatype           = errors.New("Invalid datatype")
 	MissingValues             = errors.New("Missing values")
+	TooManyValues             = errors.New("More values than columns")
+	DuplicateColumn           = errors.New("Column specified more than once")
 	InvalidCell               = errors.New("Cell is invalid")
 	InvalidOperands           = errors.New("Operands are invalid")
+	DivisionByZero            = errors.New("Division by zero")
+	IntegerOutOfRange         = errors.New("Integer out of range")
+	FloatOutOfRange           = errors.New("Float out of range")
+	InvalidNumber             = errors.New("Invalid input syntax for a number")
+	ValueTooLong              = errors.New("Value too long for column")
+	InvalidDatetime           = errors.New("Invalid date, time or interval")
+	InvalidDatetimeField      = errors.New("Date or time field is not supported")
+	DatetimeOutOfRange        = errors.New("Date or time out of range")
 	IndexAlreadyExists        = errors.New("Index already exists")
 	PrimaryKeyAlreadyExists   = errors.New("Primary key already exists")
 	ViolatesNonNullConstraint = errors.New("Violates non-null constraint")
 	ViolatesUniqueConstraint  = errors.New("Violates unique constraint")
+	DeadlockDetected          = errors.New("Deadlock detected, transaction chosen as victim")
+	LockWaitTimeout           = errors.New("Canceling statement due to lock timeout")
+	IndexDoesNotExist         = errors.New("Index does not exist")
+	CannotDropPrimaryKey      = errors.New("Cannot drop the index of a primary key")
+	CorruptedDataFile         = errors.New("Data file is corrupted")
+	RecordTooLarge            = errors.New("Record does not fit in a page")
+	CorruptedLog              = errors.New("Write-ahead log is corrupted")
+	InvalidLimit              = errors.New("LIMIT and OFFSET must be non-negative integers")
+	FunctionDoesNotExist      = errors.New("Function does not exist")
+	AggregateNotAllowed       = errors.New("Aggregate functions are not allowed here")
+	ColumnNotGrouped          = errors.New("Column must appear in the GROUP BY clause or be used in an aggregate function")
+	InvalidGroupByPosition    = errors.New("GROUP BY position is not in select list")
+	AmbiguousColumn           = errors.New("Column reference is ambiguous")
+	DuplicateTableName        = errors.New("Table name specified more than once")
+	TransactionAborted        = errors.New("Current transaction is aborted, commands ignored until end of transaction block")
+	TransactionClosed         = errors.New("Transaction is already committed or rolled back")
+	SerializationFailure      = errors.New("Could not serialize access due to concurrent update")
+	NotInTransaction          = errors.New("Savepoints can only be used in transaction blocks")
+	SavepointDoesNotExist     = errors.New("Savepoint does not exist")
+	LockingNotAllowed         = errors.New("FOR UPDATE and FOR SHARE are not allowed with joins, subqueries in FROM, GROUP BY or aggregate functions")
+	UnknownSetting            = errors.New("Unrecognized configuration parameter")
+	InvalidSettingValue       = errors.New("Invalid value for parameter")
+	SubqueryTooManyColumns    = errors.New("Subquery must return only one column")
+	SubqueryTooManyRows       = errors.New("More than one row returned by a subquery used as an expression")
+	SubqueryNotAllowed        = errors.New("Subqueries are not allowed here")
 )
 
+// ColumnTypeError is returned when a value does not fit the type of the
+// column it is stored in.
+type ColumnTypeError struct {
+	Column   string
+	Expected columnType
+	Actual   columnType
+}
+
+func (e *ColumnTypeError) Error() string {
+	return fmt.Sprintf("Column \"%s\" is of type %s but expression is of type %s", e.Column, e.Expected, e.Actual)
+}
+
+// A Backend runs every statement in the transaction it is given, or in a
+// transaction of its own when it is given nil.
 type Backend interface {
-	CreateTable(*CreateTableStatement) error
-	Insert(*InsertStatement) error
-	Select(*SelectStatement) (*Results, error)
+	Begin() (*Transaction, error)
+	Commit(*Transaction) error
+	Rollback(*Transaction) error
+	Savepoint(*Transaction, *SavepointStatement) error
+	RollbackToSavepoint(*Transaction, *SavepointStatement) error
+	ReleaseSavepoint(*Transaction, *SavepointStatement) error
+	CreateTable(*Transaction, *CreateTableStatement) error
+	CreateIndex(*Transaction, *CreateIndexStatement) error
+	Insert(*Transaction, *InsertStatement) error
+	Update(*Transaction, *UpdateStatement) (uint, error)
+	Delete(*Transaction, *DeleteStatement) (uint, error)
+	DropTable(*Transaction, *DropTableStatement) error
+	DropIndex(*Transaction, *DropIndexStatement) error
+	Truncate(*Transaction, *TruncateStatement) error
+	Select(*Transaction, *SelectStatement) (*Results, error)
 }
@@ -0,0 +1,800 @@
+package src
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Dates are stored as the number of days since 1970-01-01 like ints, and
+// timestamps and times as a number of microseconds like bigints, since
+// 1970-01-01 00:00:00 and midnight. Their bytes sort in the same order as
+// their values, as for numbers.
+
+// Layouts of dates and times, following ISO 8601.
+const (
+	DateFormat      = "2006-01-02"
+	TimeFormat      = "15:04:05.999999"
+	TimestampFormat = "2006-01-02T15:04:05.999999"
+)
+
+// datetimeTypes are the date and time types by name. The names are not
+// reserved, as they are common column names.
+var datetimeTypes = map[string]columnType{
+	"date":      DateType,
+	"time":      TimeType,
+	"timestamp": TimestampType,
+	"interval":  IntervalType,
+}
+
+const (
+	microsPerSecond = int64(time.Second / time.Microsecond)
+	microsPerMinute = 60 * microsPerSecond
+	microsPerHour   = 60 * microsPerMinute
+	microsPerDay    = 24 * microsPerHour
+
+	// Intervals are compared as if months had 30 days, as in PostgreSQL
+	daysPerMonth = 30
+)
+
+// Dates and times are limited to years 1 to 9999, which have four digits.
+var (
+	minTimestamp = time.Date(1, 1, 1, 0, 0, 0, 0, time.UTC).UnixMicro()
+	maxTimestamp = time.Date(10000, 1, 1, 0, 0, 0, 0, time.UTC).UnixMicro() - 1
+)
+
+func isDatetime(typ columnType) bool {
+	return typ == DateType || typ == TimeType || typ == TimestampType || typ == IntervalType
+}
+
+// Interval is a span of time. Months and days are kept apart from the rest,
+// as their length depends on the date they are added to.
+type Interval struct {
+	Months int32
+	Days   int32
+	Micros int64
+}
+
+// String formats the interval as an ISO 8601 duration, with a sign on each
+// negative component.
+func (iv Interval) String() string {
+	var b strings.Builder
+	b.WriteString("P")
+	if years := iv.Months / 12; years != 0 {
+		fmt.Fprintf(&b, "%dY", years)
+	}
+	if months := iv.Months % 12; months != 0 {
+		fmt.Fprintf(&b, "%dM", months)
+	}
+	if iv.Days != 0 {
+		fmt.Fprintf(&b, "%dD", iv.Days)
+	}
+
+	if iv.Micros != 0 {
+		b.WriteString("T")
+		if hours := iv.Micros / microsPerHour; hours != 0 {
+			fmt.Fprintf(&b, "%dH", hours)
+		}
+		if minutes := iv.Micros % microsPerHour / microsPerMinute; minutes != 0 {
+			fmt.Fprintf(&b, "%dM", minutes)
+		}
+		if micros := iv.Micros % microsPerMinute; micros != 0 {
+			b.WriteString(strconv.FormatFloat(float64(micros)/float64(microsPerSecond), 'f', -1, 64) + "S")
+		}
+	}
+
+	if b.Len() == 1 {
+		return "PT0S"
+	}
+
+	return b.String()
+}
+
+// span is the length of the interval with months of 30 days, by which
+// intervals are ordered.
+func (iv Interval) span() float64 {
+	return float64(iv.Months)*daysPerMonth*float64(microsPerDay) + float64(iv.Days)*float64(microsPerDay) + float64(iv.Micros)
+}
+
+func (iv Interval) add(other Interval) (Interval, error) {
+	months := int64(iv.Months) + int64(other.Months)
+	days := int64(iv.Days) + int64(other.Days)
+	micros := iv.Micros + other.Micros
+	overflow := (other.Micros > 0 && micros < iv.Micros) || (other.Micros < 0 && micros > iv.Micros)
+	if overflow || months != int64(int32(months)) || days != int64(int32(days)) {
+		return Interval{}, DatetimeOutOfRange
+	}
+
+	return Interval{Months: int32(months), Days: int32(days), Micros: micros}, nil
+}
+
+func (iv Interval) negate() Interval {
+	return Interval{Months: -iv.Months, Days: -iv.Days, Micros: -iv.Micros}
+}
+
+// mul multiplies every component of the interval, and carries the fractions
+// of months and days over to the smaller components.
+func (iv Interval) mul(f float64) (Interval, error) {
+	months := float64(iv.Months) * f
+	days := float64(iv.Days)*f + (months-math.Trunc(months))*daysPerMonth
+	micros := float64(iv.Micros)*f + (days-math.Trunc(days))*float64(microsPerDay)
+
+	months, days, micros = math.Trunc(months), math.Trunc(days), math.Round(micros)
+	if math.Abs(months) > math.MaxInt32 || math.Abs(days) > math.MaxInt32 || math.Abs(micros) >= math.MaxInt64 || math.IsNaN(micros) {
+		return Interval{}, DatetimeOutOfRange
+	}
+
+	return Interval{Months: int32(months), Days: int32(days), Micros: int64(micros)}, nil
+}
+
+func dateCell(t time.Time) memoryCell {
+	y, m, d := t.Date()
+	days := time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Unix() / int64(microsPerDay/microsPerSecond)
+	return intCell(int32(days))
+}
+
+func timestampCell(t time.Time) memoryCell {
+	return bigIntCell(t.UnixMicro())
+}
+
+func intervalCell(iv Interval) memoryCell {
+	cell := make(memoryCell, 16)
+	binary.BigEndian.PutUint32(cell, uint32(iv.Months)^intSignBit)
+	binary.BigEndian.PutUint32(cell[4:], uint32(iv.Days)^intSignBit)
+	binary.BigEndian.PutUint64(cell[8:], uint64(iv.Micros)^bigIntSignBit)
+	return cell
+}
+
+// AsTime reads dates and timestamps, and times as a time on 1970-01-01.
+func (mc memoryCell) AsTime() time.Time {
+	if len(mc) == 4 {
+		return time.Unix(int64(mc.AsInt())*int64(microsPerDay/microsPerSecond), 0).UTC()
+	}
+
+	return time.UnixMicro(mc.AsInt64()).UTC()
+}
+
+func (mc memoryCell) AsInterval() Interval {
+	return Interval{
+		Months: int32(binary.BigEndian.Uint32(mc) ^ intSignBit),
+		Days:   int32(binary.BigEndian.Uint32(mc[4:]) ^ intSignBit),
+		Micros: int64(binary.BigEndian.Uint64(mc[8:]) ^ bigIntSignBit),
+	}
+}
+
+// parseDatetime reads text as a date, a time, a timestamp or an interval.
+func parseDatetime(text string, typ columnType) (memoryCell, error) {
+	text = strings.TrimSpace(text)
+	switch typ {
+	case DateType:
+		t, err := time.Parse(DateFormat, text)
+		if err != nil {
+			return nil, InvalidDatetime
+		}
+
+		return dateCell(t), nil
+	case TimeType:
+		for _, layout := range []string{"15:04:05", "15:04"} {
+			t, err := time.Parse(layout, text)
+			if err == nil {
+				y, m, d := t.Date()
+				return bigIntCell(t.Sub(time.Date(y, m, d, 0, 0, 0, 0, time.UTC)).Microseconds()), nil
+			}
+		}
+	case TimestampType:
+		for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02 15:04", "2006-01-02T15:04", DateFormat} {
+			t, err := time.Parse(layout, text)
+			if err == nil {
+				return timestampCell(t), nil
+			}
+		}
+	case IntervalType:
+		iv, err := parseInterval(text)
+		if err != nil {
+			return nil, err
+		}
+
+		return intervalCell(iv), nil
+	}
+
+	return nil, InvalidDatetime
+}
+
+// Units of intervals in the PostgreSQL format, like '1 year 2 mons'.
+var intervalUnits = map[string]Interval{
+	"millennium":  {Months: 12000},
+	"century":     {Months: 1200},
+	"decade":      {Months: 120},
+	"year":        {Months: 12},
+	"month":       {Months: 1},
+	"mon":         {Months: 1},
+	"week":        {Days: 7},
+	"day":         {Days: 1},
+	"hour":        {Micros: microsPerHour},
+	"minute":      {Micros: microsPerMinute},
+	"min":         {Micros: microsPerMinute},
+	"second":      {Micros: microsPerSecond},
+	"sec":         {Micros: microsPerSecond},
+	"millisecond": {Micros: 1000},
+	"microsecond": {Micros: 1},
+}
+
+// Designators of ISO 8601 durations, before and after the T.
+var (
+	isoDateUnits = map[byte]Interval{'Y': {Months: 12}, 'M': {Months: 1}, 'W': {Days: 7}, 'D': {Days: 1}}
+	isoTimeUnits = map[byte]Interval{'H': {Micros: microsPerHour}, 'M': {Micros: microsPerMinute}, 'S': {Micros: microsPerSecond}}
+)
+
+// parseInterval reads an interval either as an ISO 8601 duration, like
+// 'P1DT2H', or in the PostgreSQL format, like '1 day 02:00:00' or
+// '2 hours ago'.
+func parseInterval(text string) (Interval, error) {
+	if strings.HasPrefix(text, "P") {
+		return parseISOInterval(text[1:])
+	}
+
+	fields := strings.Fields(strings.ToLower(text))
+	ago := len(fields) > 0 && fields[len(fields)-1] == "ago"
+	if ago {
+		fields = fields[:len(fields)-1]
+	}
+
+	if len(fields) == 0 {
+		return Interval{}, InvalidDatetime
+	}
+
+	iv := Interval{}
+	for i := 0; i < len(fields); i++ {
+		var part Interval
+		var err error
+		if strings.Contains(fields[i], ":") {
+			part, err = parseClock(fields[i])
+		} else if i+1 < len(fields) {
+			part, err = parseIntervalPart(fields[i], intervalUnits[strings.TrimSuffix(fields[i+1], "s")])
+			i++
+		} else {
+			err = InvalidDatetime
+		}
+
+		if err == nil {
+			iv, err = iv.add(part)
+		}
+		if err != nil {
+			return Interval{}, err
+		}
+	}
+
+	if ago {
+		return iv.negate(), nil
+	}
+
+	return iv, nil
+}
+
+func parseISOInterval(text string) (Interval, error) {
+	iv := Interval{}
+	units := isoDateUnits
+	inTime := false
+	for len(text) > 0 {
+		if text[0] == 'T' && !inTime {
+			units, inTime = isoTimeUnits, true
+			text = text[1:]
+			continue
+		}
+
+		end := strings.IndexFunc(text, func(r rune) bool {
+			return (r < '0' || r > '9') && r != '.' && r != '-' && r != '+'
+		})
+		if end <= 0 {
+			return Interval{}, InvalidDatetime
+		}
+
+		part, err := parseIntervalPart(text[:end], units[text[end]])
+		if err == nil {
+			iv, err = iv.add(part)
+		}
+		if err != nil {
+			return Interval{}, err
+		}
+
+		text = text[end+1:]
+	}
+
+	return iv, nil
+}
+
+// parseIntervalPart reads a number of units, which may be fractional.
+func parseIntervalPart(number string, unit Interval) (Interval, error) {
+	f, err := strconv.ParseFloat(number, 64)
+	if err != nil || unit == (Interval{}) {
+		return Interval{}, InvalidDatetime
+	}
+
+	return unit.mul(f)
+}
+
+// parseClock reads a signed [-]hh:mm[:ss[.ffffff]] part of an interval.
+func parseClock(text string) (Interval, error) {
+	sign := int64(1)
+	if strings.HasPrefix(text, "-") {
+		sign = -1
+		text = text[1:]
+	}
+
+	parts := strings.Split(text, ":")
+	if len(parts) > 3 {
+		return Interval{}, InvalidDatetime
+	}
+
+	micros := int64(0)
+	units := []int64{microsPerHour, microsPerMinute, microsPerSecond}
+	for i, part := range parts {
+		f, err := strconv.ParseFloat(part, 64)
+		if err != nil || f < 0 || (i > 0 && f >= 60) {
+			return Interval{}, InvalidDatetime
+		}
+
+		micros += int64(math.Round(f * float64(units[i])))
+	}
+
+	return Interval{Micros: sign * micros}, nil
+}
+
+// checkTimestamp makes sure a timestamp computed by arithmetic is still in
+// the supported range.
+func checkTimestamp(micros int64) (memoryCell, error) {
+	if micros < minTimestamp || micros > maxTimestamp {
+		return nil, DatetimeOutOfRange
+	}
+
+	return bigIntCell(micros), nil
+}
+
+// addInterval adds an interval to a timestamp. Adding months keeps the day of
+// the month, or takes the last day of shorter months.
+func addInterval(micros int64, iv Interval) (memoryCell, error) {
+	t := time.UnixMicro(micros).UTC()
+	if iv.Months != 0 {
+		y, m, d := t.Date()
+		months := int(y)*12 + int(m) - 1 + int(iv.Months)
+		y, m = months/12, time.Month(months%12+1)
+		if months < 0 {
+			return nil, DatetimeOutOfRange
+		}
+
+		if last := time.Date(y, m+1, 0, 0, 0, 0, 0, time.UTC).Day(); d > last {
+			d = last
+		}
+
+		t = time.Date(y, m, d, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+	}
+
+	t = t.AddDate(0, 0, int(iv.Days))
+	if t.Year() < 1 || t.Year() > 9999 {
+		return nil, DatetimeOutOfRange
+	}
+
+	res := t.UnixMicro() + iv.Micros
+	if (iv.Micros > 0 && res < t.UnixMicro()) || (iv.Micros < 0 && res > t.UnixMicro()) {
+		return nil, DatetimeOutOfRange
+	}
+
+	return checkTimestamp(res)
+}
+
+// commuted tells whether the operands of a commutative operator are swapped
+// so that a date or time comes first, then an interval, then a number.
+func commuted(op symbol, leftType columnType, rightType columnType) bool {
+	rank := func(typ columnType) int {
+		switch typ {
+		case DateType, TimeType, TimestampType:
+			return 0
+		case IntervalType:
+			return 1
+		}
+
+		return 2
+	}
+
+	return (op == Plus || op == Asterisk) && rank(leftType) > rank(rightType)
+}
+
+// datetimeType is the type of the result of an arithmetic operator with a
+// date or time operand, and false when the operator does not apply. A NULL
+// operand is taken to have the type of the other one, or to be a number for
+// intervals multiplied or divided.
+func datetimeType(op symbol, leftType columnType, rightType columnType) (columnType, bool) {
+	if !isDatetime(leftType) && !isDatetime(rightType) {
+		return 0, false
+	}
+
+	if leftType == NullType || rightType == NullType {
+		typ := leftType
+		if typ == NullType {
+			typ = rightType
+		}
+
+		return typ, op == Plus || op == Minus || typ == IntervalType
+	}
+
+	if commuted(op, leftType, rightType) {
+		leftType, rightType = rightType, leftType
+	}
+
+	switch {
+	case op == Plus || op == Minus:
+		switch leftType {
+		case DateType:
+			switch rightType {
+			case IntType:
+				return DateType, true
+			case IntervalType:
+				return TimestampType, true
+			case TimeType:
+				return TimestampType, op == Plus
+			case DateType:
+				return IntType, op == Minus
+			case TimestampType:
+				return IntervalType, op == Minus
+			}
+		case TimestampType:
+			switch rightType {
+			case IntervalType:
+				return TimestampType, true
+			case TimestampType, DateType:
+				return IntervalType, op == Minus
+			}
+		case TimeType:
+			switch rightType {
+			case IntervalType:
+				return TimeType, true
+			case TimeType:
+				return IntervalType, op == Minus
+			case DateType:
+				return TimestampType, op == Plus
+			}
+		case IntervalType:
+			return IntervalType, rightType == IntervalType
+		}
+	case op == Asterisk || op == Slash:
+		if leftType == IntervalType && (isNumeric(rightType) || rightType == NullType) {
+			return IntervalType, true
+		}
+	}
+
+	return 0, false
+}
+
+// datetimeArithmetic applies an operator to non-NULL operands, of which at
+// least one is a date or time, as typed by datetimeType.
+func datetimeArithmetic(op symbol, left memoryCell, leftType columnType, right memoryCell, rightType columnType) (memoryCell, error) {
+	if commuted(op, leftType, rightType) {
+		left, right = right, left
+		leftType, rightType = rightType, leftType
+	}
+
+	// A date is subtracted from or to a timestamp as its midnight
+	if leftType == DateType && rightType == TimestampType {
+		left, _ = castCell(left, leftType, rightType)
+		leftType = TimestampType
+	} else if leftType == TimestampType && rightType == DateType {
+		right, _ = castCell(right, rightType, leftType)
+		rightType = TimestampType
+	}
+
+	switch op {
+	case Asterisk, Slash:
+		f := float64(right.AsInt64())
+		if rightType == FloatType {
+			f = right.AsFloat()
+		}
+
+		if op == Slash {
+			if f == 0 {
+				return nil, DivisionByZero
+			}
+			f = 1 / f
+		}
+
+		iv, err := left.AsInterval().mul(f)
+		if err != nil {
+			return nil, err
+		}
+
+		return intervalCell(iv), nil
+	}
+
+	sign := int64(1)
+	if op == Minus {
+		sign = -1
+	}
+
+	switch leftType {
+	case DateType:
+		switch rightType {
+		case IntType:
+			days := int64(left.AsInt()) + sign*int64(right.AsInt())
+			_, err := checkTimestamp(days * microsPerDay)
+			if err != nil {
+				return nil, err
+			}
+
+			return intCell(int32(days)), nil
+		case DateType:
+			return intCell(left.AsInt() - right.AsInt()), nil
+		case TimeType:
+			return checkTimestamp(int64(left.AsInt())*microsPerDay + right.AsInt64())
+		case IntervalType:
+			iv := right.AsInterval()
+			if op == Minus {
+				iv = iv.negate()
+			}
+
+			return addInterval(int64(left.AsInt())*microsPerDay, iv)
+		}
+	case TimestampType:
+		if rightType == TimestampType {
+			// The difference is in days and microseconds, as in PostgreSQL
+			diff := left.AsInt64() - right.AsInt64()
+			return intervalCell(Interval{Days: int32(diff / microsPerDay), Micros: diff % microsPerDay}), nil
+		}
+
+		iv := right.AsInterval()
+		if op == Minus {
+			iv = iv.negate()
+		}
+
+		return addInterval(left.AsInt64(), iv)
+	case TimeType:
+		switch rightType {
+		case TimeType:
+			return intervalCell(Interval{Micros: left.AsInt64() - right.AsInt64()}), nil
+		case DateType:
+			return checkTimestamp(int64(right.AsInt())*microsPerDay + left.AsInt64())
+		}
+
+		// Times wrap around midnight, and ignore days and months
+		micros := (left.AsInt64() + sign*(right.AsInterval().Micros%microsPerDay)) % microsPerDay
+		if micros < 0 {
+			micros += microsPerDay
+		}
+
+		return bigIntCell(micros), nil
+	case IntervalType:
+		iv := right.AsInterval()
+		if op == Minus {
+			iv = iv.negate()
+		}
+
+		res, err := left.AsInterval().add(iv)
+		if err != nil {
+			return nil, err
+		}
+
+		return intervalCell(res), nil
+	}
+
+	return nil, InvalidOperands
+}
+
+// scalarFunctions are the functions computed on the values of a row, which
+// get the values of their arguments with their types. They must handle NULL
+// arguments, to be typed without any row.
+var scalarFunctions = map[string]func([]memoryCell, []columnType) (memoryCell, columnType, error){
+	"now":        nowFunction,
+	"extract":    extractFunction,
+	"date_trunc": dateTruncFunction,
+}
+
+// nowFunction returns the current local time, as timestamps have no time
+// zone.
+func nowFunction(args []memoryCell, types []columnType) (memoryCell, columnType, error) {
+	if len(args) != 0 {
+		return nil, 0, FunctionDoesNotExist
+	}
+
+	now := time.Now()
+	y, m, d := now.Date()
+	local := time.Date(y, m, d, now.Hour(), now.Minute(), now.Second(), now.Nanosecond(), time.UTC)
+	return timestampCell(local), TimestampType, nil
+}
+
+// extractFunction returns a field of a date or time as a double, as in
+// extract(year FROM ts).
+func extractFunction(args []memoryCell, types []columnType) (memoryCell, columnType, error) {
+	if len(args) != 2 || (types[0] != TextType && types[0] != NullType) || (!isDatetime(types[1]) && types[1] != NullType) {
+		return nil, 0, FunctionDoesNotExist
+	}
+
+	if args[0].IsNull() || args[1].IsNull() {
+		return nil, FloatType, nil
+	}
+
+	field := strings.ToLower(args[0].AsText())
+	if types[1] == IntervalType {
+		res, err := extractInterval(field, args[1].AsInterval())
+		if err != nil {
+			return nil, 0, err
+		}
+
+		return floatCell(res), FloatType, nil
+	}
+
+	t := args[1].AsTime()
+	seconds := float64(t.Second()) + float64(t.Nanosecond())/float64(time.Second)
+	if types[1] == TimeType {
+		switch field {
+		case "hour", "minute", "second", "milliseconds", "microseconds", "epoch":
+		default:
+			return nil, 0, InvalidDatetimeField
+		}
+	}
+
+	var res float64
+	switch field {
+	case "millennium":
+		res = float64((t.Year() + 999) / 1000)
+	case "century":
+		res = float64((t.Year() + 99) / 100)
+	case "decade":
+		res = float64(t.Year() / 10)
+	case "year":
+		res = float64(t.Year())
+	case "quarter":
+		res = float64((int(t.Month())-1)/3 + 1)
+	case "month":
+		res = float64(t.Month())
+	case "week":
+		_, week := t.ISOWeek()
+		res = float64(week)
+	case "day":
+		res = float64(t.Day())
+	case "dow":
+		res = float64(t.Weekday())
+	case "isodow":
+		res = float64((int(t.Weekday())+6)%7 + 1)
+	case "doy":
+		res = float64(t.YearDay())
+	case "hour":
+		res = float64(t.Hour())
+	case "minute":
+		res = float64(t.Minute())
+	case "second":
+		res = seconds
+	case "milliseconds":
+		res = seconds * 1000
+	case "microseconds":
+		res = seconds * 1000000
+	case "epoch":
+		res = float64(t.UnixMicro()) / float64(microsPerSecond)
+	default:
+		return nil, 0, InvalidDatetimeField
+	}
+
+	return floatCell(res), FloatType, nil
+}
+
+func extractInterval(field string, iv Interval) (float64, error) {
+	seconds := float64(iv.Micros%microsPerMinute) / float64(microsPerSecond)
+	switch field {
+	case "millennium":
+		return float64(iv.Months / 12000), nil
+	case "century":
+		return float64(iv.Months / 1200), nil
+	case "decade":
+		return float64(iv.Months / 120), nil
+	case "year":
+		return float64(iv.Months / 12), nil
+	case "quarter":
+		return float64(iv.Months%12/3 + 1), nil
+	case "month":
+		return float64(iv.Months % 12), nil
+	case "day":
+		return float64(iv.Days), nil
+	case "hour":
+		return float64(iv.Micros / microsPerHour), nil
+	case "minute":
+		return float64(iv.Micros % microsPerHour / microsPerMinute), nil
+	case "second":
+		return seconds, nil
+	case "milliseconds":
+		return seconds * 1000, nil
+	case "microseconds":
+		return seconds * 1000000, nil
+	case "epoch":
+		// Years have 365.25 days here, as in PostgreSQL
+		years, months := iv.Months/12, iv.Months%12
+		days := float64(years)*365.25 + float64(months)*daysPerMonth + float64(iv.Days)
+		return days*float64(microsPerDay/microsPerSecond) + float64(iv.Micros)/float64(microsPerSecond), nil
+	}
+
+	return 0, InvalidDatetimeField
+}
+
+// Units that date_trunc truncates to, as a number of months for the larger
+// ones and of microseconds for the smaller ones.
+var (
+	truncMonths = map[string]int32{"millennium": 12000, "century": 1200, "decade": 120, "year": 12, "quarter": 3, "month": 1}
+	truncMicros = map[string]int64{"day": microsPerDay, "hour": microsPerHour, "minute": microsPerMinute, "second": microsPerSecond, "milliseconds": 1000, "microseconds": 1}
+)
+
+// dateTruncFunction truncates a timestamp or an interval to a unit, as in
+// date_trunc('hour', ts). Dates are truncated as timestamps.
+func dateTruncFunction(args []memoryCell, types []columnType) (memoryCell, columnType, error) {
+	if len(args) != 2 || (types[0] != TextType && types[0] != NullType) {
+		return nil, 0, FunctionDoesNotExist
+	}
+
+	typ := types[1]
+	switch typ {
+	case DateType, NullType:
+		typ = TimestampType
+	case TimestampType, IntervalType:
+	default:
+		return nil, 0, FunctionDoesNotExist
+	}
+
+	if args[0].IsNull() || args[1].IsNull() {
+		return nil, typ, nil
+	}
+
+	field := strings.ToLower(args[0].AsText())
+	months, isMonths := truncMonths[field]
+	micros, isMicros := truncMicros[field]
+	if !isMonths && !isMicros && (field != "week" || typ == IntervalType) {
+		return nil, 0, InvalidDatetimeField
+	}
+
+	if typ == IntervalType {
+		iv := args[1].AsInterval()
+		if isMonths {
+			return intervalCell(Interval{Months: iv.Months / months * months}), typ, nil
+		}
+
+		if field == "day" {
+			return intervalCell(Interval{Months: iv.Months, Days: iv.Days}), typ, nil
+		}
+
+		return intervalCell(Interval{Months: iv.Months, Days: iv.Days, Micros: iv.Micros / micros * micros}), typ, nil
+	}
+
+	t := args[1].AsTime()
+	switch {
+	case isMonths:
+		// Millennia and centuries start on years ending with 1
+		y, m := t.Year(), int(t.Month())-1
+		switch field {
+		case "millennium":
+			y, m = (y-1)/1000*1000+1, 0
+		case "century":
+			y, m = (y-1)/100*100+1, 0
+		case "decade":
+			y, m = y/10*10, 0
+		case "year":
+			m = 0
+		case "quarter":
+			m = m / 3 * 3
+		}
+
+		t = time.Date(y, time.Month(m+1), 1, 0, 0, 0, 0, time.UTC)
+	case field == "week":
+		// Weeks start on Monday
+		t = time.Date(t.Year(), t.Month(), t.Day()-(int(t.Weekday())+6)%7, 0, 0, 0, 0, time.UTC)
+	default:
+		us := t.UnixMicro()
+		rem := us % micros
+		if rem < 0 {
+			rem += micros
+		}
+		t = time.UnixMicro(us - rem).UTC()
+	}
+
+	if t.Year() < 1 {
+		return nil, 0, DatetimeOutOfRange
+	}
+
+	return timestampCell(t), typ, nil
+}
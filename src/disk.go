@@ -0,0 +1,935 @@
+package src
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+const (
+	pageSize         = 4096
+	pageHeaderSize   = 2 // used bytes in the page, uint16
+	recordHeaderSize = 3 // kind (byte) and payload length (uint16)
+	diskMagic        = "GODB"
+	diskVersion      = uint32(6)
+
+	// Marks a NULL cell in a row record, as opposed to an empty one.
+	nilCellLength = ^uint32(0)
+)
+
+type recordKind byte
+
+const (
+	tableRecord recordKind = iota + 1
+	indexRecord
+	rowRecord
+)
+
+// DiskBackend is a Backend that keeps its tables in a page-based data file so
+// that they survive a restart. Statements are evaluated by an in-memory
+// backend.
+//
+// The changes of every committed transaction are appended to a write-ahead
+// log next to the data file, as a single entry so that a transaction is
+// replayed whole or not at all. Rows are logged by value along with their
+// position, so that replaying them does not evaluate anything again, while
+// statements that define tables and indexes are logged as code. The data file itself is only
+// written at checkpoints, which write the pages holding the rows changed
+// since the last one, after which the log is emptied. On startup the data
+// file is loaded and the transactions logged since the last checkpoint are
+// replayed on top of it.
+//
+// The data file starts with a header page (magic, version, page count,
+// checkpoint lsn) followed by data pages. Every data page begins with the
+// number of bytes in use and holds whole records: either table and index
+// definitions, or rows of a single table along with their positions.
+type DiskBackend struct {
+	mb            *MemoryBackend
+	pager         *pager
+	wal           *wal
+	checkpointLsn uint64
+
+	// Held while a transaction commits, so that transactions are logged in
+	// the order they commit in memory, and while a checkpoint runs.
+	// Transactions wait for each other through the locks of the in-memory
+	// backend, like they do without a DiskBackend.
+	lock sync.Mutex
+
+	// Transactions running with changes to the definition of the tables.
+	// Checkpoints wait for them to end, as the catalog in the data file
+	// only holds committed tables and indexes.
+	definitions map[*Transaction]bool
+}
+
+// Number of log entries after which the changes are written to the data
+// file.
+const checkpointInterval = 1000
+
+func NewDiskBackend(path string) (*DiskBackend, error) {
+	db := &DiskBackend{
+		mb:          NewMemoryBackend(),
+		definitions: map[*Transaction]bool{},
+	}
+
+	var err error
+	db.pager, err = openPager(path)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.load()
+	if err != nil {
+		db.pager.close()
+		return nil, err
+	}
+
+	db.wal, err = openWal(path + ".wal")
+	if err != nil {
+		db.pager.close()
+		return nil, err
+	}
+
+	db.wal.lsn = db.checkpointLsn
+	err = db.wal.replay(func(lsn uint64, payload []byte) error {
+		if lsn <= db.checkpointLsn {
+			return nil
+		}
+
+		tx, err := db.mb.Begin()
+		if err != nil {
+			return err
+		}
+
+		// Only changes that were made are logged, so they can be made
+		// again on the same tables, unless the log does not belong to them
+		err = db.replay(tx, bytes.NewBuffer(payload))
+		if err != nil {
+			db.mb.Rollback(tx)
+			return CorruptedLog
+		}
+
+		err = db.mb.Commit(tx)
+		if err != nil {
+			return err
+		}
+
+		db.pager.change(tx.changes)
+		return nil
+	})
+	if err == nil {
+		// The data file and its journal may just have been created
+		err = syncDir(filepath.Dir(path))
+	}
+
+	if err != nil {
+		db.wal.close()
+		db.pager.close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+func (db *DiskBackend) Begin() (*Transaction, error) {
+	return db.mb.Begin()
+}
+
+// Commit writes the changes of a transaction to the log before it is
+// committed in memory. A transaction that conflicts with another one is
+// rolled back before anything is logged.
+func (db *DiskBackend) Commit(tx *Transaction) error {
+	if tx.done {
+		return TransactionClosed
+	}
+
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	delete(db.definitions, tx)
+
+	// The rows of an aborted transaction are rolled back
+	if tx.aborted || len(tx.changes) == 0 {
+		changes := tx.changes
+		err := db.mb.Commit(tx)
+		db.pager.change(changes)
+		return err
+	}
+
+	// No other transaction commits until this one did, so it cannot
+	// conflict once checked
+	if db.mb.conflicts(tx) {
+		changes := tx.changes
+		db.mb.Rollback(tx)
+		db.pager.change(changes)
+		return SerializationFailure
+	}
+
+	_, err := db.wal.append(encodeChanges(tx.changes))
+	if err != nil {
+		changes := tx.changes
+		db.mb.Rollback(tx)
+		db.pager.change(changes)
+		return err
+	}
+
+	err = db.mb.Commit(tx)
+	if err != nil {
+		return err
+	}
+	db.pager.change(tx.changes)
+
+	return db.maybeCheckpoint()
+}
+
+func (db *DiskBackend) Rollback(tx *Transaction) error {
+	if tx.done {
+		return TransactionClosed
+	}
+
+	db.lock.Lock()
+	delete(db.definitions, tx)
+	db.lock.Unlock()
+
+	changes := tx.changes
+	err := db.mb.Rollback(tx)
+
+	db.lock.Lock()
+	db.pager.change(changes)
+	db.lock.Unlock()
+
+	return err
+}
+
+// Savepoints only change the transaction, and rolling back to one drops the
+// changes made since.
+func (db *DiskBackend) Savepoint(tx *Transaction, sp *SavepointStatement) error {
+	return db.mb.Savepoint(tx, sp)
+}
+
+func (db *DiskBackend) RollbackToSavepoint(tx *Transaction, sp *SavepointStatement) error {
+	return db.mb.RollbackToSavepoint(tx, sp)
+}
+
+func (db *DiskBackend) ReleaseSavepoint(tx *Transaction, sp *SavepointStatement) error {
+	return db.mb.ReleaseSavepoint(tx, sp)
+}
+
+// run runs a statement in tx, or in a transaction of its own when tx is nil.
+func (db *DiskBackend) run(tx *Transaction, fn func(tx *Transaction) error) error {
+	if tx != nil {
+		return fn(tx)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	err = fn(tx)
+	if err != nil {
+		db.Rollback(tx)
+		return err
+	}
+
+	return db.Commit(tx)
+}
+
+func (db *DiskBackend) CreateTable(tx *Transaction, crt *CreateTableStatement) error {
+	return db.define(tx, &Statement{Kind: CreateAstKind, Create: crt}, func(tx *Transaction) error {
+		return db.mb.CreateTable(tx, crt)
+	})
+}
+
+func (db *DiskBackend) CreateIndex(tx *Transaction, ci *CreateIndexStatement) error {
+	return db.define(tx, &Statement{Kind: CreateIndexAstKind, CreateIndex: ci}, func(tx *Transaction) error {
+		return db.mb.CreateIndex(tx, ci)
+	})
+}
+
+// Rows inserted, updated and deleted are recorded by the in-memory backend
+// itself.
+func (db *DiskBackend) Insert(tx *Transaction, inst *InsertStatement) error {
+	return db.run(tx, func(tx *Transaction) error {
+		return db.mb.Insert(tx, inst)
+	})
+}
+
+func (db *DiskBackend) Update(tx *Transaction, upd *UpdateStatement) (uint, error) {
+	var count uint
+	err := db.run(tx, func(tx *Transaction) error {
+		var err error
+		count, err = db.mb.Update(tx, upd)
+		return err
+	})
+
+	return count, err
+}
+
+func (db *DiskBackend) Delete(tx *Transaction, del *DeleteStatement) (uint, error) {
+	var count uint
+	err := db.run(tx, func(tx *Transaction) error {
+		var err error
+		count, err = db.mb.Delete(tx, del)
+		return err
+	})
+
+	return count, err
+}
+
+func (db *DiskBackend) DropTable(tx *Transaction, dt *DropTableStatement) error {
+	return db.define(tx, &Statement{Kind: DropTableAstKind, DropTable: dt}, func(tx *Transaction) error {
+		return db.mb.DropTable(tx, dt)
+	})
+}
+
+func (db *DiskBackend) DropIndex(tx *Transaction, di *DropIndexStatement) error {
+	return db.define(tx, &Statement{Kind: DropIndexAstKind, DropIndex: di}, func(tx *Transaction) error {
+		return db.mb.DropIndex(tx, di)
+	})
+}
+
+func (db *DiskBackend) Truncate(tx *Transaction, trunc *TruncateStatement) error {
+	return db.run(tx, func(tx *Transaction) error {
+		return db.mb.Truncate(tx, trunc)
+	})
+}
+
+func (db *DiskBackend) Select(tx *Transaction, slct *SelectStatement) (*Results, error) {
+	return db.mb.Select(tx, slct)
+}
+
+// Close checkpoints the backend and releases its files. The changes of the
+// transactions still running are lost.
+func (db *DiskBackend) Close() error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	err := db.checkpoint()
+	if err != nil {
+		db.wal.close()
+		db.pager.close()
+		return err
+	}
+
+	db.wal.close()
+	return db.pager.close()
+}
+
+// define runs a statement that changes the definition of the tables in tx,
+// and records it to be written to the write-ahead log when tx commits.
+// Checkpoints are put off from before the statement runs, so that none
+// writes out the change before it is logged.
+func (db *DiskBackend) define(tx *Transaction, stmt *Statement, fn func(tx *Transaction) error) error {
+	return db.run(tx, func(tx *Transaction) error {
+		db.lock.Lock()
+		db.definitions[tx] = true
+		db.lock.Unlock()
+
+		err := fn(tx)
+		if err != nil {
+			return err
+		}
+
+		tx.change(change{statement: stmt.generateCode() + ";"})
+		return nil
+	})
+}
+
+type changeKind byte
+
+const (
+	statementChange changeKind = iota + 1
+	insertChange
+	deleteChange
+)
+
+// encodeChanges lays out the changes of a transaction for the write-ahead
+// log, in the order they were made. Every change starts with its kind. A
+// statement is its code, preceded by its length (uint32), and a row change
+// names the table and the position of the row (uint32), followed for an
+// insert by the values of the row.
+func encodeChanges(changes []change) []byte {
+	buf := new(bytes.Buffer)
+	for _, c := range changes {
+		if c.row.table == nil {
+			buf.WriteByte(byte(statementChange))
+			binary.Write(buf, binary.BigEndian, uint32(len(c.statement)))
+			buf.WriteString(c.statement)
+			continue
+		}
+
+		t := c.row.table
+		if c.deleted {
+			buf.WriteByte(byte(deleteChange))
+		} else {
+			buf.WriteByte(byte(insertChange))
+		}
+
+		writeString(buf, t.name)
+		binary.Write(buf, binary.BigEndian, uint32(c.row.rowIndex))
+		if !c.deleted {
+			writeRow(buf, t.row(c.row.rowIndex))
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// replay makes the changes of a log entry again in tx.
+func (db *DiskBackend) replay(tx *Transaction, r *bytes.Buffer) error {
+	for r.Len() > 0 {
+		kind, _ := r.ReadByte()
+		if changeKind(kind) == statementChange {
+			var length uint32
+			if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+				return CorruptedLog
+			}
+
+			code := r.Next(int(length))
+			if len(code) != int(length) {
+				return CorruptedLog
+			}
+
+			a, err := Parse(string(code))
+			if err != nil || len(a.Statements) != 1 {
+				return CorruptedLog
+			}
+
+			err = db.apply(tx, a.Statements[0])
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		tableName, err := readString(r)
+		if err != nil {
+			return err
+		}
+
+		t, ok := db.mb.tables[tableName]
+		if !ok {
+			return CorruptedLog
+		}
+
+		var rowIndex uint32
+		if err = binary.Read(r, binary.BigEndian, &rowIndex); err != nil {
+			return CorruptedLog
+		}
+
+		exists := uint(rowIndex) < uint(len(t.rows)) && t.rows[rowIndex] != nil
+		switch changeKind(kind) {
+		case insertChange:
+			row, err := readRow(r)
+			if err != nil {
+				return err
+			}
+
+			if exists || len(row) != len(t.columns) {
+				return CorruptedLog
+			}
+
+			err = t.placeRow(tx, uint(rowIndex), row)
+			if err != nil {
+				return err
+			}
+		case deleteChange:
+			if !exists || t.xmax[rowIndex] != 0 {
+				return CorruptedLog
+			}
+
+			tx.deleteRow(t, uint(rowIndex))
+		default:
+			return CorruptedLog
+		}
+	}
+
+	return nil
+}
+
+// apply runs a statement read back from the write-ahead log.
+func (db *DiskBackend) apply(tx *Transaction, stmt *Statement) error {
+	switch stmt.Kind {
+	case CreateAstKind:
+		return db.mb.CreateTable(tx, stmt.Create)
+	case CreateIndexAstKind:
+		return db.mb.CreateIndex(tx, stmt.CreateIndex)
+	case DropTableAstKind:
+		return db.mb.DropTable(tx, stmt.DropTable)
+	case DropIndexAstKind:
+		return db.mb.DropIndex(tx, stmt.DropIndex)
+	}
+
+	return CorruptedLog
+}
+
+func (db *DiskBackend) maybeCheckpoint() error {
+	if db.wal.lsn-db.checkpointLsn >= checkpointInterval {
+		return db.checkpoint()
+	}
+
+	return nil
+}
+
+// checkpoint writes the rows changed since the last checkpoint to the data
+// file, after which the logged changes are no longer needed. It is put off
+// while a running transaction changed the definition of the tables.
+func (db *DiskBackend) checkpoint() error {
+	if db.wal.lsn == db.checkpointLsn || len(db.definitions) > 0 {
+		return nil
+	}
+
+	err := db.pager.checkpoint(db.mb, db.wal.lsn)
+	if err != nil {
+		return err
+	}
+
+	db.checkpointLsn = db.wal.lsn
+	return db.wal.reset()
+}
+
+// load reads the tables of the data file, and what each of its pages holds.
+// Tables are created first, as rows and indexes refer to them, and indexes
+// last, so that each is built once over all the rows.
+func (db *DiskBackend) load() error {
+	p := db.pager
+	info, err := p.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if info.Size() == 0 {
+		return nil
+	}
+
+	header, err := p.read(0)
+	if err != nil {
+		return err
+	}
+
+	if string(header[:len(diskMagic)]) != diskMagic {
+		return CorruptedDataFile
+	}
+
+	version := binary.BigEndian.Uint32(header[4:])
+	if version != diskVersion {
+		return CorruptedDataFile
+	}
+
+	pageCount := binary.BigEndian.Uint32(header[8:])
+	if pageCount == 0 {
+		return CorruptedDataFile
+	}
+
+	db.checkpointLsn = binary.BigEndian.Uint64(header[12:])
+	p.pages = make([]*dataPage, pageCount)
+
+	type rowPage struct {
+		no      uint32
+		records [][]byte
+	}
+	rowPages := []rowPage{}
+	indexes := [][]byte{}
+	for no := uint32(1); no < pageCount; no++ {
+		page, err := p.read(no)
+		if err != nil {
+			return err
+		}
+
+		used := binary.BigEndian.Uint16(page)
+		if int(used) > pageSize-pageHeaderSize {
+			return CorruptedDataFile
+		}
+
+		if used == 0 {
+			p.free = append(p.free, no)
+			continue
+		}
+
+		records, err := splitRecords(page[pageHeaderSize : pageHeaderSize+int(used)])
+		if err != nil {
+			return err
+		}
+
+		if recordKind(records[0][0]) == rowRecord {
+			rowPages = append(rowPages, rowPage{no, records})
+			continue
+		}
+
+		p.pages[no] = &dataPage{used: int(used)}
+		p.catalog = append(p.catalog, no)
+		for _, record := range records {
+			p.catalogRecords = append(p.catalogRecords, record)
+			switch recordKind(record[0]) {
+			case tableRecord:
+				err = db.loadTable(bytes.NewBuffer(record[recordHeaderSize:]))
+				if err != nil {
+					return err
+				}
+			case indexRecord:
+				indexes = append(indexes, record)
+			default:
+				return CorruptedDataFile
+			}
+		}
+	}
+
+	for _, rp := range rowPages {
+		page := &dataPage{rows: map[uint]int{}}
+		for _, record := range rp.records {
+			if recordKind(record[0]) != rowRecord {
+				return CorruptedDataFile
+			}
+
+			t, rowIndex, err := db.loadRow(bytes.NewBuffer(record[recordHeaderSize:]))
+			if err != nil {
+				return err
+			}
+
+			// Rows of a page all belong to the same table
+			if page.table == nil {
+				page.table = t
+				if p.tables[t] == nil {
+					p.tables[t] = &tablePages{rows: map[uint]uint32{}}
+				}
+				p.tables[t].pages = append(p.tables[t].pages, rp.no)
+			} else if page.table != t {
+				return CorruptedDataFile
+			}
+
+			page.rows[rowIndex] = len(record)
+			page.used += len(record)
+			p.tables[t].rows[rowIndex] = rp.no
+		}
+
+		p.pages[rp.no] = page
+	}
+
+	for _, record := range indexes {
+		err = db.loadIndex(bytes.NewBuffer(record[recordHeaderSize:]))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// splitRecords returns the records in the used part of a page.
+func splitRecords(data []byte) ([][]byte, error) {
+	records := [][]byte{}
+	for len(data) > 0 {
+		if len(data) < recordHeaderSize {
+			return nil, CorruptedDataFile
+		}
+
+		length := recordHeaderSize + int(binary.BigEndian.Uint16(data[1:]))
+		if len(data) < length {
+			return nil, CorruptedDataFile
+		}
+
+		records = append(records, data[:length])
+		data = data[length:]
+	}
+
+	return records, nil
+}
+
+func (db *DiskBackend) loadTable(r *bytes.Buffer) error {
+	t := newTable()
+	var err error
+	if t.name, err = readString(r); err != nil {
+		return err
+	}
+
+	var count uint16
+	if err = binary.Read(r, binary.BigEndian, &count); err != nil {
+		return CorruptedDataFile
+	}
+
+	for i := uint16(0); i < count; i++ {
+		name, err := readString(r)
+		if err != nil {
+			return err
+		}
+
+		typ, err := r.ReadByte()
+		if err != nil {
+			return CorruptedDataFile
+		}
+
+		var maxLength uint32
+		if err = binary.Read(r, binary.BigEndian, &maxLength); err != nil {
+			return CorruptedDataFile
+		}
+
+		notNull, err := r.ReadByte()
+		if err != nil {
+			return CorruptedDataFile
+		}
+
+		// Empty when the column has no default
+		code, err := readString(r)
+		if err != nil {
+			return err
+		}
+
+		var def *expression
+		if code != "" {
+			def, err = parseExpressionSource(code)
+			if err != nil {
+				return CorruptedDataFile
+			}
+		}
+
+		t.columns = append(t.columns, name)
+		t.columnTypes = append(t.columnTypes, columnType(typ))
+		t.lengths = append(t.lengths, int(maxLength))
+		t.notNull = append(t.notNull, notNull != 0)
+		t.defaults = append(t.defaults, def)
+	}
+
+	db.mb.tables[t.name] = t
+	return nil
+}
+
+func (db *DiskBackend) loadIndex(r *bytes.Buffer) error {
+	tableName, err := readString(r)
+	if err != nil {
+		return err
+	}
+
+	name, err := readString(r)
+	if err != nil {
+		return err
+	}
+
+	flags, err := r.ReadByte()
+	if err != nil {
+		return CorruptedDataFile
+	}
+
+	code, err := readString(r)
+	if err != nil {
+		return err
+	}
+
+	exp, err := parseExpressionSource(code)
+	if err != nil {
+		return CorruptedDataFile
+	}
+
+	err = db.mb.CreateIndex(nil, &CreateIndexStatement{
+		table:      token{value: tableName},
+		name:       token{value: name},
+		unique:     flags&1 != 0,
+		primaryKey: flags&2 != 0,
+		exp:        *exp,
+	})
+	if err != nil {
+		return CorruptedDataFile
+	}
+
+	return nil
+}
+
+// loadRow puts a row back at its position in its table.
+func (db *DiskBackend) loadRow(r *bytes.Buffer) (*table, uint, error) {
+	tableName, err := readString(r)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	t, ok := db.mb.tables[tableName]
+	if !ok {
+		return nil, 0, CorruptedDataFile
+	}
+
+	var rowIndex uint32
+	if err = binary.Read(r, binary.BigEndian, &rowIndex); err != nil {
+		return nil, 0, CorruptedDataFile
+	}
+
+	row, err := readRow(r)
+	if err != nil {
+		return nil, 0, CorruptedDataFile
+	}
+
+	if len(row) != len(t.columns) || (uint(rowIndex) < uint(len(t.rows)) && t.rows[rowIndex] != nil) {
+		return nil, 0, CorruptedDataFile
+	}
+
+	if err = t.placeRow(nil, uint(rowIndex), row); err != nil {
+		return nil, 0, CorruptedDataFile
+	}
+
+	return t, uint(rowIndex), nil
+}
+
+// catalogRecords returns the records of every table definition, each
+// followed by the records of its indexes. Tables are in name order so that
+// the records only change along with the tables.
+func catalogRecords(mb *MemoryBackend) ([][]byte, error) {
+	names := []string{}
+	for name := range mb.tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	records := [][]byte{}
+	for _, name := range names {
+		t := mb.tables[name]
+
+		buf := new(bytes.Buffer)
+		writeString(buf, t.name)
+		binary.Write(buf, binary.BigEndian, uint16(len(t.columns)))
+		for i, col := range t.columns {
+			writeString(buf, col)
+			buf.WriteByte(byte(t.columnTypes[i]))
+			binary.Write(buf, binary.BigEndian, uint32(t.lengths[i]))
+			if t.notNull[i] {
+				buf.WriteByte(1)
+			} else {
+				buf.WriteByte(0)
+			}
+
+			code := ""
+			if t.defaults[i] != nil {
+				code = t.defaults[i].generateCode()
+			}
+			writeString(buf, code)
+		}
+
+		record, err := newRecord(tableRecord, buf.Bytes())
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+
+		for _, index := range t.indexes {
+			var flags byte
+			if index.unique {
+				flags |= 1
+			}
+			if index.primaryKey {
+				flags |= 2
+			}
+
+			buf := new(bytes.Buffer)
+			writeString(buf, t.name)
+			writeString(buf, index.name)
+			buf.WriteByte(flags)
+			writeString(buf, index.exp.generateCode())
+
+			record, err := newRecord(indexRecord, buf.Bytes())
+			if err != nil {
+				return nil, err
+			}
+			records = append(records, record)
+		}
+	}
+
+	return records, nil
+}
+
+// rowRecordOf returns the record of a row, which holds its position.
+func rowRecordOf(t *table, rowIndex uint) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	writeString(buf, t.name)
+	binary.Write(buf, binary.BigEndian, uint32(rowIndex))
+	writeRow(buf, t.rows[rowIndex])
+
+	return newRecord(rowRecord, buf.Bytes())
+}
+
+// writeRow writes the number of cells of a row (uint16), then every cell
+// preceded by its length (uint32).
+func writeRow(buf *bytes.Buffer, row []memoryCell) {
+	binary.Write(buf, binary.BigEndian, uint16(len(row)))
+	for _, cell := range row {
+		if cell == nil {
+			binary.Write(buf, binary.BigEndian, nilCellLength)
+			continue
+		}
+
+		binary.Write(buf, binary.BigEndian, uint32(len(cell)))
+		buf.Write(cell)
+	}
+}
+
+func readRow(r *bytes.Buffer) ([]memoryCell, error) {
+	var count uint16
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return nil, CorruptedDataFile
+	}
+
+	row := []memoryCell{}
+	for i := uint16(0); i < count; i++ {
+		var length uint32
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+			return nil, CorruptedDataFile
+		}
+
+		if length == nilCellLength {
+			row = append(row, nil)
+			continue
+		}
+
+		cell := r.Next(int(length))
+		if len(cell) != int(length) {
+			return nil, CorruptedDataFile
+		}
+
+		row = append(row, memoryCell(append([]byte{}, cell...)))
+	}
+
+	return row, nil
+}
+
+// newRecord prefixes a payload with its kind and length. A record must fit
+// in a page.
+func newRecord(kind recordKind, payload []byte) ([]byte, error) {
+	record := append([]byte{byte(kind), 0, 0}, payload...)
+	if len(record) > pageSize-pageHeaderSize || len(payload) > int(^uint16(0)) {
+		return nil, RecordTooLarge
+	}
+	binary.BigEndian.PutUint16(record[1:], uint16(len(payload)))
+
+	return record, nil
+}
+
+func newPage() []byte {
+	return make([]byte, pageSize)
+}
+
+func writeString(buf *bytes.Buffer, s string) {
+	binary.Write(buf, binary.BigEndian, uint16(len(s)))
+	buf.WriteString(s)
+}
+
+func readString(buf *bytes.Buffer) (string, error) {
+	var length uint16
+	if err := binary.Read(buf, binary.BigEndian, &length); err != nil {
+		return "", CorruptedDataFile
+	}
+
+	s := buf.Next(int(length))
+	if len(s) != int(length) {
+		return "", CorruptedDataFile
+	}
+
+	return string(s), nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
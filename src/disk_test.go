@@ -0,0 +1,663 @@
+package src
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// crash releases the files of a backend without checkpointing it, as when
+// the process dies.
+func crash(db *DiskBackend) {
+	db.wal.close()
+	db.pager.close()
+}
+
+func TestDiskBackend_reopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	db, err := NewDiskBackend(path)
+	assert.Nil(t, err)
+
+	a, err := Parse("CREATE TABLE users (id INT PRIMARY KEY, name TEXT); INSERT INTO users VALUES (1, 'Alice'); INSERT INTO users VALUES (2, 'Robert');")
+	assert.Nil(t, err)
+	assert.Nil(t, db.CreateTable(nil, a.Statements[0].Create))
+	assert.Nil(t, db.Insert(nil, a.Statements[1].Insert))
+	assert.Nil(t, db.Insert(nil, a.Statements[2].Insert))
+
+	a, err = Parse("UPDATE users SET name = 'Bob' WHERE name = 'Robert';")
+	assert.Nil(t, err)
+	count, err := db.Update(nil, a.Statements[0].Update)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(1), count)
+	assert.Nil(t, db.Close())
+
+	db, err = NewDiskBackend(path)
+	assert.Nil(t, err)
+
+	a, err = Parse("SELECT id, name FROM users;")
+	assert.Nil(t, err)
+	results, err := db.Select(nil, a.Statements[0].Select)
+	assert.Nil(t, err)
+
+	assert.Equal(t, []ResultsColumn{{IntType, "id"}, {TextType, "name"}}, results.Columns)
+	assert.Equal(t, 2, len(results.Rows))
+	assert.Equal(t, int32(1), results.Rows[0][0].AsInt())
+	assert.Equal(t, "Alice", results.Rows[0][1].AsText())
+	assert.Equal(t, int32(2), results.Rows[1][0].AsInt())
+	assert.Equal(t, "Bob", results.Rows[1][1].AsText())
+
+	assert.Equal(t, 1, len(db.mb.tables["users"].indexes))
+	assert.Equal(t, TableAlreadyExists, db.CreateTable(nil, &CreateTableStatement{name: token{value: "users"}}))
+	assert.Nil(t, db.Close())
+}
+
+func TestDiskBackend_manyPages(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	db, err := NewDiskBackend(path)
+	assert.Nil(t, err)
+
+	a, err := Parse("CREATE TABLE t (a INT, b TEXT); INSERT INTO t VALUES (7, 'some text that takes up space in a page');")
+	assert.Nil(t, err)
+	assert.Nil(t, db.CreateTable(nil, a.Statements[0].Create))
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Insert(nil, a.Statements[1].Insert))
+	}
+	assert.Nil(t, db.Close())
+
+	db, err = NewDiskBackend(path)
+	assert.Nil(t, err)
+	assert.Equal(t, 500, len(db.mb.tables["t"].rows))
+	assert.Nil(t, db.Close())
+}
+
+func TestDiskBackend_dirtyPages(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	db, err := NewDiskBackend(path)
+	assert.Nil(t, err)
+
+	a, err := Parse("CREATE TABLE t (a INT, b TEXT);")
+	assert.Nil(t, err)
+	assert.Nil(t, db.CreateTable(nil, a.Statements[0].Create))
+	for i := 0; i < 500; i++ {
+		a, err = Parse(fmt.Sprintf("INSERT INTO t VALUES (%d, 'some text that takes up space in a page');", i))
+		assert.Nil(t, err)
+		assert.Nil(t, db.Insert(nil, a.Statements[0].Insert))
+	}
+	assert.Nil(t, db.Close())
+
+	before, err := os.ReadFile(path)
+	assert.Nil(t, err)
+
+	db, err = NewDiskBackend(path)
+	assert.Nil(t, err)
+
+	a, err = Parse("UPDATE t SET b = 'changed' WHERE a = 250;")
+	assert.Nil(t, err)
+	count, err := db.Update(nil, a.Statements[0].Update)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(1), count)
+	assert.Nil(t, db.Close())
+
+	after, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, len(before), len(after))
+	assert.Less(t, 5, len(after)/pageSize)
+
+	// The header, the page the old version was on and the page the new
+	// one went to
+	changed := 0
+	for offset := 0; offset < len(after); offset += pageSize {
+		if !bytes.Equal(before[offset:offset+pageSize], after[offset:offset+pageSize]) {
+			changed++
+		}
+	}
+	assert.LessOrEqual(t, changed, 3)
+
+	info, err := os.Stat(path + ".journal")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), info.Size())
+
+	db, err = NewDiskBackend(path)
+	assert.Nil(t, err)
+
+	a, err = Parse("SELECT b FROM t WHERE a = 250; SELECT a FROM t;")
+	assert.Nil(t, err)
+	results, err := db.Select(nil, a.Statements[0].Select)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(results.Rows))
+	assert.Equal(t, "changed", results.Rows[0][0].AsText())
+
+	results, err = db.Select(nil, a.Statements[1].Select)
+	assert.Nil(t, err)
+	assert.Equal(t, 500, len(results.Rows))
+	assert.Nil(t, db.Close())
+}
+
+func TestDiskBackend_journal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	db, err := NewDiskBackend(path)
+	assert.Nil(t, err)
+
+	a, err := Parse("CREATE TABLE t (a INT); INSERT INTO t VALUES (1); INSERT INTO t VALUES (2);")
+	assert.Nil(t, err)
+	assert.Nil(t, db.CreateTable(nil, a.Statements[0].Create))
+	assert.Nil(t, db.Insert(nil, a.Statements[1].Insert))
+	assert.Nil(t, db.Close())
+
+	before, err := os.ReadFile(path)
+	assert.Nil(t, err)
+
+	db, err = NewDiskBackend(path)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Insert(nil, a.Statements[2].Insert))
+	assert.Nil(t, db.Close())
+
+	after, err := os.ReadFile(path)
+	assert.Nil(t, err)
+
+	// A crash once the journal of the second checkpoint was synced, but
+	// before the data file was written
+	journal := new(bytes.Buffer)
+	count := 0
+	for offset := 0; offset < len(after); offset += pageSize {
+		if offset < len(before) && bytes.Equal(before[offset:offset+pageSize], after[offset:offset+pageSize]) {
+			continue
+		}
+
+		no := make([]byte, 4)
+		binary.BigEndian.PutUint32(no, uint32(offset/pageSize))
+		journal.Write(no)
+		journal.Write(after[offset : offset+pageSize])
+		count++
+	}
+
+	trailer := make([]byte, journalTrailerSize)
+	binary.BigEndian.PutUint32(trailer, uint32(count))
+	binary.BigEndian.PutUint32(trailer[4:], crc32.ChecksumIEEE(journal.Bytes()))
+
+	tests := []struct {
+		name    string
+		journal []byte
+		rows    int
+	}{
+		{"complete journal", append(journal.Bytes(), trailer...), 2},
+		{"torn journal", journal.Bytes()[:journal.Len()-100], 1},
+	}
+
+	for _, test := range tests {
+		assert.Nil(t, os.WriteFile(path, before, 0644), test.name)
+		assert.Nil(t, os.WriteFile(path+".journal", test.journal, 0644), test.name)
+
+		db, err = NewDiskBackend(path)
+		assert.Nil(t, err, test.name)
+
+		a, err = Parse("SELECT a FROM t;")
+		assert.Nil(t, err, test.name)
+		results, err := db.Select(nil, a.Statements[0].Select)
+		assert.Nil(t, err, test.name)
+		assert.Equal(t, test.rows, len(results.Rows), test.name)
+		assert.Nil(t, db.Close(), test.name)
+
+		info, err := os.Stat(path + ".journal")
+		assert.Nil(t, err, test.name)
+		assert.Equal(t, int64(0), info.Size(), test.name)
+	}
+}
+
+func TestDiskBackend_checkpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	db, err := NewDiskBackend(path)
+	assert.Nil(t, err)
+
+	a, err := Parse("CREATE TABLE t (a INT, b TEXT); INSERT INTO t VALUES (1, 'a b');")
+	assert.Nil(t, err)
+	assert.Nil(t, db.CreateTable(nil, a.Statements[0].Create))
+	assert.Nil(t, db.Insert(nil, a.Statements[1].Insert))
+	assert.Nil(t, db.Close())
+
+	info, err := os.Stat(path + ".wal")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), info.Size())
+
+	db, err = NewDiskBackend(path)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(db.mb.tables["t"].rows))
+	assert.Equal(t, "a b", db.mb.tables["t"].rows[0][1].AsText())
+	assert.Equal(t, uint64(2), db.wal.lsn)
+	assert.Nil(t, db.Close())
+}
+
+func TestDiskBackend_checkpointFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	db, err := NewDiskBackend(path)
+	assert.Nil(t, err)
+
+	_, err = execute(t, db, "CREATE TABLE t (a INT); INSERT INTO t VALUES (1);")
+	assert.Nil(t, err)
+
+	var tx *Transaction
+	_, err = executeIn(t, db, &tx, "BEGIN; INSERT INTO t VALUES (2);")
+	assert.Nil(t, err)
+	_, err = execute(t, db, "INSERT INTO t VALUES (3);")
+	assert.Nil(t, err)
+
+	// The journal cannot be written, so every row is written again at the
+	// next checkpoint, but the uncommitted one
+	db.pager.journal.Close()
+	db.lock.Lock()
+	assert.NotNil(t, db.checkpoint())
+	db.lock.Unlock()
+
+	db.pager.journal, err = os.OpenFile(path+".journal", os.O_RDWR, 0644)
+	assert.Nil(t, err)
+	_, err = execute(t, db, "INSERT INTO t VALUES (4);")
+	assert.Nil(t, err)
+	db.lock.Lock()
+	assert.Nil(t, db.checkpoint())
+	db.lock.Unlock()
+
+	_, err = executeIn(t, db, &tx, "ROLLBACK;")
+	assert.Nil(t, err)
+
+	crash(db)
+	db, err = NewDiskBackend(path)
+	assert.Nil(t, err)
+
+	results, err := execute(t, db, "SELECT a FROM t ORDER BY a;")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(results.Rows))
+	assert.Equal(t, int32(1), results.Rows[0][0].AsInt())
+	assert.Equal(t, int32(3), results.Rows[1][0].AsInt())
+	assert.Equal(t, int32(4), results.Rows[2][0].AsInt())
+	assert.Nil(t, db.Close())
+}
+
+func TestDiskBackend_recovery(t *testing.T) {
+	tests := []struct {
+		name   string
+		damage func(path string)
+	}{
+		{
+			name: "truncated last record",
+			damage: func(path string) {
+				info, _ := os.Stat(path)
+				os.Truncate(path, info.Size()-3)
+			},
+		},
+		{
+			name: "truncated header of last record",
+			damage: func(path string) {
+				f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+				f.Write([]byte{0, 0, 0})
+				f.Close()
+			},
+		},
+		{
+			name: "torn last record",
+			damage: func(path string) {
+				f, _ := os.OpenFile(path, os.O_RDWR, 0644)
+				info, _ := f.Stat()
+				f.WriteAt([]byte{'X'}, info.Size()-2)
+				f.Close()
+			},
+		},
+	}
+
+	for _, test := range tests {
+		path := filepath.Join(t.TempDir(), "test.db")
+
+		db, err := NewDiskBackend(path)
+		assert.Nil(t, err, test.name)
+
+		a, err := Parse("CREATE TABLE t (a INT); INSERT INTO t VALUES (1); INSERT INTO t VALUES (2); INSERT INTO t VALUES (3);")
+		assert.Nil(t, err, test.name)
+		assert.Nil(t, db.CreateTable(nil, a.Statements[0].Create), test.name)
+		assert.Nil(t, db.Insert(nil, a.Statements[1].Insert), test.name)
+		assert.Nil(t, db.Insert(nil, a.Statements[2].Insert), test.name)
+
+		// The process dies without closing the backend
+		crash(db)
+		test.damage(path + ".wal")
+
+		db, err = NewDiskBackend(path)
+		assert.Nil(t, err, test.name)
+
+		// Only the entries before the damaged one are replayed
+		expected := 1
+		if test.name == "truncated header of last record" {
+			expected = 2
+		}
+		assert.Equal(t, expected, len(db.mb.tables["t"].rows), test.name)
+
+		// New entries go right after the last complete one
+		assert.Nil(t, db.Insert(nil, a.Statements[3].Insert), test.name)
+		crash(db)
+
+		db, err = NewDiskBackend(path)
+		assert.Nil(t, err, test.name)
+		rows := db.mb.tables["t"].rows
+		assert.Equal(t, expected+1, len(rows), test.name)
+		assert.Equal(t, int32(3), rows[len(rows)-1][0].AsInt(), test.name)
+		assert.Nil(t, db.Close(), test.name)
+	}
+}
+
+func TestDiskBackend_replayFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	db, err := NewDiskBackend(path)
+	assert.Nil(t, err)
+
+	_, err = execute(t, db, "CREATE TABLE t (a INT PRIMARY KEY); INSERT INTO t VALUES (1);")
+	assert.Nil(t, err)
+
+	// A committed transaction that cannot be replayed is not dropped
+	logged := &table{name: "t", rows: [][]memoryCell{nil, {intCell(2)}, {intCell(1)}}}
+	_, err = db.wal.append(encodeChanges([]change{
+		{row: rowRef{table: logged, rowIndex: 1}},
+		{row: rowRef{table: logged, rowIndex: 2}},
+	}))
+	assert.Nil(t, err)
+	crash(db)
+
+	_, err = NewDiskBackend(path)
+	assert.Equal(t, CorruptedLog, err)
+}
+
+func TestDiskBackend_volatileValues(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	db, err := NewDiskBackend(path)
+	assert.Nil(t, err)
+
+	_, err = execute(t, db, "CREATE TABLE t (a TIMESTAMP DEFAULT now(), b TIMESTAMP); INSERT INTO t (b) VALUES (now()); INSERT INTO t (b) SELECT now();")
+	assert.Nil(t, err)
+
+	before, err := execute(t, db, "SELECT a, b FROM t;")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(before.Rows))
+
+	// Replaying the log must not evaluate now() again, which would give
+	// later values
+	time.Sleep(10 * time.Millisecond)
+	crash(db)
+
+	db, err = NewDiskBackend(path)
+	assert.Nil(t, err)
+
+	after, err := execute(t, db, "SELECT a, b FROM t;")
+	assert.Nil(t, err)
+	assert.Equal(t, before.Rows, after.Rows)
+	assert.Nil(t, db.Close())
+}
+
+func TestDiskBackend_indexes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	db, err := NewDiskBackend(path)
+	assert.Nil(t, err)
+
+	_, err = execute(t, db, "CREATE TABLE t (a INT, b TEXT); INSERT INTO t VALUES (1, 'x'); INSERT INTO t VALUES (2, 'y'); CREATE INDEX t_b ON t (b);")
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close())
+
+	db, err = NewDiskBackend(path)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, db.mb.tables["t"].indexes[0].tree.Len())
+
+	results, err := execute(t, db, "SELECT a FROM t WHERE b = 'y';")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(results.Rows))
+	assert.Equal(t, int32(2), results.Rows[0][0].AsInt())
+	assert.Nil(t, db.Close())
+}
+
+func TestDiskBackend_null(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	db, err := NewDiskBackend(path)
+	assert.Nil(t, err)
+
+	_, err = execute(t, db, "CREATE TABLE t (a INT NOT NULL, b TEXT); INSERT INTO t VALUES (1, NULL); INSERT INTO t VALUES (2, '');")
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close())
+
+	db, err = NewDiskBackend(path)
+	assert.Nil(t, err)
+
+	rows := db.mb.tables["t"].rows
+	assert.True(t, rows[0][1].IsNull())
+	assert.False(t, rows[1][1].IsNull())
+
+	_, err = execute(t, db, "INSERT INTO t VALUES (NULL, 'x');")
+	assert.Equal(t, ViolatesNonNullConstraint, err)
+	assert.Nil(t, db.Close())
+}
+
+func TestDiskBackend_insertRows(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	db, err := NewDiskBackend(path)
+	assert.Nil(t, err)
+
+	_, err = execute(t, db, "CREATE TABLE t (id INT PRIMARY KEY, name TEXT DEFAULT 'it''s'); INSERT INTO t (id) VALUES (1), (-2); CREATE TABLE copy (id INT, name TEXT, flag INT DEFAULT 7);")
+	assert.Nil(t, err)
+
+	_, err = execute(t, db, "INSERT INTO copy (id, name) SELECT id, name FROM t; INSERT INTO copy (id, name) SELECT NULL, name FROM t WHERE id < 0; INSERT INTO copy SELECT 1, name, 1 FROM t WHERE id > 100;")
+	assert.Nil(t, err)
+
+	check := func(db *DiskBackend) {
+		results, err := execute(t, db, "SELECT id, name, flag FROM copy;")
+		assert.Nil(t, err)
+		assert.Equal(t, 3, len(results.Rows))
+		assert.Equal(t, int32(-2), results.Rows[1][0].AsInt())
+		assert.True(t, results.Rows[2][0].IsNull())
+		for _, row := range results.Rows {
+			assert.Equal(t, "it's", row[1].AsText())
+			assert.Equal(t, int32(7), row[2].AsInt())
+		}
+	}
+
+	// Replayed from the log
+	crash(db)
+	db, err = NewDiskBackend(path)
+	assert.Nil(t, err)
+	check(db)
+
+	// Loaded from the data file, defaults included
+	assert.Nil(t, db.Close())
+	db, err = NewDiskBackend(path)
+	assert.Nil(t, err)
+	check(db)
+
+	_, err = execute(t, db, "INSERT INTO t (id) VALUES (3); SELECT name FROM t WHERE id = 3;")
+	assert.Nil(t, err)
+	assert.Equal(t, "it's", db.mb.tables["t"].rows[2][1].AsText())
+	assert.Nil(t, db.Close())
+}
+
+func TestDiskBackend_subqueries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	db, err := NewDiskBackend(path)
+	assert.Nil(t, err)
+
+	_, err = execute(t, db, "CREATE TABLE users (id INT PRIMARY KEY, name TEXT); INSERT INTO users VALUES (1, 'alice'), (2, 'bob'), (3, 'carol'); CREATE TABLE orders (user_id INT, total INT); INSERT INTO orders VALUES (1, 5), (2, 7);")
+	assert.Nil(t, err)
+
+	_, err = execute(t, db, "UPDATE users SET name = (SELECT 'big ' || name FROM users u WHERE u.id = users.id) WHERE id IN (SELECT user_id FROM orders WHERE total > 6); DELETE FROM users WHERE NOT EXISTS (SELECT 1 FROM orders o WHERE o.user_id = users.id);")
+	assert.Nil(t, err)
+
+	// Statements with subqueries are replayed from the log
+	crash(db)
+	db, err = NewDiskBackend(path)
+	assert.Nil(t, err)
+
+	results, err := execute(t, db, "SELECT name FROM users ORDER BY id;")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(results.Rows))
+	assert.Equal(t, "alice", results.Rows[0][0].AsText())
+	assert.Equal(t, "big bob", results.Rows[1][0].AsText())
+	assert.Nil(t, db.Close())
+}
+
+func TestDiskBackend_types(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	db, err := NewDiskBackend(path)
+	assert.Nil(t, err)
+
+	_, err = execute(t, db, "CREATE TABLE t (a BIGINT PRIMARY KEY, b DOUBLE, c BOOLEAN, d VARCHAR(3)); INSERT INTO t VALUES (5000000000, -2.5, true, 'abc'), (-1, 1e300, false, NULL);")
+	assert.Nil(t, err)
+
+	_, err = execute(t, db, "CREATE TABLE copy (a BIGINT, b DOUBLE); INSERT INTO copy SELECT a, b FROM t;")
+	assert.Nil(t, err)
+
+	_, err = execute(t, db, "CREATE TABLE events (d DATE, ts TIMESTAMP, iv INTERVAL); INSERT INTO events VALUES ('2024-02-29', TIMESTAMP '2024-02-29 12:00' + INTERVAL '1 year', '1 mon -2 days');")
+	assert.Nil(t, err)
+
+	check := func(db *DiskBackend) {
+		results, err := execute(t, db, "SELECT a, b, c FROM t WHERE a = 5000000000;")
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(results.Rows))
+		assert.Equal(t, int64(5000000000), results.Rows[0][0].AsInt64())
+		assert.Equal(t, -2.5, results.Rows[0][1].AsFloat())
+		assert.True(t, results.Rows[0][2].AsBool())
+
+		results, err = execute(t, db, "SELECT a, b FROM copy ORDER BY b;")
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(results.Rows))
+		assert.Equal(t, 1e300, results.Rows[1][1].AsFloat())
+
+		_, err = execute(t, db, "INSERT INTO t (a, d) VALUES (1, 'abcd');")
+		assert.Equal(t, ValueTooLong, err)
+
+		results, err = execute(t, db, "SELECT d, ts, iv FROM events WHERE ts > '2025-01-01';")
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(results.Rows))
+		assert.Equal(t, "2024-02-29", results.Rows[0][0].AsTime().Format(DateFormat))
+		assert.Equal(t, "2025-02-28T12:00:00", results.Rows[0][1].AsTime().Format(TimestampFormat))
+		assert.Equal(t, Interval{Months: 1, Days: -2}, results.Rows[0][2].AsInterval())
+	}
+
+	// Replayed from the log
+	crash(db)
+	db, err = NewDiskBackend(path)
+	assert.Nil(t, err)
+	check(db)
+
+	// Loaded from the data file
+	assert.Nil(t, db.Close())
+	db, err = NewDiskBackend(path)
+	assert.Nil(t, err)
+	check(db)
+	assert.Nil(t, db.Close())
+}
+
+func TestDiskBackend_transactions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	db, err := NewDiskBackend(path)
+	assert.Nil(t, err)
+
+	_, err = execute(t, db, "CREATE TABLE t (id INT PRIMARY KEY);")
+	assert.Nil(t, err)
+	lsn := db.wal.lsn
+
+	// Only committed transactions are logged, as one entry each
+	var tx *Transaction
+	_, err = executeIn(t, db, &tx, "BEGIN; INSERT INTO t VALUES (1); INSERT INTO t VALUES (2); ROLLBACK;")
+	assert.Nil(t, err)
+	assert.Equal(t, lsn, db.wal.lsn)
+
+	_, err = executeIn(t, db, &tx, "BEGIN; INSERT INTO t VALUES (3); INSERT INTO t VALUES (3);")
+	assert.Equal(t, ViolatesUniqueConstraint, err)
+	_, err = executeIn(t, db, &tx, "COMMIT;")
+	assert.Equal(t, TransactionAborted, err)
+	assert.Equal(t, lsn, db.wal.lsn)
+
+	_, err = executeIn(t, db, &tx, "BEGIN; INSERT INTO t VALUES (4); INSERT INTO t VALUES (4); INSERT INTO t VALUES (5);")
+	assert.Equal(t, ViolatesUniqueConstraint, err)
+	_, err = executeIn(t, db, &tx, "ROLLBACK; BEGIN; INSERT INTO t VALUES (6); UPDATE t SET id = id + 1; COMMIT;")
+	assert.Nil(t, err)
+	assert.Equal(t, lsn+1, db.wal.lsn)
+
+	// Changes rolled back to a savepoint are left out of the entry
+	_, err = executeIn(t, db, &tx, "BEGIN; INSERT INTO t VALUES (8); SAVEPOINT a; INSERT INTO t VALUES (9); INSERT INTO t VALUES (9);")
+	assert.Equal(t, ViolatesUniqueConstraint, err)
+	_, err = executeIn(t, db, &tx, "ROLLBACK TO SAVEPOINT a; INSERT INTO t VALUES (10); COMMIT;")
+	assert.Nil(t, err)
+	assert.Equal(t, lsn+2, db.wal.lsn)
+
+	crash(db)
+	db, err = NewDiskBackend(path)
+	assert.Nil(t, err)
+
+	results, err := execute(t, db, "SELECT id FROM t ORDER BY id;")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(results.Rows))
+	assert.Equal(t, int32(7), results.Rows[0][0].AsInt())
+	assert.Equal(t, int32(8), results.Rows[1][0].AsInt())
+	assert.Equal(t, int32(10), results.Rows[2][0].AsInt())
+	assert.Nil(t, db.Close())
+}
+
+func TestDiskBackend_concurrentTransactions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	db, err := NewDiskBackend(path)
+	assert.Nil(t, err)
+
+	_, err = execute(t, db, "CREATE TABLE t (id INT PRIMARY KEY, n INT); INSERT INTO t VALUES (1, 10);")
+	assert.Nil(t, err)
+
+	// A running transaction does not keep others from beginning and
+	// committing, and they wait for its rows through the locks, with their
+	// timeout
+	var a, b *Transaction
+	_, err = executeIn(t, db, &a, "BEGIN; UPDATE t SET n = 11 WHERE id = 1; CREATE TABLE u (id INT);")
+	assert.Nil(t, err)
+	_, err = executeIn(t, db, &b, "BEGIN; INSERT INTO t VALUES (2, 20);")
+	assert.Nil(t, err)
+	b.LockTimeout = 10 * time.Millisecond
+	_, err = executeIn(t, db, &b, "UPDATE t SET n = 12 WHERE id = 1;")
+	assert.Equal(t, LockWaitTimeout, err)
+	_, err = executeIn(t, db, &b, "ROLLBACK; BEGIN; INSERT INTO t VALUES (3, 30); COMMIT;")
+	assert.Nil(t, err)
+	_, err = execute(t, db, "INSERT INTO t VALUES (4, 40);")
+	assert.Nil(t, err)
+
+	// The data file cannot hold the table of a transaction that did not
+	// commit, so it is left to the log
+	assert.Nil(t, db.Close())
+
+	db, err = NewDiskBackend(path)
+	assert.Nil(t, err)
+
+	results, err := execute(t, db, "SELECT id, n FROM t ORDER BY id;")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(results.Rows))
+	assert.Equal(t, int32(10), results.Rows[0][1].AsInt())
+	assert.Equal(t, int32(3), results.Rows[1][0].AsInt())
+	assert.Equal(t, int32(4), results.Rows[2][0].AsInt())
+	_, err = execute(t, db, "SELECT id FROM u;")
+	assert.Equal(t, TableDoesNotExists, err)
+	assert.Nil(t, db.Close())
+
+	info, err := os.Stat(path + ".wal")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), info.Size())
+}
@@ -18,12 +18,56 @@ const (
 	Values     keyword = "values"
 	Int        keyword = "int"
 	Text       keyword = "text"
+	Bigint     keyword = "bigint"
+	Boolean    keyword = "boolean"
+	Double     keyword = "double"
+	Float      keyword = "float"
+	Varchar    keyword = "varchar"
 	Where      keyword = "where"
 	And        keyword = "and"
 	Or         keyword = "or"
 	True       keyword = "true"
 	False      keyword = "false"
 	PrimaryKey keyword = "primary key"
+	Update     keyword = "update"
+	Set        keyword = "set"
+	Delete     keyword = "delete"
+	Drop       keyword = "drop"
+	Index      keyword = "index"
+	If         keyword = "if"
+	Exists     keyword = "exists"
+	Truncate   keyword = "truncate"
+	Unique     keyword = "unique"
+	On         keyword = "on"
+	Not        keyword = "not"
+	Null       keyword = "null"
+	Is         keyword = "is"
+	Order      keyword = "order"
+	By         keyword = "by"
+	Asc        keyword = "asc"
+	Desc       keyword = "desc"
+	Nulls      keyword = "nulls"
+	Limit      keyword = "limit"
+	Offset     keyword = "offset"
+	Group      keyword = "group"
+	Having     keyword = "having"
+	Join       keyword = "join"
+	Inner      keyword = "inner"
+	Left       keyword = "left"
+	Right      keyword = "right"
+	Full       keyword = "full"
+	Outer      keyword = "outer"
+	Cross      keyword = "cross"
+	Default    keyword = "default"
+	Begin      keyword = "begin"
+	Commit     keyword = "commit"
+	Rollback   keyword = "rollback"
+	Savepoint  keyword = "savepoint"
+	Release    keyword = "release"
+	To         keyword = "to"
+	For        keyword = "for"
+	Share      keyword = "share"
+	In         keyword = "in"
 )
 
 func (k keyword) toToken() token {
@@ -46,9 +90,14 @@ const (
 	Greater        symbol = ">"
 	GreaterOrEqual symbol = ">="
 	Less           symbol = "<"
-	LessOrEqual           = "<="
+	LessOrEqual    symbol = "<="
+	NotEqual       symbol = "<>" // lexed as XEqual
 	Concat         symbol = "||"
 	Plus           symbol = "+"
+	Minus          symbol = "-"
+	Slash          symbol = "/"
+	Percent        symbol = "%"
+	Dot            symbol = "."
 )
 
 func (s symbol) toToken() token {
@@ -0,0 +1,237 @@
+package src
+
+// fromTable returns the table a SELECT reads from, whose expressions are
+// evaluated in scope. Stored tables are read through a view sharing their
+// rows, renamed by their alias if any, while joins and subqueries are
+// materialized into new tables.
+func (mb *MemoryBackend) fromTable(scope *queryScope, from *fromItem) (*table, error) {
+	if from.join != nil {
+		return mb.join(scope, from.join)
+	}
+
+	if from.subquery != nil {
+		return mb.derivedTable(scope, from)
+	}
+
+	// Rows are read from a snapshot, the lock only keeps the table from
+	// being dropped or truncated meanwhile
+	t, err := mb.lockedTable(scope.tx, from.table.value, intentSharedLock)
+	if err != nil {
+		return nil, err
+	}
+
+	view := t.view(scope)
+	if from.as != nil {
+		view.name = from.as.value
+	}
+
+	return view, nil
+}
+
+// join builds the rows of a join. When the ON condition compares columns of
+// both sides for equality, the rows of the right side are looked up in a hash
+// table by the compared values, and otherwise every pair of rows is tried.
+func (mb *MemoryBackend) join(scope *queryScope, j *joinExpression) (*table, error) {
+	left, err := mb.fromTable(scope, &j.a)
+	if err != nil {
+		return nil, err
+	}
+
+	right, err := mb.fromTable(scope, &j.b)
+	if err != nil {
+		return nil, err
+	}
+
+	joined := &table{scope: scope}
+	names := map[string]bool{}
+	for _, side := range []*table{left, right} {
+		sideNames := map[string]bool{}
+		for i, col := range side.columns {
+			joined.columns = append(joined.columns, col)
+			joined.columnTypes = append(joined.columnTypes, side.columnTypes[i])
+			joined.qualifiers = append(joined.qualifiers, side.qualifier(i))
+			sideNames[side.qualifier(i)] = true
+		}
+
+		for name := range sideNames {
+			if names[name] {
+				return nil, DuplicateTableName
+			}
+			names[name] = true
+		}
+	}
+
+	if j.on != nil {
+		typ, err := joined.expressionType(*j.on)
+		if err != nil {
+			return nil, err
+		}
+
+		if typ != BoolType && typ != NullType {
+			return nil, InvalidOperands
+		}
+	}
+
+	leftRows, err := left.candidateRows(scope.tx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	rightRows, err := right.candidateRows(scope.tx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := func(leftRow uint) ([]uint, error) {
+		return rightRows, nil
+	}
+
+	leftKeys, rightKeys := equiJoinKeys(left, right, j.on)
+	if len(leftKeys) > 0 {
+		hashed, err := hashRows(right, rightRows, rightKeys)
+		if err != nil {
+			return nil, err
+		}
+
+		candidates = func(leftRow uint) ([]uint, error) {
+			key, ok, err := joinKey(left, leftRow, leftKeys)
+			if err != nil || !ok {
+				return nil, err
+			}
+
+			return hashed[string(key)], nil
+		}
+	}
+
+	matchedRight := map[uint]bool{}
+	for _, l := range leftRows {
+		rows, err := candidates(l)
+		if err != nil {
+			return nil, err
+		}
+
+		matched := false
+		for _, r := range rows {
+			joined.rows = append(joined.rows, joinRows(left.row(l), right.row(r)))
+			if j.on != nil {
+				val, _, _, err := joined.evaluateCell(uint(len(joined.rows)-1), *j.on)
+				if err != nil {
+					return nil, err
+				}
+
+				if !val.AsBool() {
+					joined.rows = joined.rows[:len(joined.rows)-1]
+					continue
+				}
+			}
+
+			matched = true
+			matchedRight[r] = true
+		}
+
+		if !matched && (j.kind == leftJoin || j.kind == fullJoin) {
+			joined.rows = append(joined.rows, joinRows(left.row(l), make([]memoryCell, len(right.columns))))
+		}
+	}
+
+	if j.kind == rightJoin || j.kind == fullJoin {
+		for _, r := range rightRows {
+			if !matchedRight[r] {
+				joined.rows = append(joined.rows, joinRows(make([]memoryCell, len(left.columns)), right.row(r)))
+			}
+		}
+	}
+
+	return joined, nil
+}
+
+func joinRows(a []memoryCell, b []memoryCell) []memoryCell {
+	row := make([]memoryCell, 0, len(a)+len(b))
+	row = append(row, a...)
+	return append(row, b...)
+}
+
+// equiJoinKeys finds the equalities of an ON condition between an expression
+// on the left side only and one of the same type on the right side only.
+func equiJoinKeys(left *table, right *table, on *expression) ([]expression, []expression) {
+	if on == nil || on.kind != binaryKind {
+		return nil, nil
+	}
+
+	if on.binary.op.value == string(And) {
+		leftKeys, rightKeys := equiJoinKeys(left, right, &on.binary.a)
+		moreLeftKeys, moreRightKeys := equiJoinKeys(left, right, &on.binary.b)
+		return append(leftKeys, moreLeftKeys...), append(rightKeys, moreRightKeys...)
+	}
+
+	if on.binary.op.value != string(Equal) {
+		return nil, nil
+	}
+
+	a, b := on.binary.a, on.binary.b
+	if !onlyIn(left, right, a) {
+		a, b = b, a
+	}
+
+	if !onlyIn(left, right, a) || !onlyIn(right, left, b) {
+		return nil, nil
+	}
+
+	// Rows are hashed by the bytes of their keys, which differ for values of
+	// different types and for equal intervals
+	leftType, _ := left.expressionType(a)
+	rightType, _ := right.expressionType(b)
+	if leftType != rightType || leftType == IntervalType {
+		return nil, nil
+	}
+
+	return []expression{a}, []expression{b}
+}
+
+// onlyIn tells whether an expression can be evaluated on the rows of t, but
+// not on the rows of other.
+func onlyIn(t *table, other *table, exp expression) bool {
+	_, err := t.expressionType(exp)
+	if err != nil {
+		return false
+	}
+
+	_, err = other.expressionType(exp)
+	return err != nil
+}
+
+// hashRows indexes rows by the values of the keys. Rows with a NULL key are
+// left out, as they cannot be equal to anything.
+func hashRows(t *table, rows []uint, keys []expression) (map[string][]uint, error) {
+	hashed := map[string][]uint{}
+	for _, r := range rows {
+		key, ok, err := joinKey(t, r, keys)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			hashed[string(key)] = append(hashed[string(key)], r)
+		}
+	}
+
+	return hashed, nil
+}
+
+func joinKey(t *table, rowIndex uint, keys []expression) ([]byte, bool, error) {
+	key := []byte{}
+	for _, exp := range keys {
+		value, _, _, err := t.evaluateCell(rowIndex, exp)
+		if err != nil {
+			return nil, false, err
+		}
+
+		if value.IsNull() {
+			return nil, false, nil
+		}
+
+		key = appendGroupKey(key, value)
+	}
+
+	return key, true, nil
+}
@@ -139,11 +139,11 @@ func lexCharacterDelimited(source string, ic cursor, delimiter byte) (*token, cu
 					loc:   ic.loc,
 					kind:  StringKind,
 				}, cur, true
-			} else {
-				value = append(value, delimiter)
-				cur.pointer++
-				cur.loc.col++
 			}
+
+			// Only one of the doubled characters is kept
+			cur.pointer++
+			cur.loc.col++
 		}
 
 		value = append(value, c)
@@ -176,13 +176,22 @@ func lexSymbol(source string, ic cursor) (*token, cursor, bool) {
 	symbols := []symbol{
 		Equal,
 		XEqual,
+		NotEqual,
+		Greater,
+		GreaterOrEqual,
+		Less,
+		LessOrEqual,
 		Concat,
 		Plus,
+		Minus,
+		Slash,
+		Percent,
 		Comma,
 		LeftParen,
 		RightParen,
 		SemiColon,
 		Asterisk,
+		Dot,
 	}
 
 	var options []string
@@ -195,9 +204,19 @@ func lexSymbol(source string, ic cursor) (*token, cursor, bool) {
 		return nil, ic, false
 	}
 
+	// A period followed by a digit starts a number, like .5
+	if end := ic.pointer + 1; match == string(Dot) && end < uint(len(source)) && source[end] >= '0' && source[end] <= '9' {
+		return nil, ic, false
+	}
+
 	cur.pointer = ic.pointer + uint(len(match))
 	cur.loc.col = ic.loc.col + uint(len(match))
 
+	// Both spellings of inequality are handled as one operator
+	if match == string(NotEqual) {
+		match = string(XEqual)
+	}
+
 	return &token{
 		value: match,
 		loc:   ic.loc,
@@ -219,11 +238,55 @@ func lexKeyword(source string, ic cursor) (*token, cursor, bool) {
 		Values,
 		Int,
 		Text,
+		Bigint,
+		Boolean,
+		Double,
+		Float,
+		Varchar,
 		And,
 		Or,
 		True,
 		False,
 		PrimaryKey,
+		Update,
+		Set,
+		Delete,
+		Drop,
+		Index,
+		If,
+		Exists,
+		Truncate,
+		Unique,
+		On,
+		Not,
+		Null,
+		Is,
+		Order,
+		By,
+		Asc,
+		Desc,
+		Nulls,
+		Limit,
+		Offset,
+		Group,
+		Having,
+		Join,
+		Inner,
+		Left,
+		Right,
+		Full,
+		Outer,
+		Cross,
+		Default,
+		Begin,
+		Commit,
+		Rollback,
+		Savepoint,
+		Release,
+		To,
+		For,
+		Share,
+		In,
 	}
 
 	var options []string
@@ -236,12 +299,19 @@ func lexKeyword(source string, ic cursor) (*token, cursor, bool) {
 		return nil, ic, false
 	}
 
+	// A keyword must not be the prefix of an identifier, like "as" in "ask".
+	if end := ic.pointer + uint(len(match)); end < uint(len(source)) && isIdentifierChar(source[end]) {
+		return nil, ic, false
+	}
+
 	cur.pointer = ic.pointer + uint(len(match))
 	cur.loc.col = ic.loc.col + uint(len(match))
 
 	kind := KeywordKind
 	if match == string(True) || match == string(False) {
 		kind = BoolKind
+	} else if match == string(Null) {
+		kind = NullKind
 	}
 
 	return &token{
@@ -253,6 +323,7 @@ func lexKeyword(source string, ic cursor) (*token, cursor, bool) {
 
 func lexIdentifier(source string, ic cursor) (*token, cursor, bool) {
 	if token, newCursor, ok := lexCharacterDelimited(source, ic, '"'); ok {
+		token.kind = IdentifierKind
 		return token, newCursor, true
 	}
 
@@ -271,9 +342,7 @@ func lexIdentifier(source string, ic cursor) (*token, cursor, bool) {
 	for ; cur.pointer < uint(len(source)); cur.pointer++ {
 		c = source[cur.pointer]
 
-		isAlphabetical := (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z')
-		isNumeric := c >= '0' && c <= '9'
-		if isAlphabetical || isNumeric || c == '$' || c == '_' {
+		if isIdentifierChar(c) {
 			value = append(value, c)
 			cur.loc.col++
 			continue
@@ -293,6 +362,12 @@ func lexIdentifier(source string, ic cursor) (*token, cursor, bool) {
 	}, cur, true
 }
 
+func isIdentifierChar(c byte) bool {
+	isAlphabetical := (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z')
+	isNumeric := c >= '0' && c <= '9'
+	return isAlphabetical || isNumeric || c == '$' || c == '_'
+}
+
 // longestMatch iter through a source string starting at the given cursor to find
 // the longest matching option among the provided options.
 //
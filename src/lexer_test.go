@@ -133,7 +133,7 @@ func TestToken_lexString(t *testing.T) {
 		assert.Equal(t, test.string, ok, test.value)
 		if ok {
 			test.value = strings.TrimSpace(test.value)
-			assert.Equal(t, test.value[1:len(test.value)-1], tok.value, test.value)
+			assert.Equal(t, strings.ReplaceAll(test.value[1:len(test.value)-1], "''", "'"), tok.value, test.value)
 		}
 	}
 }
@@ -151,6 +151,51 @@ func TestToken_lexSymbol(t *testing.T) {
 			symbol: true,
 			value:  "*",
 		},
+		{
+			symbol: true,
+			value:  ">",
+		},
+		{
+			symbol: true,
+			value:  ">=",
+		},
+		{
+			symbol: true,
+			value:  "<",
+		},
+		{
+			symbol: true,
+			value:  "<= ",
+		},
+		{
+			symbol: true,
+			value:  "!=",
+		},
+		{
+			symbol: true,
+			value:  "-",
+		},
+		{
+			symbol: true,
+			value:  "/",
+		},
+		{
+			symbol: true,
+			value:  "%",
+		},
+		{
+			symbol: true,
+			value:  ".",
+		},
+		// false tests
+		{
+			symbol: false,
+			value:  "!",
+		},
+		{
+			symbol: false,
+			value:  ".5",
+		},
 	}
 
 	for _, test := range tests {
@@ -204,6 +249,11 @@ func TestToken_lexIdentifier(t *testing.T) {
 			input:      `"userName"`,
 			value:      "userName",
 		},
+		{
+			Identifier: true,
+			input:      `"a""b"`,
+			value:      `a"b`,
+		},
 		// false tests
 		{
 			Identifier: false,
@@ -228,6 +278,7 @@ func TestToken_lexIdentifier(t *testing.T) {
 		assert.Equal(t, test.Identifier, ok, test.input)
 		if ok {
 			assert.Equal(t, test.value, tok.value, test.input)
+			assert.Equal(t, IdentifierKind, tok.kind, test.input)
 		}
 	}
 }
@@ -266,6 +317,14 @@ func TestToken_lexKeyword(t *testing.T) {
 			keyword: false,
 			value:   "flubbrety",
 		},
+		{
+			keyword: false,
+			value:   "settings",
+		},
+		{
+			keyword: false,
+			value:   "as_of",
+		},
 	}
 
 	for _, test := range tests {
@@ -421,6 +480,52 @@ func TestLex(t *testing.T) {
 			},
 			err: nil,
 		},
+		{
+			input: "a<>d and not b<=c",
+			Tokens: []token{
+				{
+					loc:   location{col: 0, line: 0},
+					value: "a",
+					kind:  IdentifierKind,
+				},
+				{
+					loc:   location{col: 1, line: 0},
+					value: string(XEqual),
+					kind:  SymbolKind,
+				},
+				{
+					loc:   location{col: 3, line: 0},
+					value: "d",
+					kind:  IdentifierKind,
+				},
+				{
+					loc:   location{col: 5, line: 0},
+					value: string(And),
+					kind:  KeywordKind,
+				},
+				{
+					loc:   location{col: 9, line: 0},
+					value: string(Not),
+					kind:  KeywordKind,
+				},
+				{
+					loc:   location{col: 13, line: 0},
+					value: "b",
+					kind:  IdentifierKind,
+				},
+				{
+					loc:   location{col: 14, line: 0},
+					value: string(LessOrEqual),
+					kind:  SymbolKind,
+				},
+				{
+					loc:   location{col: 16, line: 0},
+					value: "c",
+					kind:  IdentifierKind,
+				},
+			},
+			err: nil,
+		},
 		{
 			input: "SELECT id FROM users;",
 			Tokens: []token{
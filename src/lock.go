@@ -0,0 +1,353 @@
+package src
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Writers lock the tables and rows they change, and hold the locks until
+// their transaction ends. Readers see a snapshot and only lock the tables they
+// read, to keep them from being dropped, unless they lock rows with SELECT ...
+// FOR UPDATE or FOR SHARE.
+//
+// Tables are locked in intent modes by transactions that lock some of their
+// rows, and in shared or exclusive mode by statements that act on every row.
+type lockMode uint8
+
+const (
+	intentSharedLock lockMode = 1 << iota
+	intentExclusiveLock
+	sharedLock
+	exclusiveLock
+)
+
+// lockConflicts are the modes held by other transactions that keep a mode
+// from being granted.
+var lockConflicts = map[lockMode]lockMode{
+	intentSharedLock:    exclusiveLock,
+	intentExclusiveLock: sharedLock | exclusiveLock,
+	sharedLock:          intentExclusiveLock | exclusiveLock,
+	exclusiveLock:       intentSharedLock | intentExclusiveLock | sharedLock | exclusiveLock,
+}
+
+// lockCovers are the modes that already grant at least as much as a mode.
+var lockCovers = map[lockMode]lockMode{
+	intentSharedLock:    intentSharedLock | intentExclusiveLock | sharedLock | exclusiveLock,
+	intentExclusiveLock: intentExclusiveLock | exclusiveLock,
+	sharedLock:          sharedLock | exclusiveLock,
+	exclusiveLock:       exclusiveLock,
+}
+
+// A lockTag names a table, or one of its rows.
+type lockTag struct {
+	table    string
+	row      bool
+	rowIndex uint
+}
+
+// A lockRequest is a transaction waiting for a lock. It is woken up whenever
+// locks are released, or with err set when it is chosen to break a deadlock.
+type lockRequest struct {
+	tag  lockTag
+	mode lockMode
+	wake chan struct{}
+	err  error
+}
+
+func (r *lockRequest) signal() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// A lockManager keeps the locks held by transactions, by their id, and the
+// requests of the transactions waiting for one. Waiting transactions and the
+// ones they wait for make up the waits-for graph, which is checked for
+// cycles every time a transaction starts waiting.
+type lockManager struct {
+	mu      sync.Mutex
+	holders map[lockTag]map[uint64]lockMode
+	held    map[uint64][]lockTag
+	waiting map[uint64]*lockRequest
+}
+
+func newLockManager() *lockManager {
+	return &lockManager{
+		holders: map[lockTag]map[uint64]lockMode{},
+		held:    map[uint64][]lockTag{},
+		waiting: map[uint64]*lockRequest{},
+	}
+}
+
+// tryLock grants a lock to a transaction if no other one holds a conflicting
+// mode.
+func (lm *lockManager) tryLock(id uint64, tag lockTag, mode lockMode) bool {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	return lm.grant(id, tag, mode)
+}
+
+func (lm *lockManager) grant(id uint64, tag lockTag, mode lockMode) bool {
+	holders := lm.holders[tag]
+	if holders[id]&lockCovers[mode] != 0 {
+		return true
+	}
+
+	if len(lm.blockers(id, tag, mode)) > 0 {
+		return false
+	}
+
+	if holders == nil {
+		holders = map[uint64]lockMode{}
+		lm.holders[tag] = holders
+	}
+
+	if holders[id] == 0 {
+		lm.held[id] = append(lm.held[id], tag)
+	}
+
+	holders[id] |= mode
+	return true
+}
+
+// blockers returns the other transactions that hold a mode of a lock that
+// conflicts with the one requested.
+func (lm *lockManager) blockers(id uint64, tag lockTag, mode lockMode) []uint64 {
+	ids := []uint64{}
+	for other, held := range lm.holders[tag] {
+		if other != id && held&lockConflicts[mode] != 0 {
+			ids = append(ids, other)
+		}
+	}
+
+	return ids
+}
+
+// wait blocks until tx is granted a lock. It fails with DeadlockDetected if
+// tx is the victim of a deadlock, and with LockWaitTimeout once the lock
+// timeout of tx is over.
+func (lm *lockManager) wait(tx *Transaction, tag lockTag, mode lockMode) error {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	req := &lockRequest{tag: tag, mode: mode, wake: make(chan struct{}, 1)}
+	lm.waiting[tx.id] = req
+	defer delete(lm.waiting, tx.id)
+
+	var timeout <-chan time.Time
+	if tx.LockTimeout > 0 {
+		timer := time.NewTimer(tx.LockTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	for {
+		if req.err != nil {
+			return req.err
+		}
+
+		if lm.grant(tx.id, tag, mode) {
+			return nil
+		}
+
+		// The youngest transaction of a cycle is aborted, as it has
+		// likely done the least work
+		victim := lm.deadlockVictim(tx.id)
+		if victim == tx.id {
+			return DeadlockDetected
+		}
+
+		if victim != 0 {
+			other := lm.waiting[victim]
+			other.err = DeadlockDetected
+			delete(lm.waiting, victim)
+			other.signal()
+			continue
+		}
+
+		lm.mu.Unlock()
+		select {
+		case <-req.wake:
+			lm.mu.Lock()
+		case <-timeout:
+			lm.mu.Lock()
+			return LockWaitTimeout
+		}
+	}
+}
+
+// deadlockVictim looks for a cycle through a transaction in the waits-for
+// graph, and returns the transaction of the cycle with the highest id, or 0
+// if there is none.
+func (lm *lockManager) deadlockVictim(id uint64) uint64 {
+	path := []uint64{}
+	seen := map[uint64]bool{}
+
+	var visit func(waiter uint64) bool
+	visit = func(waiter uint64) bool {
+		req, ok := lm.waiting[waiter]
+		if !ok {
+			return false
+		}
+
+		path = append(path, waiter)
+		for _, holder := range lm.blockers(waiter, req.tag, req.mode) {
+			if holder == id {
+				return true
+			}
+
+			if !seen[holder] {
+				seen[holder] = true
+				if visit(holder) {
+					return true
+				}
+			}
+		}
+
+		path = path[:len(path)-1]
+		return false
+	}
+
+	if !visit(id) {
+		return 0
+	}
+
+	victim := id
+	for _, waiter := range path {
+		if waiter > victim {
+			victim = waiter
+		}
+	}
+
+	return victim
+}
+
+// releaseAll releases the locks of a transaction, and wakes up the waiting
+// ones to try again.
+func (lm *lockManager) releaseAll(id uint64) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	for _, tag := range lm.held[id] {
+		delete(lm.holders[tag], id)
+		if len(lm.holders[tag]) == 0 {
+			delete(lm.holders, tag)
+		}
+	}
+	delete(lm.held, id)
+
+	for _, req := range lm.waiting {
+		req.signal()
+	}
+}
+
+// A lockWait is returned by a statement that has to wait for a lock. The
+// statement is undone, and run again once the lock is granted.
+type lockWait struct {
+	tag  lockTag
+	mode lockMode
+}
+
+func (w *lockWait) Error() string {
+	return "waiting for lock"
+}
+
+// lockTable locks a table for tx, or returns a lockWait.
+func (mb *MemoryBackend) lockTable(tx *Transaction, name string, mode lockMode) error {
+	tag := lockTag{table: name}
+	if !mb.locks.tryLock(tx.id, tag, mode) {
+		return &lockWait{tag: tag, mode: mode}
+	}
+
+	return nil
+}
+
+// lockedTable locks a table for tx and returns it, or returns a lockWait.
+// The table is looked up again once locked, in case it was dropped or
+// created meanwhile.
+func (mb *MemoryBackend) lockedTable(tx *Transaction, name string, mode lockMode) (*table, error) {
+	t, ok := mb.table(name)
+	for {
+		if !ok {
+			return nil, TableDoesNotExists
+		}
+
+		err := mb.lockTable(tx, name, mode)
+		if err != nil {
+			return nil, err
+		}
+
+		locked, lockedOk := mb.table(name)
+		if lockedOk && locked == t {
+			return t, nil
+		}
+
+		t, ok = locked, lockedOk
+	}
+}
+
+// lockRow locks a version of a row for tx, or returns a lockWait. A row that
+// was deleted by a transaction that committed after tx began cannot be locked
+// anymore.
+func (mb *MemoryBackend) lockRow(tx *Transaction, t *table, name string, rowIndex uint, mode lockMode) error {
+	tag := lockTag{table: name, row: true, rowIndex: rowIndex}
+	if !mb.locks.tryLock(tx.id, tag, mode) {
+		return &lockWait{tag: tag, mode: mode}
+	}
+
+	// A view may not show the deletions committed since it was taken
+	t = t.source()
+	t.rlock()
+	deleted := t.xmax[rowIndex] != 0
+	t.runlock()
+
+	if deleted {
+		return SerializationFailure
+	}
+
+	return nil
+}
+
+// Settings are the options of a session, which SET changes.
+type Settings struct {
+	// How long a statement waits for a lock, forever when 0. It applies to
+	// the transactions the session begins.
+	LockTimeout time.Duration
+}
+
+// Set changes a setting. lock_timeout is given in milliseconds, or as an
+// interval like '2 seconds'.
+func (s *Settings) Set(set *SetStatement) error {
+	if set.name.value != "lock_timeout" {
+		return UnknownSetting
+	}
+
+	if set.value.kind == KeywordKind {
+		s.LockTimeout = 0
+		return nil
+	}
+
+	if ms, err := strconv.ParseFloat(set.value.value, 64); err == nil {
+		if ms < 0 {
+			return InvalidSettingValue
+		}
+
+		s.LockTimeout = time.Duration(ms * float64(time.Millisecond))
+		return nil
+	}
+
+	if set.value.kind != StringKind {
+		return InvalidSettingValue
+	}
+
+	iv, err := parseInterval(set.value.value)
+	if err != nil || iv.span() < 0 {
+		return InvalidSettingValue
+	}
+
+	s.LockTimeout = time.Duration(iv.span()) * time.Microsecond
+	return nil
+}
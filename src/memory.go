@@ -3,36 +3,164 @@ package src
 import (
 	"bytes"
 	"encoding/binary"
-	"fmt"
-	"log"
+	"math"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
 
 	"github.com/petar/GoLLRB/llrb"
 )
 
 type memoryCell []byte
 
+// Ints are stored big-endian with the sign bit flipped, so that their bytes
+// sort in the same order as their values, as index trees compare bytes.
+const intSignBit = uint32(1) << 31
+
 func (mc memoryCell) AsInt() int32 {
-	var i int32
+	var i uint32
 	err := binary.Read(bytes.NewBuffer(mc), binary.BigEndian, &i)
 	if err != nil {
 		panic(err)
 	}
 
-	return i
+	return int32(i ^ intSignBit)
+}
+
+// AsInt64 reads bigints, which are stored like ints on eight bytes, as well
+// as ints.
+func (mc memoryCell) AsInt64() int64 {
+	if len(mc) == 4 {
+		return int64(mc.AsInt())
+	}
+
+	return int64(binary.BigEndian.Uint64(mc) ^ bigIntSignBit)
+}
+
+// Doubles are stored as their IEEE 754 bits, with the sign bit flipped for
+// positive numbers and all bits flipped for negative ones, so that their
+// bytes sort in the same order as their values too.
+func (mc memoryCell) AsFloat() float64 {
+	bits := binary.BigEndian.Uint64(mc)
+	if bits&bigIntSignBit != 0 {
+		bits ^= bigIntSignBit
+	} else {
+		bits = ^bits
+	}
+
+	return math.Float64frombits(bits)
+}
+
+const bigIntSignBit = uint64(1) << 63
+
+func intCell(i int32) memoryCell {
+	cell := make(memoryCell, 4)
+	binary.BigEndian.PutUint32(cell, uint32(i)^intSignBit)
+	return cell
+}
+
+func bigIntCell(i int64) memoryCell {
+	cell := make(memoryCell, 8)
+	binary.BigEndian.PutUint64(cell, uint64(i)^bigIntSignBit)
+	return cell
+}
+
+func floatCell(f float64) memoryCell {
+	// -0 is stored as 0, as they are equal
+	if f == 0 {
+		f = 0
+	}
+
+	bits := math.Float64bits(f)
+	if bits&bigIntSignBit != 0 {
+		bits = ^bits
+	} else {
+		bits ^= bigIntSignBit
+	}
+
+	cell := make(memoryCell, 8)
+	binary.BigEndian.PutUint64(cell, bits)
+	return cell
 }
 
 func (mc memoryCell) AsText() string {
 	return string(mc)
 }
 
+// AsBool is false for NULL, so that NULL conditions filter rows out.
 func (mc memoryCell) AsBool() bool {
-	return len(mc) != 0
+	return len(mc) != 0 && mc[0] != 0
+}
+
+// IsNull distinguishes NULL from any value, including false and the empty
+// string.
+func (mc memoryCell) IsNull() bool {
+	return mc == nil
+}
+
+// compare orders two cells of the same type. It returns a negative number,
+// zero or a positive number when mc is less than, equal to or greater than b.
+func (mc memoryCell) compare(b memoryCell, typ columnType) int {
+	switch typ {
+	case IntType:
+		l, r := mc.AsInt(), b.AsInt()
+		if l < r {
+			return -1
+		} else if l > r {
+			return 1
+		}
+
+		return 0
+	case BigIntType:
+		l, r := mc.AsInt64(), b.AsInt64()
+		if l < r {
+			return -1
+		} else if l > r {
+			return 1
+		}
+
+		return 0
+	case DateType:
+		return mc.compare(b, IntType)
+	case TimeType, TimestampType:
+		return mc.compare(b, BigIntType)
+	case IntervalType:
+		l, r := mc.AsInterval().span(), b.AsInterval().span()
+		if l < r {
+			return -1
+		} else if l > r {
+			return 1
+		}
+
+		return 0
+	case FloatType:
+		l, r := mc.AsFloat(), b.AsFloat()
+		if l < r {
+			return -1
+		} else if l > r {
+			return 1
+		}
+
+		return 0
+	case BoolType:
+		l, r := mc.AsBool(), b.AsBool()
+		if l == r {
+			return 0
+		} else if r {
+			return -1
+		}
+
+		return 1
+	}
+
+	return bytes.Compare(mc, b)
 }
 
 func (mc memoryCell) equals(b memoryCell) bool {
-	if mc == nil || b == nil {
-		return mc == nil && b == nil
+	if mc.IsNull() || b.IsNull() {
+		return mc.IsNull() && b.IsNull()
 	}
 
 	return bytes.Compare(mc, b) == 0
@@ -40,44 +168,103 @@ func (mc memoryCell) equals(b memoryCell) bool {
 
 type MemoryBackend struct {
 	tables map[string]*table
+
+	// Guards the tables, their indexes and the running transactions, and is
+	// only held while they are looked up or changed. The rows of every table
+	// have a latch of their own.
+	latch sync.RWMutex
+
+	// Transactions get increasing ids, from 1 on. Rows with a creator of 0
+	// were there before any transaction, like those loaded from disk.
+	nextID uint64
+	active map[uint64]bool
+
+	// Rows deleted by committed transactions, which running ones may still
+	// see, in the order they were deleted.
+	dead []deadRow
+
+	locks *lockManager
 }
 
 func NewMemoryBackend() *MemoryBackend {
 	return &MemoryBackend{
 		tables: map[string]*table{},
+		nextID: 1,
+		active: map[uint64]bool{},
+		locks:  newLockManager(),
 	}
 }
 
-func (mb *MemoryBackend) CreateTable(crt *CreateTableStatement) error {
-	if _, ok := mb.tables[crt.name.value]; ok {
+// table looks up a stored table by name.
+func (mb *MemoryBackend) table(name string) (*table, bool) {
+	mb.latch.RLock()
+	defer mb.latch.RUnlock()
+
+	t, ok := mb.tables[name]
+	return t, ok
+}
+
+func (mb *MemoryBackend) CreateTable(tx *Transaction, crt *CreateTableStatement) error {
+	return mb.run(tx, func(tx *Transaction) error {
+		return mb.createTable(tx, crt)
+	})
+}
+
+func (mb *MemoryBackend) createTable(tx *Transaction, crt *CreateTableStatement) error {
+	err := mb.lockTable(tx, crt.name.value, exclusiveLock)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := mb.table(crt.name.value); ok {
 		return TableAlreadyExists
 	}
 
 	t := newTable()
 	t.name = crt.name.value
-	mb.tables[t.name] = t
-	if crt.cols == nil {
-		return nil
+
+	cols := []*columnDefinition{}
+	if crt.cols != nil {
+		cols = *crt.cols
 	}
 
 	var primaryKey *expression = nil
-	for _, col := range *crt.cols {
+	for _, col := range cols {
 		t.columns = append(t.columns, col.name.value)
 
 		var dt columnType
-		switch col.dataType.value {
-		case "int":
+		switch keyword(col.dataType.value) {
+		case Int:
 			dt = IntType
-		case "text":
+		case Bigint:
+			dt = BigIntType
+		case Double, Float:
+			dt = FloatType
+		case Boolean:
+			dt = BoolType
+		case Text, Varchar:
 			dt = TextType
 		default:
-			delete(mb.tables, t.name)
-			return InvalidDatatype
+			var ok bool
+			dt, ok = datetimeTypes[col.dataType.value]
+			if !ok {
+				return InvalidDatatype
+			}
+		}
+
+		// Only text columns have a length, and it is optional
+		length := 0
+		if col.length != nil {
+			n, err := strconv.Atoi(col.length.value)
+			if dt != TextType || err != nil || n <= 0 {
+				return InvalidDatatype
+			}
+
+			length = n
 		}
 
 		if col.primaryKey {
 			if primaryKey != nil {
-				delete(mb.tables, t.name)
 				return PrimaryKeyAlreadyExists
 			}
 
@@ -87,11 +274,32 @@ func (mb *MemoryBackend) CreateTable(crt *CreateTableStatement) error {
 			}
 		}
 
+		if col.defaultValue != nil {
+			_, err := newTable().expressionType(*col.defaultValue)
+			if err != nil {
+				return err
+			}
+		}
+
 		t.columnTypes = append(t.columnTypes, dt)
+		t.lengths = append(t.lengths, length)
+		t.notNull = append(t.notNull, col.notNull || col.primaryKey)
+		t.defaults = append(t.defaults, col.defaultValue)
 	}
 
+	// The table is only shared once complete. No other transaction can
+	// create it meanwhile, as they would need the lock on its name.
+	mb.latch.Lock()
+	mb.tables[t.name] = t
+	mb.latch.Unlock()
+	tx.onRollback(func() {
+		mb.latch.Lock()
+		delete(mb.tables, t.name)
+		mb.latch.Unlock()
+	})
+
 	if primaryKey != nil {
-		err := mb.CreateIndex(&CreateIndexStatement{
+		err := mb.createIndex(tx, &CreateIndexStatement{
 			table:      crt.name,
 			name:       token{value: t.name + "_pkey"},
 			unique:     true,
@@ -106,16 +314,21 @@ func (mb *MemoryBackend) CreateTable(crt *CreateTableStatement) error {
 	return nil
 }
 
-func (mb *MemoryBackend) CreateIndex(ci *CreateIndexStatement) error {
-	table, ok := mb.tables[ci.table.value]
-	if !ok {
-		return TableDoesNotExists
+func (mb *MemoryBackend) CreateIndex(tx *Transaction, ci *CreateIndexStatement) error {
+	return mb.run(tx, func(tx *Transaction) error {
+		return mb.createIndex(tx, ci)
+	})
+}
+
+func (mb *MemoryBackend) createIndex(tx *Transaction, ci *CreateIndexStatement) error {
+	// Rows can be read but not changed while the index is built
+	table, err := mb.lockedTable(tx, ci.table.value, sharedLock)
+	if err != nil {
+		return err
 	}
 
-	for _, index := range table.indexes {
-		if index.name == ci.name.value {
-			return IndexAlreadyExists
-		}
+	if mb.indexExists(ci.name.value) {
+		return IndexAlreadyExists
 	}
 
 	index := &index{
@@ -126,155 +339,1361 @@ func (mb *MemoryBackend) CreateIndex(ci *CreateIndexStatement) error {
 		tree:       llrb.New(),
 		typ:        "rbtree",
 	}
+
+	// Rows that are already in the table must satisfy the index as well.
+	// Every version is indexed, whether tx sees it or not. Rows deleted
+	// before the index is shared may be purged meanwhile, so their items
+	// are taken out again.
+	items := []treeItem{}
+	table.latch.RLock()
+	for i, row := range table.rows {
+		if row == nil {
+			continue
+		}
+
+		value, err := index.value(table, row)
+		if err == nil {
+			err = index.addRow(tx, table, uint(i), value)
+		}
+
+		if err != nil {
+			table.latch.RUnlock()
+			return err
+		}
+
+		items = append(items, treeItem{value: value, index: uint(i)})
+	}
+	table.latch.RUnlock()
+
+	mb.latch.Lock()
+	defer mb.latch.Unlock()
+
+	// Index names are shared by all tables, as DROP INDEX only takes a name
+	if mb.indexExistsLatched(ci.name.value) {
+		return IndexAlreadyExists
+	}
+
+	table.latch.Lock()
+	for _, item := range items {
+		if table.rows[item.index] == nil {
+			index.tree.Delete(item)
+		}
+	}
+
+	indexes := table.indexes
 	table.indexes = append(table.indexes, index)
+	table.latch.Unlock()
+
+	tx.onRollback(func() {
+		mb.latch.Lock()
+		table.latch.Lock()
+		table.indexes = indexes
+		table.latch.Unlock()
+		mb.latch.Unlock()
+	})
+
 	return nil
 }
 
-func (mb *MemoryBackend) Insert(inst *InsertStatement) error {
-	table, ok := mb.tables[inst.table.value]
+// indexExists tells whether a table has an index with a name.
+func (mb *MemoryBackend) indexExists(name string) bool {
+	mb.latch.RLock()
+	defer mb.latch.RUnlock()
+
+	return mb.indexExistsLatched(name)
+}
+
+func (mb *MemoryBackend) indexExistsLatched(name string) bool {
+	for _, t := range mb.tables {
+		for _, index := range t.indexes {
+			if index.name == name {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (mb *MemoryBackend) DropTable(tx *Transaction, dt *DropTableStatement) error {
+	return mb.run(tx, func(tx *Transaction) error {
+		return mb.dropTable(tx, dt)
+	})
+}
+
+func (mb *MemoryBackend) dropTable(tx *Transaction, dt *DropTableStatement) error {
+	mb.latch.Lock()
+	defer mb.latch.Unlock()
+
+	t, ok := mb.tables[dt.name.value]
 	if !ok {
+		if dt.ifExists {
+			return nil
+		}
+
 		return TableDoesNotExists
 	}
 
-	if inst.values == nil {
+	err := mb.lockTable(tx, t.name, exclusiveLock)
+	if err != nil {
+		return err
+	}
+
+	// Indexes belong to the table and go away with it
+	delete(mb.tables, dt.name.value)
+	tx.onRollback(func() {
+		mb.latch.Lock()
+		mb.tables[t.name] = t
+		mb.latch.Unlock()
+	})
+
+	return nil
+}
+
+func (mb *MemoryBackend) DropIndex(tx *Transaction, di *DropIndexStatement) error {
+	return mb.run(tx, func(tx *Transaction) error {
+		return mb.dropIndex(tx, di)
+	})
+}
+
+func (mb *MemoryBackend) dropIndex(tx *Transaction, di *DropIndexStatement) error {
+	mb.latch.Lock()
+	defer mb.latch.Unlock()
+
+	for _, table := range mb.tables {
+		for i, index := range table.indexes {
+			if index.name != di.name.value {
+				continue
+			}
+
+			if index.primaryKey {
+				return CannotDropPrimaryKey
+			}
+
+			err := mb.lockTable(tx, table.name, exclusiveLock)
+			if err != nil {
+				return err
+			}
+
+			t, indexes := table, table.indexes
+			t.latch.Lock()
+			t.indexes = append(indexes[:i:i], indexes[i+1:]...)
+			t.latch.Unlock()
+			tx.onRollback(func() {
+				mb.latch.Lock()
+				t.latch.Lock()
+				t.indexes = indexes
+				t.latch.Unlock()
+				mb.latch.Unlock()
+			})
+
+			return nil
+		}
+	}
+
+	if di.ifExists {
 		return nil
 	}
 
-	row := []memoryCell{}
+	return IndexDoesNotExist
+}
+
+func (mb *MemoryBackend) Truncate(tx *Transaction, trunc *TruncateStatement) error {
+	return mb.run(tx, func(tx *Transaction) error {
+		return mb.truncate(tx, trunc)
+	})
+}
 
-	if len(*inst.values) != len(table.columns) {
-		return MissingValues
+func (mb *MemoryBackend) truncate(tx *Transaction, trunc *TruncateStatement) error {
+	table, err := mb.lockedTable(tx, trunc.table.value, exclusiveLock)
+	if err != nil {
+		return err
 	}
 
-	for _, value := range *inst.values {
-		if value.kind != literal {
-			fmt.Println("Skipping non-literal")
-			continue
+	// Other transactions may still see the rows, which are deleted like
+	// by DELETE
+	rowIndexes, err := table.candidateRows(tx, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, rowIndex := range rowIndexes {
+		tx.deleteRow(table, rowIndex)
+	}
+
+	return nil
+}
+
+func (mb *MemoryBackend) Insert(tx *Transaction, inst *InsertStatement) error {
+	return mb.run(tx, func(tx *Transaction) error {
+		return mb.insert(tx, inst)
+	})
+}
+
+func (mb *MemoryBackend) insert(tx *Transaction, inst *InsertStatement) error {
+	// New rows are not seen by other transactions, so they are not locked
+	table, err := mb.lockedTable(tx, inst.table.value, intentExclusiveLock)
+	if err != nil {
+		return err
+	}
+
+	columns, err := table.insertColumns(inst.columns)
+	if err != nil {
+		return err
+	}
+
+	values, err := mb.insertValues(tx, inst, table, columns)
+	if err != nil {
+		return err
+	}
+
+	rows := [][]memoryCell{}
+	for _, value := range values {
+		// Columns left out get their default, or NULL
+		row := make([]memoryCell, len(table.columns))
+		for i, def := range table.defaults {
+			if def != nil {
+				cell, _, typ, err := newTable().evaluateCell(0, *def)
+				if err != nil {
+					return err
+				}
+
+				row[i], err = table.coerceCell(i, cell, typ)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		for i, column := range columns {
+			row[column] = value[i]
 		}
 
-		emptyTable := newTable()
-		value, _, _, err := emptyTable.evaluateCell(0, *value)
+		rows = append(rows, row)
+	}
+
+	// Rows go in all at once: if one is rejected, the ones before it are
+	// taken out again with the statement.
+	for _, row := range rows {
+		err := table.insertRow(tx, row)
 		if err != nil {
 			return err
 		}
-
-		row = append(row, value)
 	}
 
-	table.rows = append(table.rows, row)
 	return nil
 }
 
-func (mb *MemoryBackend) Select(slct *SelectStatement) (*Results, error) {
-	table := newTable()
-
-	if slct.from != nil {
-		var ok bool
-		table, ok = mb.tables[slct.from.value]
-		if !ok {
-			return nil, TableDoesNotExists
+// insertColumns returns the position of every column an INSERT gives values
+// for.
+func (t *table) insertColumns(names *[]*token) ([]int, error) {
+	columns := []int{}
+	if names == nil {
+		for i := range t.columns {
+			columns = append(columns, i)
 		}
-	}
 
-	if slct.item == nil || len(*slct.item) == 0 {
-		return &Results{}, nil
+		return columns, nil
 	}
 
-	results := [][]Cell{}
-	columns := []ResultsColumn{}
+	seen := map[int]bool{}
+	for _, name := range *names {
+		column := -1
+		for i, col := range t.columns {
+			if col == name.value {
+				column = i
+				break
+			}
+		}
+
+		if column == -1 {
+			return nil, ColumnDoesNotExist
+		}
 
-	for _, iAndE := range table.getApplicableIndexes(slct.where) {
-		index := iAndE.i
-		exp := iAndE.e
-		table = index.newTableFromSubset(table, exp)
+		if seen[column] {
+			return nil, DuplicateColumn
+		}
+		seen[column] = true
+
+		columns = append(columns, column)
 	}
 
-	for i := range table.rows {
-		result := []Cell{}
-		isFirstRow := len(results) == 0
+	return columns, nil
+}
+
+// insertValues evaluates the rows of an INSERT, from its values or by
+// running its selection, and fits every value to the type of its column.
+func (mb *MemoryBackend) insertValues(tx *Transaction, inst *InsertStatement, t *table, columns []int) ([][]memoryCell, error) {
+	rows := [][]memoryCell{}
+	add := func(values []memoryCell, types []columnType) error {
+		if len(values) < len(columns) {
+			return MissingValues
+		}
+
+		if len(values) > len(columns) {
+			return TooManyValues
+		}
 
-		if slct.where != nil {
-			val, _, _, err := table.evaluateCell(uint(i), *slct.where)
+		row := []memoryCell{}
+		for i, value := range values {
+			cell, err := t.coerceCell(columns[i], value, types[i])
 			if err != nil {
-				return nil, err
+				return err
 			}
 
-			if !val.AsBool() {
-				continue
-			}
+			row = append(row, cell)
 		}
 
-		for _, col := range *slct.item {
-			value, colName, colType, err := table.evaluateCell(uint(i), *col.exp)
+		rows = append(rows, row)
+		return nil
+	}
+
+	if inst.selection != nil {
+		results, err := mb.query(tx, inst.selection)
+		if err != nil {
+			return nil, err
+		}
+
+		types := []columnType{}
+		for _, col := range results.Columns {
+			types = append(types, col.Type)
+		}
+
+		for _, result := range results.Rows {
+			values := []memoryCell{}
+			for _, cell := range result {
+				values = append(values, cell.(memoryCell))
+			}
+
+			err := add(values, types)
 			if err != nil {
 				return nil, err
 			}
+		}
 
-			if isFirstRow {
-				columns = append(columns, ResultsColumn{colType, colName})
+		return rows, nil
+	}
+
+	if inst.values == nil {
+		return rows, nil
+	}
+
+	scoped := newTable().view(mb.newScope(tx))
+	for _, exps := range *inst.values {
+		values := []memoryCell{}
+		types := []columnType{}
+		for _, exp := range exps {
+			value, _, typ, err := scoped.evaluateCell(0, *exp)
+			if err != nil {
+				return nil, err
 			}
 
-			result = append(result, value)
+			values = append(values, value)
+			types = append(types, typ)
 		}
 
-		results = append(results, result)
+		err := add(values, types)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	return &Results{
-		Columns: columns,
-		Rows:    results,
-	}, nil
+	return rows, nil
 }
 
-func (mb *MemoryBackend) tokenToCell(t *token) memoryCell {
-	if t.kind == NumericKind {
-		buf := new(bytes.Buffer)
-		i, err := strconv.Atoi(t.value)
-		if err != nil {
-			panic(err)
+// coerceCell checks a value against the type of a column, and converts it
+// where no information is lost: numbers to a wider numeric type, or to a
+// narrower one when they fit, text holding a number or a boolean, and any
+// value to text. Text must also fit the length of the column.
+func (t *table) coerceCell(column int, value memoryCell, typ columnType) (memoryCell, error) {
+	expected := t.columnTypes[column]
+	if value.IsNull() {
+		return value, nil
+	}
+
+	if typ == TextType && expected != TextType {
+		typ, value = parseCell(strings.TrimSpace(value.AsText()), expected)
+	}
+
+	if typ != expected {
+		var err error
+		value, err = castCell(value, typ, expected)
+		if err == InvalidDatatype {
+			return nil, &ColumnTypeError{
+				Column:   t.columns[column],
+				Expected: expected,
+				Actual:   typ,
+			}
 		}
 
-		err = binary.Write(buf, binary.BigEndian, int32(i))
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
-		return memoryCell(buf.Bytes())
 	}
 
-	if t.kind == StringKind {
-		return memoryCell(t.value)
+	if expected == TextType && t.lengths[column] > 0 && utf8.RuneCount(value) > t.lengths[column] {
+		return nil, ValueTooLong
 	}
 
-	return nil
+	return value, nil
 }
 
-type table struct {
-	indexes     []*index
-	name        string
-	columns     []string
-	columnTypes []columnType
-	rows        [][]memoryCell
+// parseCell reads text as a value of another type. It returns the text as
+// it was when it cannot be read.
+func parseCell(text string, typ columnType) (columnType, memoryCell) {
+	switch typ {
+	case IntType, BigIntType:
+		i, err := strconv.ParseInt(text, 10, 64)
+		if err == nil {
+			return BigIntType, bigIntCell(i)
+		}
+	case FloatType:
+		f, err := strconv.ParseFloat(text, 64)
+		if err == nil {
+			return FloatType, floatCell(f)
+		}
+	case DateType, TimeType, TimestampType, IntervalType:
+		value, err := parseDatetime(text, typ)
+		if err == nil {
+			return typ, value
+		}
+	case BoolType:
+		b, ok := parseBool(text)
+		if ok && b {
+			return BoolType, trueMemoryCell
+		} else if ok {
+			return BoolType, falseMemoryCell
+		}
+	}
+
+	return TextType, memoryCell(text)
 }
 
-func newTable() *table {
-	return &table{
-		columns:     nil,
-		columnTypes: nil,
-		rows:        nil,
+// parseBool reads a boolean the way PostgreSQL does: true, yes, on and 1, or
+// false, no, off and 0, in any case and around spaces. Words may be cut
+// short as long as they stay unambiguous.
+func parseBool(text string) (bool, bool) {
+	text = strings.ToLower(strings.TrimSpace(text))
+	if text == "" {
+		return false, false
 	}
+
+	switch {
+	case strings.HasPrefix("true", text), strings.HasPrefix("yes", text), text == "on", text == "1":
+		return true, true
+	case strings.HasPrefix("false", text), strings.HasPrefix("no", text), text == "of", text == "off", text == "0":
+		return false, true
+	}
+
+	return false, false
+}
+
+// castCell converts a value to another type. It returns InvalidDatatype for
+// conversions that could lose information.
+func castCell(value memoryCell, from columnType, to columnType) (memoryCell, error) {
+	if from == to {
+		return value, nil
+	}
+
+	switch to {
+	case TextType:
+		return memoryCell(formatCell(value, from)), nil
+	case FloatType:
+		if from == IntType || from == BigIntType {
+			return floatCell(float64(value.AsInt64())), nil
+		}
+	case BigIntType:
+		if from == IntType {
+			return bigIntCell(value.AsInt64()), nil
+		}
+	case TimestampType:
+		if from == DateType {
+			return bigIntCell(int64(value.AsInt()) * microsPerDay), nil
+		}
+	case IntType:
+		if from == BigIntType {
+			i := value.AsInt64()
+			if i < math.MinInt32 || i > math.MaxInt32 {
+				return nil, IntegerOutOfRange
+			}
+
+			return intCell(int32(i)), nil
+		}
+	}
+
+	return nil, InvalidDatatype
+}
+
+// formatCell writes a non-NULL value the way it is written as a literal.
+func formatCell(value memoryCell, typ columnType) string {
+	switch typ {
+	case IntType, BigIntType:
+		return strconv.FormatInt(value.AsInt64(), 10)
+	case FloatType:
+		return strconv.FormatFloat(value.AsFloat(), 'g', -1, 64)
+	case BoolType:
+		return strconv.FormatBool(value.AsBool())
+	case DateType:
+		return value.AsTime().Format(DateFormat)
+	case TimeType:
+		return value.AsTime().Format(TimeFormat)
+	case TimestampType:
+		return value.AsTime().Format(TimestampFormat)
+	case IntervalType:
+		return value.AsInterval().String()
+	}
+
+	return value.AsText()
+}
+
+func (mb *MemoryBackend) Update(tx *Transaction, upd *UpdateStatement) (uint, error) {
+	var count uint
+	err := mb.run(tx, func(tx *Transaction) error {
+		var err error
+		count, err = mb.update(tx, upd)
+		return err
+	})
+
+	return count, err
+}
+
+func (mb *MemoryBackend) update(tx *Transaction, upd *UpdateStatement) (uint, error) {
+	table, err := mb.lockedTable(tx, upd.table.value, intentExclusiveLock)
+	if err != nil {
+		return 0, err
+	}
+
+	columns := []int{}
+	for _, item := range *upd.set {
+		column := -1
+		for i, tableCol := range table.columns {
+			if tableCol == item.column.value {
+				column = i
+				break
+			}
+		}
+
+		if column == -1 {
+			return 0, ColumnDoesNotExist
+		}
+
+		columns = append(columns, column)
+	}
+
+	// Expressions are evaluated on a view, for their subqueries to run in
+	// tx, while the rows are changed in the table itself
+	view := table.view(mb.newScope(tx))
+
+	// All new values are computed from the rows as they were before the
+	// update, then the rows are swapped in at once: the old versions are
+	// deleted before the new ones are inserted.
+	candidates, err := view.candidateRows(tx, upd.where)
+	if err != nil {
+		return 0, err
+	}
+
+	rowIndexes := []uint{}
+	newRows := [][]memoryCell{}
+	for _, rowIndex := range candidates {
+		if upd.where != nil {
+			val, _, _, err := view.evaluateCell(rowIndex, *upd.where)
+			if err != nil {
+				return 0, err
+			}
+
+			if !val.AsBool() {
+				continue
+			}
+		}
+
+		err := mb.lockRow(tx, table, table.name, rowIndex, exclusiveLock)
+		if err != nil {
+			return 0, err
+		}
+
+		row := append([]memoryCell{}, table.row(rowIndex)...)
+		for j, item := range *upd.set {
+			value, _, typ, err := view.evaluateCell(rowIndex, item.exp)
+			if err != nil {
+				return 0, err
+			}
+
+			// Values fit their column like they do on INSERT
+			row[columns[j]], err = table.coerceCell(columns[j], value, typ)
+			if err != nil {
+				return 0, err
+			}
+		}
+
+		rowIndexes = append(rowIndexes, rowIndex)
+		newRows = append(newRows, row)
+	}
+
+	for _, rowIndex := range rowIndexes {
+		tx.deleteRow(table, rowIndex)
+	}
+
+	for _, row := range newRows {
+		err := table.insertRow(tx, row)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return uint(len(rowIndexes)), nil
+}
+
+func (mb *MemoryBackend) Delete(tx *Transaction, del *DeleteStatement) (uint, error) {
+	var count uint
+	err := mb.run(tx, func(tx *Transaction) error {
+		var err error
+		count, err = mb.delete(tx, del)
+		return err
+	})
+
+	return count, err
+}
+
+func (mb *MemoryBackend) delete(tx *Transaction, del *DeleteStatement) (uint, error) {
+	table, err := mb.lockedTable(tx, del.table.value, intentExclusiveLock)
+	if err != nil {
+		return 0, err
+	}
+
+	view := table.view(mb.newScope(tx))
+
+	candidates, err := view.candidateRows(tx, del.where)
+	if err != nil {
+		return 0, err
+	}
+
+	rowIndexes := []uint{}
+	for _, rowIndex := range candidates {
+		if del.where != nil {
+			val, _, _, err := view.evaluateCell(rowIndex, *del.where)
+			if err != nil {
+				return 0, err
+			}
+
+			if !val.AsBool() {
+				continue
+			}
+		}
+
+		err := mb.lockRow(tx, table, table.name, rowIndex, exclusiveLock)
+		if err != nil {
+			return 0, err
+		}
+
+		rowIndexes = append(rowIndexes, rowIndex)
+	}
+
+	for _, rowIndex := range rowIndexes {
+		tx.deleteRow(table, rowIndex)
+	}
+
+	return uint(len(rowIndexes)), nil
+}
+
+func (mb *MemoryBackend) Select(tx *Transaction, slct *SelectStatement) (*Results, error) {
+	var results *Results
+	err := mb.run(tx, func(tx *Transaction) error {
+		var err error
+		results, err = mb.query(tx, slct)
+		return err
+	})
+
+	return results, err
+}
+
+func (mb *MemoryBackend) query(tx *Transaction, slct *SelectStatement) (*Results, error) {
+	return mb.queryIn(mb.newScope(tx), slct)
+}
+
+// queryIn runs a SELECT in a scope, which for a subquery holds the row of
+// the enclosing query it is run for.
+func (mb *MemoryBackend) queryIn(scope *queryScope, slct *SelectStatement) (*Results, error) {
+	tx := scope.tx
+
+	// Without FROM, the items are evaluated once, over a row without columns
+	table := newTable().view(scope)
+	table.rows = [][]memoryCell{{}}
+
+	if slct.from != nil {
+		var err error
+		table, err = mb.fromTable(scope, slct.from)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if slct.item == nil || len(*slct.item) == 0 {
+		return &Results{}, nil
+	}
+
+	// A probe only needs the columns of the results
+	if scope.probe {
+		table = table.view(scope)
+		table.rows = nil
+		table.indexes = nil
+	}
+
+	items, err := table.expandSelectItems(*slct.item, slct.from != nil)
+	if err != nil {
+		return nil, err
+	}
+
+	expanded := *slct
+	expanded.item = &items
+	slct = &expanded
+
+	// Rows of a join, of a subquery or of a group cannot be traced back to a
+	// single row of a table
+	lockRows := slct.lock != 0 && slct.from != nil
+	if lockRows && (slct.from.join != nil || slct.from.subquery != nil || isGroupedSelect(slct)) {
+		return nil, LockingNotAllowed
+	}
+
+	if lockRows && slct.lock == exclusiveLock {
+		err = mb.lockTable(tx, slct.from.table.value, intentExclusiveLock)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	limit, err := evaluateLimit(slct.limit)
+	if err != nil {
+		return nil, err
+	}
+
+	offset, err := evaluateLimit(slct.offset)
+	if err != nil {
+		return nil, err
+	}
+
+	if offset < 0 {
+		offset = 0
+	}
+
+	// Without ORDER BY, rows come out in scan order, so the ones skipped by
+	// OFFSET need not be evaluated and the scan can stop at the LIMIT.
+	streaming := slct.orderBy == nil
+
+	// Once grouped, rows are groups and HAVING filters them. Like the select
+	// items, both are checked even when no row is evaluated.
+	where := slct.where
+	if where != nil {
+		_, err = table.expressionType(*where)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if isGroupedSelect(slct) {
+		table, err = table.group(tx, slct)
+		if err != nil {
+			return nil, err
+		}
+
+		where = slct.having
+		if where != nil {
+			_, err = table.expressionType(*where)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	columns, err := table.resultsColumns(*slct.item)
+	if err != nil {
+		return nil, err
+	}
+
+	types := []columnType{}
+	for _, col := range columns {
+		types = append(types, col.Type)
+	}
+
+	results := [][]Cell{}
+	keys := [][]sortKey{}
+	skipped := 0
+
+	candidates, err := table.candidateRows(tx, where)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, i := range candidates {
+		if streaming && limit >= 0 && len(results) >= limit {
+			break
+		}
+
+		result := []Cell{}
+
+		if where != nil {
+			val, _, _, err := table.evaluateCell(i, *where)
+			if err != nil {
+				return nil, err
+			}
+
+			if !val.AsBool() {
+				continue
+			}
+		}
+
+		if streaming && skipped < offset {
+			skipped++
+			continue
+		}
+
+		if lockRows {
+			err := mb.lockRow(tx, table, slct.from.table.value, i, slct.lock)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		for _, col := range *slct.item {
+			value, _, _, err := table.evaluateCell(i, *col.exp)
+			if err != nil {
+				return nil, err
+			}
+
+			result = append(result, value)
+		}
+
+		if slct.orderBy != nil {
+			key, err := table.orderByKey(i, *slct.item, *slct.orderBy, result, types)
+			if err != nil {
+				return nil, err
+			}
+
+			keys = append(keys, key)
+		}
+
+		results = append(results, result)
+	}
+
+	if !streaming {
+		sortRows(results, keys, *slct.orderBy)
+
+		if offset > len(results) {
+			offset = len(results)
+		}
+		results = results[offset:]
+
+		if limit >= 0 && limit < len(results) {
+			results = results[:limit]
+		}
+	}
+
+	return &Results{
+		Columns: columns,
+		Rows:    results,
+	}, nil
+}
+
+// evaluateLimit returns the value of a LIMIT or OFFSET expression, or -1
+// when there is none. A NULL value is the same as no value.
+func evaluateLimit(exp *expression) (int, error) {
+	if exp == nil {
+		return -1, nil
+	}
+
+	value, _, typ, err := newTable().evaluateCell(0, *exp)
+	if err != nil {
+		return 0, err
+	}
+
+	if value.IsNull() {
+		return -1, nil
+	}
+
+	if typ != IntType || value.AsInt() < 0 {
+		return 0, InvalidLimit
+	}
+
+	return int(value.AsInt()), nil
+}
+
+// resultsColumns derives the columns of the results from the select items
+// alone, so that they do not depend on which rows match. Items are named
+// after their alias, or else like Postgres does.
+func (t *table) resultsColumns(items []*selectItem) ([]ResultsColumn, error) {
+	columns := []ResultsColumn{}
+	for _, item := range items {
+		typ, err := t.expressionType(*item.exp)
+		if err != nil {
+			return nil, err
+		}
+
+		name := expressionName(*item.exp)
+		if item.as != nil {
+			name = item.as.value
+		}
+
+		columns = append(columns, ResultsColumn{typ, name})
+	}
+
+	return columns, nil
+}
+
+// expandSelectItems replaces * and table.* with an item for each matching
+// column, in declared order. Columns that share their name with another one
+// are referenced through their table.
+func (t *table) expandSelectItems(items []*selectItem, hasFrom bool) ([]*selectItem, error) {
+	var expanded []*selectItem
+	for _, item := range items {
+		if !item.asterisk {
+			expanded = append(expanded, item)
+			continue
+		}
+
+		if !hasFrom {
+			return nil, InvalidSelectItem
+		}
+
+		found := false
+		for i, col := range t.columns {
+			qualifier := t.qualifier(i)
+			if item.table != nil && item.table.value != qualifier {
+				continue
+			}
+			found = true
+
+			name := col
+			if _, err := t.columnIndex(col); err != nil {
+				name = qualifier + "." + col
+			}
+
+			expanded = append(expanded, &selectItem{
+				exp: &expression{
+					literal: &token{value: name, kind: IdentifierKind},
+					kind:    literal,
+				},
+			})
+		}
+
+		if !found {
+			return nil, TableDoesNotExists
+		}
+	}
+
+	return expanded, nil
+}
+
+type sortKey struct {
+	value memoryCell
+	typ   columnType
+}
+
+// orderByKey evaluates the ORDER BY expressions for a row. A bare identifier
+// naming a select item alias refers to that item's value rather than to a
+// column.
+func (t *table) orderByKey(rowIndex uint, items []*selectItem, orderBy []*orderByItem, result []Cell, types []columnType) ([]sortKey, error) {
+	var key []sortKey
+outer:
+	for _, o := range orderBy {
+		if o.exp.kind == literal && o.exp.literal.kind == IdentifierKind {
+			for i, item := range items {
+				if item.as != nil && item.as.value == o.exp.literal.value {
+					key = append(key, sortKey{result[i].(memoryCell), types[i]})
+					continue outer
+				}
+			}
+		}
+
+		value, _, typ, err := t.evaluateCell(rowIndex, o.exp)
+		if err != nil {
+			return nil, err
+		}
+
+		key = append(key, sortKey{value, typ})
+	}
+
+	return key, nil
+}
+
+// sortRows stably sorts rows by their keys. NULLs are placed according to
+// each item's nullsFirst regardless of its direction.
+func sortRows(rows [][]Cell, keys [][]sortKey, orderBy []*orderByItem) {
+	order := make([]int, len(rows))
+	for i := range order {
+		order[i] = i
+	}
+
+	sort.SliceStable(order, func(x, y int) bool {
+		a, b := keys[order[x]], keys[order[y]]
+		for i, o := range orderBy {
+			if a[i].value.IsNull() || b[i].value.IsNull() {
+				if a[i].value.IsNull() == b[i].value.IsNull() {
+					continue
+				}
+
+				return a[i].value.IsNull() == o.nullsFirst
+			}
+
+			c := a[i].value.compare(b[i].value, a[i].typ)
+			if c == 0 {
+				continue
+			}
+
+			return (c < 0) != o.desc
+		}
+
+		return false
+	})
+
+	sorted := make([][]Cell, len(rows))
+	for i, j := range order {
+		sorted[i] = rows[j]
+	}
+	copy(rows, sorted)
+}
+
+func (mb *MemoryBackend) tokenToCell(t *token) memoryCell {
+	if t.kind == NumericKind {
+		buf := new(bytes.Buffer)
+		i, err := strconv.Atoi(t.value)
+		if err != nil {
+			panic(err)
+		}
+
+		err = binary.Write(buf, binary.BigEndian, uint32(int32(i))^intSignBit)
+		if err != nil {
+			panic(err)
+		}
+		return memoryCell(buf.Bytes())
+	}
+
+	if t.kind == StringKind {
+		return memoryCell(t.value)
+	}
+
+	return nil
+}
+
+// Rows never move once inserted, so that their positions can be stored in
+// index trees. A row that is purged leaves a nil tombstone behind.
+type table struct {
+	// Changed with both the latch of the backend and the latch of the table
+	// held, so that either is enough to read them.
+	indexes     []*index
+	name        string
+	columns     []string
+	columnTypes []columnType
+	lengths     []int // maximum length of text columns, 0 for none
+	notNull     []bool
+	defaults    []*expression
+	rows        [][]memoryCell
+
+	// The transactions that created and deleted every row of a stored
+	// table, 0 for none. Tables built by a query have none.
+	xmin []uint64
+	xmax []uint64
+
+	// A grouped table has a column for each GROUP BY expression and
+	// aggregate call, named after its code.
+	grouped bool
+
+	// The table each column comes from, for joined tables. Otherwise all
+	// columns come from the table itself.
+	qualifiers []string
+
+	// The stored table a view shares its rows with, which the rows deleted
+	// by a transaction are recorded against. Nil for the stored table.
+	stored *table
+
+	// The query expressions on the rows are evaluated for, to run their
+	// subqueries. Nil outside of a query.
+	scope *queryScope
+
+	// Guards the rows of a stored table, their versions and the trees of
+	// its indexes, and is shared with its views. It is held while they are
+	// read or changed, but not while expressions are evaluated, and scans
+	// release it between batches of rows, so that a long query does not
+	// hold out the transactions that write to the table. Tables built by a
+	// query have none.
+	latch *sync.RWMutex
+}
+
+// Number of rows a scan checks before it lets go of the latch of a table.
+const latchBatch = 64
+
+func newTable() *table {
+	return &table{
+		columns:     nil,
+		columnTypes: nil,
+		rows:        nil,
+		latch:       &sync.RWMutex{},
+	}
+}
+
+// view returns a shallow copy of a table that shares its rows, and evaluates
+// expressions in a scope. It shows the rows the table had when it was taken.
+func (t *table) view(scope *queryScope) *table {
+	t.rlock()
+	v := *t
+	t.runlock()
+
+	v.stored = t.source()
+	v.scope = scope
+	return &v
+}
+
+func (t *table) rlock() {
+	if t.latch != nil {
+		t.latch.RLock()
+	}
+}
+
+func (t *table) runlock() {
+	if t.latch != nil {
+		t.latch.RUnlock()
+	}
+}
+
+// row returns a row of a table, whose cells are never changed once it is
+// inserted.
+func (t *table) row(rowIndex uint) []memoryCell {
+	t.rlock()
+	defer t.runlock()
+
+	return t.rows[rowIndex]
+}
+
+// source returns the stored table whose rows a table shows.
+func (t *table) source() *table {
+	if t.stored != nil {
+		return t.stored
+	}
+
+	return t
+}
+
+// insertRow appends a row created by tx, see placeRow.
+func (t *table) insertRow(tx *Transaction, row []memoryCell) error {
+	t.latch.Lock()
+	defer t.latch.Unlock()
+
+	return t.placeLatchedRow(tx, uint(len(t.rows)), row)
+}
+
+// placeRow puts a row created by tx at a free position, past the end of the
+// table or left by a purged row, and adds it to every index. If an index
+// rejects the row, it is removed from the indexes that already took it and
+// from the table. A nil tx places a row that is visible to every
+// transaction.
+func (t *table) placeRow(tx *Transaction, rowIndex uint, row []memoryCell) error {
+	t.latch.Lock()
+	defer t.latch.Unlock()
+
+	return t.placeLatchedRow(tx, rowIndex, row)
+}
+
+func (t *table) placeLatchedRow(tx *Transaction, rowIndex uint, row []memoryCell) error {
+	err := t.checkNotNull(row)
+	if err != nil {
+		return err
+	}
+
+	xmin := uint64(0)
+	if tx != nil {
+		xmin = tx.id
+	}
+
+	length := len(t.rows)
+	for uint(len(t.rows)) <= rowIndex {
+		t.rows = append(t.rows, nil)
+		t.xmin = append(t.xmin, 0)
+		t.xmax = append(t.xmax, 0)
+	}
+
+	t.rows[rowIndex] = row
+	t.xmin[rowIndex] = xmin
+	t.xmax[rowIndex] = 0
+
+	values := []memoryCell{}
+	for _, index := range t.indexes {
+		value, err := index.value(t, row)
+		if err == nil {
+			err = index.addRow(tx, t, rowIndex, value)
+		}
+
+		if err != nil {
+			for i, added := range t.indexes[:len(values)] {
+				added.removeRow(rowIndex, values[i])
+			}
+
+			t.rows[rowIndex] = nil
+			if int(rowIndex) >= length {
+				t.rows = t.rows[:length]
+				t.xmin = t.xmin[:length]
+				t.xmax = t.xmax[:length]
+			}
+			return err
+		}
+
+		values = append(values, value)
+	}
+
+	if tx != nil {
+		tx.onRollback(func() {
+			t.purgeRow(rowIndex)
+		})
+		tx.change(change{row: rowRef{table: t, rowIndex: rowIndex}})
+	}
+
+	return nil
+}
+
+// purgeRow takes a row out of the table and its indexes for good.
+func (t *table) purgeRow(rowIndex uint) {
+	t.latch.Lock()
+	defer t.latch.Unlock()
+
+	row := t.rows[rowIndex]
+	if row == nil {
+		return
+	}
+
+	for _, index := range t.indexes {
+		value, err := index.value(t, row)
+		if err == nil {
+			index.removeRow(rowIndex, value)
+		}
+	}
+
+	t.rows[rowIndex] = nil
+}
+
+// visible tells whether tx sees a row, or whether the row is not deleted
+// when tx is nil. Every row of a table built by a query is visible. The
+// table must be latched.
+func (t *table) visible(tx *Transaction, rowIndex uint) bool {
+	// Rows inserted since a view was taken are past its end
+	if rowIndex >= uint(len(t.rows)) || t.rows[rowIndex] == nil {
+		return false
+	}
+
+	if rowIndex >= uint(len(t.xmin)) {
+		return true
+	}
+
+	if tx == nil {
+		return t.xmax[rowIndex] == 0
+	}
+
+	if !tx.sees(t.xmin[rowIndex]) || tx.deleted[rowRef{table: t.source(), rowIndex: rowIndex}] {
+		return false
+	}
+
+	return t.xmax[rowIndex] == 0 || !tx.sees(t.xmax[rowIndex])
+}
+
+// dead tells whether a row is deleted for good, by a committed transaction
+// or by tx. Rows that are not dead keep their values from being used again
+// in a unique index, even if tx does not see them. The table must be
+// latched.
+func (t *table) dead(tx *Transaction, rowIndex uint) bool {
+	if t.rows[rowIndex] == nil || t.xmax[rowIndex] != 0 {
+		return true
+	}
+
+	return tx != nil && tx.deleted[rowRef{table: t.source(), rowIndex: rowIndex}]
+}
+
+func (t *table) checkNotNull(row []memoryCell) error {
+	for i, cell := range row {
+		if cell.IsNull() && t.notNull[i] {
+			return ViolatesNonNullConstraint
+		}
+	}
+
+	return nil
+}
+
+// columnIndex resolves a column reference, which may be qualified by the name
+// or alias of its table as in users.id.
+func (t *table) columnIndex(name string) (int, error) {
+	qualifier := ""
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		qualifier, name = name[:i], name[i+1:]
+	}
+
+	found := -1
+	for i, col := range t.columns {
+		if col != name || (qualifier != "" && t.qualifier(i) != qualifier) {
+			continue
+		}
+
+		if found >= 0 {
+			return -1, AmbiguousColumn
+		}
+		found = i
+	}
+
+	if found < 0 {
+		return -1, ColumnDoesNotExist
+	}
+
+	return found, nil
+}
+
+func (t *table) qualifier(column int) string {
+	if t.qualifiers != nil {
+		return t.qualifiers[column]
+	}
+
+	return t.name
 }
 
 func (t *table) evaluateCell(rowIndex uint, exp expression) (memoryCell, string, columnType, error) {
+	if t.grouped {
+		code := exp.generateCode()
+		for i, col := range t.columns {
+			if col == code {
+				return t.row(rowIndex)[i], expressionName(exp), t.columnTypes[i], nil
+			}
+		}
+	}
+
 	switch exp.kind {
 	case literal:
 		return t.evaluateLiteralCell(rowIndex, exp)
 	case binaryKind:
 		return t.evaluateBinaryCell(rowIndex, exp)
+	case unaryKind:
+		return t.evaluateUnaryCell(rowIndex, exp)
+	case functionKind:
+		return t.evaluateFunctionCell(rowIndex, exp)
+	case typedLiteralKind:
+		return t.evaluateTypedLiteralCell(exp)
+	case subqueryKind:
+		return t.evaluateSubqueryCell(rowIndex, exp)
 	default:
 		return nil, "", 0, InvalidCell
 	}
 }
 
+func (t *table) evaluateTypedLiteralCell(exp expression) (memoryCell, string, columnType, error) {
+	if exp.kind != typedLiteralKind {
+		return nil, "", 0, InvalidCell
+	}
+
+	typ, ok := datetimeTypes[exp.typed.typ.value]
+	if !ok {
+		return nil, "", 0, InvalidDatatype
+	}
+
+	value, err := parseDatetime(exp.typed.value.value, typ)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	return value, exp.typed.typ.value, typ, nil
+}
+
 func (t *table) evaluateLiteralCell(rowIndex uint, exp expression) (memoryCell, string, columnType, error) {
 	if exp.kind != literal {
 		return nil, "", 0, InvalidCell
@@ -282,13 +1701,30 @@ func (t *table) evaluateLiteralCell(rowIndex uint, exp expression) (memoryCell,
 
 	lit := exp.literal
 	if lit.kind == IdentifierKind {
-		for i, tableCol := range t.columns {
-			if tableCol == lit.value {
-				return t.rows[rowIndex][i], tableCol, t.columnTypes[i], nil
-			}
+		i, err := t.columnIndex(lit.value)
+		if err == ColumnDoesNotExist && t.scope != nil && t.scope.outer != nil {
+			return t.scope.outerCell(exp)
+		}
+
+		if err != nil {
+			return nil, "", 0, err
+		}
+
+		return t.row(rowIndex)[i], t.columns[i], t.columnTypes[i], nil
+	}
+
+	if lit.kind == NumericKind {
+		columnType := lit.numericType()
+		value, err := lit.literalToMemoryCell()
+		if err != nil {
+			return nil, "", 0, err
+		}
+
+		if columnType == FloatType && math.IsInf(value.AsFloat(), 0) {
+			return nil, "", 0, FloatOutOfRange
 		}
 
-		return nil, "", 0, ColumnDoesNotExist
+		return value, "?column?", columnType, nil
 	}
 
 	columnType := IntType
@@ -296,9 +1732,16 @@ func (t *table) evaluateLiteralCell(rowIndex uint, exp expression) (memoryCell,
 		columnType = TextType
 	} else if lit.kind == BoolKind {
 		columnType = BoolType
+	} else if lit.kind == NullKind {
+		columnType = NullType
+	}
+
+	value, err := lit.literalToMemoryCell()
+	if err != nil {
+		return nil, "", 0, err
 	}
 
-	return lit.literalToMemoryCell(), "?column?", columnType, nil
+	return value, "?column?", columnType, nil
 }
 
 func (t *table) evaluateBinaryCell(rowIndex uint, exp expression) (memoryCell, string, columnType, error) {
@@ -318,45 +1761,95 @@ func (t *table) evaluateBinaryCell(rowIndex uint, exp expression) (memoryCell, s
 		return nil, "", 0, err
 	}
 
-	columnName := fmt.Sprintf("%s %s %s", bexp.a.literal.value, bexp.op.value, bexp.b.literal.value)
+	columnName := "?column?"
 
 	switch bexp.op.kind {
 	case SymbolKind:
-		switch symbol(bexp.op.value) {
-		case Equal:
-			eq := left.equals(right)
-			if leftType == TextType && rightType == TextType && eq {
-				return trueMemoryCell, columnName, BoolType, nil
+		left, leftType, err = datetimeLiteral(symbol(bexp.op.value), bexp.a, left, leftType, rightType)
+		if err != nil {
+			return nil, "", 0, err
+		}
+
+		right, rightType, err = datetimeLiteral(symbol(bexp.op.value), bexp.b, right, rightType, leftType)
+		if err != nil {
+			return nil, "", 0, err
+		}
+
+		err = checkOperandTypes(symbol(bexp.op.value), leftType, rightType)
+		if err != nil {
+			return nil, "", 0, err
+		}
+
+		// Operators yield NULL when either operand is NULL
+		if left.IsNull() || right.IsNull() {
+			switch symbol(bexp.op.value) {
+			case Concat:
+				return nil, columnName, TextType, nil
+			case Plus, Minus, Asterisk, Slash, Percent:
+				if typ, ok := datetimeType(symbol(bexp.op.value), leftType, rightType); ok {
+					return nil, columnName, typ, nil
+				}
+
+				return nil, columnName, promotedType(leftType, rightType), nil
 			}
 
-			if leftType == IntType && rightType == IntType && eq {
-				return trueMemoryCell, columnName, BoolType, nil
+			return nil, columnName, BoolType, nil
+		}
+
+		if isDatetime(leftType) || isDatetime(rightType) {
+			if typ, ok := datetimeType(symbol(bexp.op.value), leftType, rightType); ok {
+				res, err := datetimeArithmetic(symbol(bexp.op.value), left, leftType, right, rightType)
+				if err != nil {
+					return nil, "", 0, err
+				}
+
+				return res, columnName, typ, nil
 			}
+		}
+
+		left, leftType, right, rightType = unifyOperands(left, leftType, right, rightType)
 
-			if leftType == BoolType && rightType == BoolType && eq {
+		switch symbol(bexp.op.value) {
+		case Equal:
+			if leftType == rightType && left.compare(right, leftType) == 0 {
 				return trueMemoryCell, columnName, BoolType, nil
 			}
+
 			return falseMemoryCell, columnName, BoolType, nil
 		case XEqual:
-			if leftType != rightType || !left.equals(right) {
+			if leftType != rightType || left.compare(right, leftType) != 0 {
 				return trueMemoryCell, columnName, BoolType, nil
 			}
 
 			return falseMemoryCell, columnName, BoolType, nil
 		case Concat:
-			if leftType != TextType || rightType != TextType {
-				return nil, "", 0, InvalidOperands
+			return memoryCell(left.AsText() + right.AsText()), columnName, TextType, nil
+		case Plus, Minus, Asterisk, Slash, Percent:
+			res, err := arithmetic(symbol(bexp.op.value), left, right, leftType)
+			if err != nil {
+				return nil, "", 0, err
 			}
 
-			lit := &token{kind: StringKind, value: left.AsText() + right.AsText()}
-			return lit.literalToMemoryCell(), columnName, TextType, nil
-		case Plus:
-			if leftType != IntType || rightType != IntType {
-				return nil, "", 0, InvalidOperands
+			return res, columnName, leftType, nil
+		case Greater, GreaterOrEqual, Less, LessOrEqual:
+			c := left.compare(right, leftType)
+			res := false
+			switch symbol(bexp.op.value) {
+			case Greater:
+				res = c > 0
+			case GreaterOrEqual:
+				res = c >= 0
+			case Less:
+				res = c < 0
+			case LessOrEqual:
+				res = c <= 0
+			}
+
+			if res {
+				return trueMemoryCell, columnName, BoolType, nil
 			}
 
-			lit := &token{kind: NumericKind, value: strconv.Itoa(int(left.AsInt() + right.AsInt()))}
-			return lit.literalToMemoryCell(), columnName, IntType, nil
+			return falseMemoryCell, columnName, BoolType, nil
 		default:
 			// TODO
 			break
@@ -364,27 +1857,40 @@ func (t *table) evaluateBinaryCell(rowIndex uint, exp expression) (memoryCell, s
 	case KeywordKind:
 		switch keyword(bexp.op.value) {
 		case And:
-			if leftType != BoolType || rightType != BoolType {
+			if !isBoolOrNull(leftType) || !isBoolOrNull(rightType) {
 				return nil, "", 0, InvalidOperands
 			}
 
-			res := falseMemoryCell
-			if left.AsBool() && right.AsBool() {
-				res = trueMemoryCell
+			// FALSE wins over NULL, which wins over TRUE
+			res := trueMemoryCell
+			if isFalse(left) || isFalse(right) {
+				res = falseMemoryCell
+			} else if left.IsNull() || right.IsNull() {
+				res = nil
 			}
 
 			return res, columnName, BoolType, nil
 		case Or:
-			if leftType != BoolType || rightType != BoolType {
+			if !isBoolOrNull(leftType) || !isBoolOrNull(rightType) {
 				return nil, "", 0, InvalidOperands
 			}
 
+			// TRUE wins over NULL, which wins over FALSE
 			res := falseMemoryCell
 			if left.AsBool() || right.AsBool() {
 				res = trueMemoryCell
+			} else if left.IsNull() || right.IsNull() {
+				res = nil
 			}
 
 			return res, columnName, BoolType, nil
+		case Is:
+			// The right operand is always NULL, as only IS [NOT] NULL parses
+			if left.IsNull() {
+				return trueMemoryCell, columnName, BoolType, nil
+			}
+
+			return falseMemoryCell, columnName, BoolType, nil
 		default:
 			//TODO
 			break
@@ -394,6 +1900,319 @@ func (t *table) evaluateBinaryCell(rowIndex uint, exp expression) (memoryCell, s
 	return nil, "", 0, InvalidCell
 }
 
+// arithmetic computes an operator on two numbers of the same type, which is
+// also the type of the result.
+func arithmetic(op symbol, left memoryCell, right memoryCell, typ columnType) (memoryCell, error) {
+	if typ == FloatType {
+		l, r := left.AsFloat(), right.AsFloat()
+		var res float64
+		switch op {
+		case Plus:
+			res = l + r
+		case Minus:
+			res = l - r
+		case Asterisk:
+			res = l * r
+		case Slash:
+			if r == 0 {
+				return nil, DivisionByZero
+			}
+			res = l / r
+		}
+
+		if math.IsInf(res, 0) {
+			return nil, FloatOutOfRange
+		}
+
+		return floatCell(res), nil
+	}
+
+	l, r := left.AsInt64(), right.AsInt64()
+	var res int64
+	overflow := false
+	switch op {
+	case Plus:
+		res = l + r
+		overflow = (r > 0 && res < l) || (r < 0 && res > l)
+	case Minus:
+		res = l - r
+		overflow = (r < 0 && res < l) || (r > 0 && res > l)
+	case Asterisk:
+		res = l * r
+		overflow = l != 0 && (res/l != r || (l == -1 && r == math.MinInt64))
+	case Slash:
+		if r == 0 {
+			return nil, DivisionByZero
+		}
+		res = l / r
+		overflow = l == math.MinInt64 && r == -1
+	case Percent:
+		if r == 0 {
+			return nil, DivisionByZero
+		}
+		res = l % r
+	}
+
+	if typ == BigIntType {
+		if overflow {
+			return nil, IntegerOutOfRange
+		}
+
+		return bigIntCell(res), nil
+	}
+
+	// int32 operands cannot overflow an int64
+	if res < math.MinInt32 || res > math.MaxInt32 {
+		return nil, IntegerOutOfRange
+	}
+
+	return intCell(int32(res)), nil
+}
+
+func isNumeric(typ columnType) bool {
+	return typ == IntType || typ == BigIntType || typ == FloatType
+}
+
+// promotedType is the widest of two numeric types, which both are converted
+// to before an operator is applied.
+func promotedType(a columnType, b columnType) columnType {
+	if a == FloatType || b == FloatType {
+		return FloatType
+	}
+
+	if a == BigIntType || b == BigIntType {
+		return BigIntType
+	}
+
+	return IntType
+}
+
+func isBoolOrNull(typ columnType) bool {
+	return typ == BoolType || typ == NullType
+}
+
+// checkOperandTypes checks the operands of an operator before their values,
+// so that the types are checked even when one of them is NULL. A NULL literal
+// fits any operand.
+func checkOperandTypes(op symbol, leftType columnType, rightType columnType) error {
+	if leftType == NullType || rightType == NullType {
+		return nil
+	}
+
+	switch op {
+	case Concat:
+		if leftType != TextType || rightType != TextType {
+			return InvalidOperands
+		}
+	case Plus, Minus, Asterisk, Slash:
+		if _, ok := datetimeType(op, leftType, rightType); ok {
+			return nil
+		}
+
+		if !isNumeric(leftType) || !isNumeric(rightType) {
+			return InvalidOperands
+		}
+	case Percent:
+		if promotedType(leftType, rightType) == FloatType || !isNumeric(leftType) || !isNumeric(rightType) {
+			return InvalidOperands
+		}
+	case Greater, GreaterOrEqual, Less, LessOrEqual:
+		if isNumeric(leftType) && isNumeric(rightType) {
+			return nil
+		}
+
+		if (leftType == DateType || leftType == TimestampType) && (rightType == DateType || rightType == TimestampType) {
+			return nil
+		}
+
+		if leftType != rightType || leftType == BoolType {
+			return InvalidOperands
+		}
+	}
+
+	return nil
+}
+
+// datetimeLiteral reads a string literal compared to a date or time as a
+// value of the same type, as the type of the literal is only known from the
+// other operand.
+func datetimeLiteral(op symbol, exp expression, value memoryCell, typ columnType, otherType columnType) (memoryCell, columnType, error) {
+	switch op {
+	case Equal, XEqual, Greater, GreaterOrEqual, Less, LessOrEqual:
+	default:
+		return value, typ, nil
+	}
+
+	if !isDatetime(otherType) || exp.kind != literal || exp.literal.kind != StringKind {
+		return value, typ, nil
+	}
+
+	value, err := parseDatetime(value.AsText(), otherType)
+	return value, otherType, err
+}
+
+// unifyOperands converts the operands of an operator to a common type, where
+// they have one.
+func unifyOperands(left memoryCell, leftType columnType, right memoryCell, rightType columnType) (memoryCell, columnType, memoryCell, columnType) {
+	// Dates are compared to timestamps as their midnight
+	if leftType == DateType && rightType == TimestampType {
+		left, _ = castCell(left, leftType, rightType)
+		leftType = rightType
+	} else if leftType == TimestampType && rightType == DateType {
+		right, _ = castCell(right, rightType, leftType)
+		rightType = leftType
+	}
+
+	// Numbers of different types are compared and computed as the widest of
+	// them
+	if isNumeric(leftType) && isNumeric(rightType) {
+		typ := promotedType(leftType, rightType)
+		left, _ = castCell(left, leftType, typ)
+		right, _ = castCell(right, rightType, typ)
+		leftType, rightType = typ, typ
+	}
+
+	return left, leftType, right, rightType
+}
+
+func isFalse(value memoryCell) bool {
+	return !value.IsNull() && !value.AsBool()
+}
+
+func (t *table) evaluateUnaryCell(rowIndex uint, exp expression) (memoryCell, string, columnType, error) {
+	if exp.kind != unaryKind {
+		return nil, "", 0, InvalidCell
+	}
+
+	uexp := exp.unary
+
+	value, _, typ, err := t.evaluateCell(rowIndex, uexp.a)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	switch uexp.op.kind {
+	case KeywordKind:
+		switch keyword(uexp.op.value) {
+		case Not:
+			if !isBoolOrNull(typ) {
+				return nil, "", 0, InvalidOperands
+			}
+
+			if value.IsNull() {
+				return nil, "?column?", BoolType, nil
+			}
+
+			if value.AsBool() {
+				return falseMemoryCell, "?column?", BoolType, nil
+			}
+
+			return trueMemoryCell, "?column?", BoolType, nil
+		}
+	case SymbolKind:
+		switch symbol(uexp.op.value) {
+		case Minus:
+			if !isNumeric(typ) && typ != IntervalType && typ != NullType {
+				return nil, "", 0, InvalidOperands
+			}
+
+			if value.IsNull() {
+				if typ == IntervalType {
+					return nil, "?column?", typ, nil
+				}
+
+				return nil, "?column?", promotedType(typ, IntType), nil
+			}
+
+			if typ == FloatType {
+				return floatCell(-value.AsFloat()), "?column?", typ, nil
+			}
+
+			if typ == IntervalType {
+				return intervalCell(value.AsInterval().negate()), "?column?", typ, nil
+			}
+
+			res, err := arithmetic(Minus, intCell(0), value, typ)
+			if err != nil {
+				return nil, "", 0, err
+			}
+
+			return res, "?column?", typ, nil
+		}
+	}
+
+	return nil, "", 0, InvalidCell
+}
+
+func (t *table) evaluateFunctionCell(rowIndex uint, exp expression) (memoryCell, string, columnType, error) {
+	if exp.kind != functionKind {
+		return nil, "", 0, InvalidCell
+	}
+
+	// Aggregates are only computed by the grouping stage, so reaching one
+	// here means it is used where it is not allowed, like in WHERE.
+	if isAggregate(exp) {
+		return nil, "", 0, AggregateNotAllowed
+	}
+
+	function, ok := scalarFunctions[exp.function.name.value]
+	if !ok || exp.function.star {
+		return nil, "", 0, FunctionDoesNotExist
+	}
+
+	args := []memoryCell{}
+	types := []columnType{}
+	for _, arg := range exp.function.args {
+		value, _, typ, err := t.evaluateCell(rowIndex, arg)
+		if err != nil {
+			return nil, "", 0, err
+		}
+
+		args = append(args, value)
+		types = append(types, typ)
+	}
+
+	value, typ, err := function(args, types)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	return value, exp.function.name.value, typ, nil
+}
+
+// expressionName is the name of the result column of a select item without
+// an alias. Columns are named without their table.
+func expressionName(exp expression) string {
+	switch exp.kind {
+	case literal:
+		if exp.literal.kind == IdentifierKind {
+			name := exp.literal.value
+			return name[strings.LastIndex(name, ".")+1:]
+		}
+	case functionKind:
+		return exp.function.name.value
+	case subqueryKind:
+		switch exp.subquery.form {
+		case scalarSubquery:
+			// A scalar subquery is named after the column it returns
+			items := exp.subquery.slct.item
+			if items == nil || len(*items) != 1 || (*items)[0].asterisk {
+				break
+			}
+
+			if (*items)[0].as != nil {
+				return (*items)[0].as.value
+			}
+
+			return expressionName(*(*items)[0].exp)
+		case existsSubquery:
+			return "exists"
+		}
+	}
+
+	return "?column?"
+}
+
 func (t *table) getApplicableIndexes(where *expression) []indexAndExpression {
 	var linearizeExpressions func(where *expression, exps []expression) []expression
 
@@ -416,9 +2235,13 @@ func (t *table) getApplicableIndexes(where *expression) []indexAndExpression {
 
 	exps := linearizeExpressions(where, []expression{})
 
+	t.rlock()
+	indexes := t.indexes
+	t.runlock()
+
 	iAndE := []indexAndExpression{}
 	for _, exp := range exps {
-		for _, index := range t.indexes {
+		for _, index := range indexes {
 			if index.applicableValue(exp) != nil {
 				iAndE = append(iAndE, indexAndExpression{
 					i: index,
@@ -431,14 +2254,88 @@ func (t *table) getApplicableIndexes(where *expression) []indexAndExpression {
 	return iAndE
 }
 
+// candidateRows returns, in insertion order, the positions of the rows
+// visible to tx that may satisfy where. The applicable indexes are used to
+// narrow down the rows, which still have to be checked against where.
+func (t *table) candidateRows(tx *Transaction, where *expression) ([]uint, error) {
+	var candidates map[uint]bool
+	for _, iAndE := range t.getApplicableIndexes(where) {
+		subset := map[uint]bool{}
+		rows, ok, err := iAndE.i.rowIndexesFromSubset(t, iAndE.e)
+		if err != nil {
+			return nil, err
+		}
+
+		if !ok {
+			continue
+		}
+
+		for _, rowIndex := range rows {
+			if candidates == nil || candidates[rowIndex] {
+				subset[rowIndex] = true
+			}
+		}
+
+		candidates = subset
+	}
+
+	rowIndexes := []uint{}
+	if candidates == nil {
+		t.rlock()
+		count := len(t.rows)
+		t.runlock()
+
+		for i := 0; i < count; i++ {
+			rowIndexes = append(rowIndexes, uint(i))
+		}
+	} else {
+		for rowIndex := range candidates {
+			rowIndexes = append(rowIndexes, rowIndex)
+		}
+		sort.Slice(rowIndexes, func(i, j int) bool {
+			return rowIndexes[i] < rowIndexes[j]
+		})
+	}
+
+	return t.visibleRows(tx, rowIndexes), nil
+}
+
+// visibleRows keeps the rows that tx sees, a batch of rows at a time.
+func (t *table) visibleRows(tx *Transaction, rowIndexes []uint) []uint {
+	visible := rowIndexes[:0]
+	for start := 0; start < len(rowIndexes); start += latchBatch {
+		end := start + latchBatch
+		if end > len(rowIndexes) {
+			end = len(rowIndexes)
+		}
+
+		t.rlock()
+		for _, rowIndex := range rowIndexes[start:end] {
+			if t.visible(tx, rowIndex) {
+				visible = append(visible, rowIndex)
+			}
+		}
+		t.runlock()
+	}
+
+	return visible
+}
+
 // Implements llrb.Item interface
 type treeItem struct {
 	value memoryCell
 	index uint
 }
 
+// Items are ordered by value, and by row for equal values so that an entry of
+// a non-unique index can be found and deleted.
 func (ti treeItem) Less(than llrb.Item) bool {
-	return bytes.Compare(ti.value, than.(treeItem).value) < 0
+	other := than.(treeItem)
+	if c := bytes.Compare(ti.value, other.value); c != 0 {
+		return c < 0
+	}
+
+	return ti.index < other.index
 }
 
 type index struct {
@@ -450,27 +2347,72 @@ type index struct {
 	typ        string
 }
 
-func (i *index) addRow(t *table, rowIndex uint) error {
-	indexValue, _, _, err := t.evaluateCell(rowIndex, i.exp)
-	if err != nil {
-		return err
+// value evaluates the expression of an index on a row of t, which need not
+// be in t yet.
+func (i *index) value(t *table, row []memoryCell) (memoryCell, error) {
+	r := &table{
+		name:        t.name,
+		columns:     t.columns,
+		columnTypes: t.columnTypes,
+		rows:        [][]memoryCell{row},
 	}
 
-	if indexValue == nil {
+	value, _, _, err := r.evaluateCell(0, i.exp)
+	return value, err
+}
+
+// addRow adds a row of t to the index under its value. The table must be
+// latched.
+func (i *index) addRow(tx *Transaction, t *table, rowIndex uint, value memoryCell) error {
+	if value.IsNull() && i.primaryKey {
 		return ViolatesNonNullConstraint
 	}
 
-	if i.unique && i.tree.Has(treeItem{value: indexValue}) {
-		return ViolatesUniqueConstraint
+	// NULLs are never equal to each other, so they never break uniqueness.
+	// A value taken by a version that tx does not see was written
+	// concurrently, so tx could not have known about it.
+	if i.unique && !value.IsNull() {
+		if other, ok := i.findValue(tx, t, value); ok {
+			if t.visible(tx, other) {
+				return ViolatesUniqueConstraint
+			}
+
+			return SerializationFailure
+		}
 	}
 
 	i.tree.InsertNoReplace(treeItem{
-		value: indexValue,
+		value: value,
 		index: rowIndex,
 	})
 	return nil
 }
 
+func (i *index) removeRow(rowIndex uint, value memoryCell) {
+	i.tree.Delete(treeItem{
+		value: value,
+		index: rowIndex,
+	})
+}
+
+// findValue returns a row of t that is not dead for tx and is indexed under
+// the given non-NULL value.
+func (i *index) findValue(tx *Transaction, t *table, value memoryCell) (uint, bool) {
+	rowIndex, found := uint(0), false
+	i.tree.AscendGreaterOrEqual(treeItem{value: value}, func(item llrb.Item) bool {
+		ti := item.(treeItem)
+		if !bytes.Equal(ti.value, value) {
+			return false
+		}
+
+		// NULL has the same bytes as the empty string
+		rowIndex, found = ti.index, !ti.value.IsNull() && !t.dead(tx, ti.index)
+		return !found
+	})
+
+	return rowIndex, found
+}
+
 // Support matching for =, <>, >, <, >=, or <=
 // One of the operands is an identifier that match the index
 // The other is a literal value
@@ -499,36 +2441,68 @@ func (i *index) applicableValue(exp expression) *expression {
 		return nil
 	}
 
-	if valueExp.kind != literal {
-		fmt.Println("only index checks on literals supported")
+	// Columns, like those of another table in a join or of the enclosing
+	// query, have no value to look up
+	if valueExp.kind != literal && valueExp.kind != typedLiteralKind {
+		return nil
+	}
+
+	if valueExp.kind == literal && valueExp.literal.kind == IdentifierKind {
 		return nil
 	}
 
 	return &valueExp
 }
 
-func (i *index) newTableFromSubset(t *table, exp expression) *table {
+// rowIndexesFromSubset returns the positions of the rows whose indexed value
+// satisfies exp. The index cannot be used when the value compared to it does
+// not convert to the type of the index, as the tree compares bytes.
+func (i *index) rowIndexesFromSubset(t *table, exp expression) ([]uint, bool, error) {
 	valueExp := i.applicableValue(exp)
 	if valueExp == nil {
-		return t
+		return nil, false, nil
+	}
+
+	value, _, typ, err := newTable().evaluateCell(0, *valueExp)
+	if err != nil {
+		return nil, false, err
 	}
 
-	value, _, _, err := newTable().evaluateCell(0, *valueExp)
+	indexType, err := t.expressionType(i.exp)
 	if err != nil {
-		log.Println(err)
-		return t
+		return nil, false, err
+	}
+
+	// Intervals equal to each other can have different bytes
+	if indexType == IntervalType {
+		return nil, false, nil
+	}
+
+	if typ != indexType {
+		switch {
+		case typ == TextType && isDatetime(indexType):
+			value, err = parseDatetime(value.AsText(), indexType)
+		case isNumeric(typ) && isNumeric(indexType), typ == DateType && indexType == TimestampType:
+			value, err = castCell(value, typ, indexType)
+		default:
+			return nil, false, nil
+		}
+
+		if err != nil {
+			return nil, false, nil
+		}
 	}
 
 	tiValue := treeItem{value: value}
 
-	fmt.Println(symbol(exp.binary.op.value), symbol(exp.binary.op.value) == Equal)
+	t.rlock()
+	defer t.runlock()
+
 	indexes := []uint{}
 	switch symbol(exp.binary.op.value) {
 	case Equal:
 		i.tree.AscendGreaterOrEqual(tiValue, func(i llrb.Item) bool {
 			ti := i.(treeItem)
-
-			fmt.Println(ti.value, value)
 			if !bytes.Equal(ti.value, value) {
 				return false
 			}
@@ -537,9 +2511,9 @@ func (i *index) newTableFromSubset(t *table, exp expression) *table {
 			return true
 		})
 	case XEqual:
-		i.tree.AscendGreaterOrEqual(llrb.Int(-1), func(i llrb.Item) bool {
+		i.tree.AscendGreaterOrEqual(treeItem{}, func(i llrb.Item) bool {
 			ti := i.(treeItem)
-			if bytes.Equal(ti.value, value) {
+			if !bytes.Equal(ti.value, value) {
 				indexes = append(indexes, ti.index)
 			}
 
@@ -555,12 +2529,13 @@ func (i *index) newTableFromSubset(t *table, exp expression) *table {
 			return true
 		})
 	case LessOrEqual:
-		i.tree.DescendLessOrEqual(tiValue, func(i llrb.Item) bool {
+		i.tree.AscendGreaterOrEqual(treeItem{}, func(i llrb.Item) bool {
 			ti := i.(treeItem)
-			if bytes.Compare(ti.value, value) <= 0 {
-				indexes = append(indexes, ti.index)
+			if bytes.Compare(ti.value, value) > 0 {
+				return false
 			}
 
+			indexes = append(indexes, ti.index)
 			return true
 		})
 	case Greater:
@@ -583,17 +2558,7 @@ func (i *index) newTableFromSubset(t *table, exp expression) *table {
 		})
 	}
 
-	newT := newTable()
-	newT.columns = t.columns
-	newT.columnTypes = t.columnTypes
-	newT.indexes = t.indexes
-	newT.rows = [][]memoryCell{}
-
-	for _, index := range indexes {
-		newT.rows = append(newT.rows, t.rows[index])
-	}
-
-	return newT
+	return indexes, true, nil
 }
 
 type indexAndExpression struct {
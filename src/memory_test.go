@@ -0,0 +1,2250 @@
+package src
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// execute runs every statement of source against the backend and returns the
+// results of the last SELECT.
+func execute(t *testing.T, b Backend, source string) (*Results, error) {
+	var tx *Transaction
+	results, err := executeIn(t, b, &tx, source)
+	if tx != nil {
+		b.Rollback(tx)
+	}
+
+	return results, err
+}
+
+// executeIn runs the statements of source like execute, in the transaction
+// of a session: BEGIN opens it in *tx, and COMMIT or ROLLBACK end it.
+func executeIn(t *testing.T, b Backend, tx **Transaction, source string) (*Results, error) {
+	a, err := Parse(source)
+	if err != nil {
+		t.Fatalf("failed to parse %q: %s", source, err)
+	}
+
+	var results *Results
+	for _, stmt := range a.Statements {
+		switch stmt.Kind {
+		case BeginAstKind:
+			*tx, err = b.Begin()
+		case CommitAstKind:
+			err = b.Commit(*tx)
+			*tx = nil
+		case RollbackAstKind:
+			err = b.Rollback(*tx)
+			*tx = nil
+		case SavepointAstKind:
+			err = b.Savepoint(*tx, stmt.Savepoint)
+		case RollbackToSavepointAstKind:
+			err = b.RollbackToSavepoint(*tx, stmt.Savepoint)
+		case ReleaseSavepointAstKind:
+			err = b.ReleaseSavepoint(*tx, stmt.Savepoint)
+		case CreateAstKind:
+			err = b.CreateTable(*tx, stmt.Create)
+		case CreateIndexAstKind:
+			err = b.CreateIndex(*tx, stmt.CreateIndex)
+		case InsertAstKind:
+			err = b.Insert(*tx, stmt.Insert)
+		case UpdateAstKind:
+			_, err = b.Update(*tx, stmt.Update)
+		case DeleteAstKind:
+			_, err = b.Delete(*tx, stmt.Delete)
+		case DropTableAstKind:
+			err = b.DropTable(*tx, stmt.DropTable)
+		case DropIndexAstKind:
+			err = b.DropIndex(*tx, stmt.DropIndex)
+		case TruncateAstKind:
+			err = b.Truncate(*tx, stmt.Truncate)
+		case SelectAstKind:
+			results, err = b.Select(*tx, stmt.Select)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return results, nil
+}
+
+func TestMemoryBackend_Update(t *testing.T) {
+	mb := NewMemoryBackend()
+	_, err := execute(t, mb, "CREATE TABLE users (id INT, name TEXT); INSERT INTO users VALUES (1, 'a'); INSERT INTO users VALUES (2, 'b'); INSERT INTO users VALUES (3, 'c');")
+	assert.Nil(t, err)
+
+	a, err := Parse("UPDATE users SET name = name || '!', id = id + 10 WHERE id = 2;")
+	assert.Nil(t, err)
+	count, err := mb.Update(nil, a.Statements[0].Update)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(1), count)
+
+	// The new version of a row comes after the others
+	results, err := execute(t, mb, "SELECT id, name FROM users;")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(results.Rows))
+	assert.Equal(t, int32(3), results.Rows[1][0].AsInt())
+	assert.Equal(t, "c", results.Rows[1][1].AsText())
+	assert.Equal(t, int32(12), results.Rows[2][0].AsInt())
+	assert.Equal(t, "b!", results.Rows[2][1].AsText())
+
+	a, err = Parse("UPDATE users SET name = 'x';")
+	assert.Nil(t, err)
+	count, err = mb.Update(nil, a.Statements[0].Update)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(3), count)
+
+	_, err = execute(t, mb, "UPDATE users SET missing = 1;")
+	assert.Equal(t, ColumnDoesNotExist, err)
+
+	// Values fit their column like on INSERT
+	_, err = execute(t, mb, "UPDATE users SET id = 'one';")
+	assert.Equal(t, &ColumnTypeError{Column: "id", Expected: IntType, Actual: TextType}, err)
+
+	_, err = execute(t, mb, "UPDATE users SET id = ' 5 ' WHERE id = 1; UPDATE users SET name = 7 WHERE id = 5;")
+	assert.Nil(t, err)
+
+	results, err = execute(t, mb, "SELECT id, name FROM users WHERE id = 5;")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(results.Rows))
+	assert.Equal(t, "7", results.Rows[0][1].AsText())
+}
+
+func TestMemoryBackend_UpdateIndexes(t *testing.T) {
+	mb := NewMemoryBackend()
+	_, err := execute(t, mb, "CREATE TABLE users (id INT PRIMARY KEY, name TEXT); INSERT INTO users VALUES (1, 'a'); INSERT INTO users VALUES (2, 'b');")
+	assert.Nil(t, err)
+
+	users := mb.tables["users"]
+	pkey := users.indexes[0]
+	hasValue := func(value memoryCell) bool {
+		_, ok := pkey.findValue(nil, users, value)
+		return ok
+	}
+	ids := func() []int32 {
+		results, err := execute(t, mb, "SELECT id FROM users;")
+		assert.Nil(t, err)
+
+		ids := []int32{}
+		for _, row := range results.Rows {
+			ids = append(ids, row[0].AsInt())
+		}
+		return ids
+	}
+
+	// Every row moves at once, so intermediate collisions are fine
+	_, err = execute(t, mb, "UPDATE users SET id = id + 1;")
+	assert.Nil(t, err)
+	assert.Equal(t, []int32{2, 3}, ids())
+	assert.False(t, hasValue(intCell(1)))
+	assert.True(t, hasValue(intCell(2)))
+	assert.True(t, hasValue(intCell(3)))
+	assert.Equal(t, 2, pkey.tree.Len())
+
+	// A collision leaves the table and the index untouched
+	_, err = execute(t, mb, "UPDATE users SET id = 3 WHERE id = 2;")
+	assert.Equal(t, ViolatesUniqueConstraint, err)
+	assert.Equal(t, []int32{2, 3}, ids())
+	assert.True(t, hasValue(intCell(2)))
+	assert.True(t, hasValue(intCell(3)))
+	assert.Equal(t, 2, pkey.tree.Len())
+}
+
+func TestMemoryBackend_Delete(t *testing.T) {
+	mb := NewMemoryBackend()
+	_, err := execute(t, mb, "CREATE TABLE users (id INT PRIMARY KEY, name TEXT); INSERT INTO users VALUES (1, 'a'); INSERT INTO users VALUES (2, 'b'); INSERT INTO users VALUES (3, 'c');")
+	assert.Nil(t, err)
+
+	users := mb.tables["users"]
+	pkey := users.indexes[0]
+
+	a, err := Parse("DELETE FROM users WHERE id = 2;")
+	assert.Nil(t, err)
+	count, err := mb.Delete(nil, a.Statements[0].Delete)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(1), count)
+	assert.Equal(t, 2, pkey.tree.Len())
+
+	// Positions of the remaining rows are still valid in the index
+	results, err := execute(t, mb, "SELECT id, name FROM users WHERE id = 3;")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(results.Rows))
+	assert.Equal(t, "c", results.Rows[0][1].AsText())
+
+	results, err = execute(t, mb, "SELECT id FROM users;")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(results.Rows))
+	assert.Equal(t, int32(1), results.Rows[0][0].AsInt())
+	assert.Equal(t, int32(3), results.Rows[1][0].AsInt())
+
+	_, err = execute(t, mb, "UPDATE users SET name = 'z';")
+	assert.Nil(t, err)
+
+	a, err = Parse("DELETE FROM users;")
+	assert.Nil(t, err)
+	count, err = mb.Delete(nil, a.Statements[0].Delete)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(2), count)
+	assert.Equal(t, 0, pkey.tree.Len())
+
+	results, err = execute(t, mb, "SELECT id FROM users;")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(results.Rows))
+}
+
+func TestMemoryBackend_DropAndTruncate(t *testing.T) {
+	mb := NewMemoryBackend()
+	_, err := execute(t, mb, "CREATE TABLE users (id INT PRIMARY KEY, name TEXT); INSERT INTO users VALUES (1, 'a'); INSERT INTO users VALUES (2, 'b');")
+	assert.Nil(t, err)
+
+	users := mb.tables["users"]
+	pkey := users.indexes[0]
+
+	_, err = execute(t, mb, "TRUNCATE users;")
+	assert.Nil(t, err)
+	rowIndexes, err := users.candidateRows(nil, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(rowIndexes))
+	assert.Equal(t, 0, pkey.tree.Len())
+
+	_, err = execute(t, mb, "INSERT INTO users VALUES (1, 'a');")
+	assert.Nil(t, err)
+	rowIndexes, err = users.candidateRows(nil, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(rowIndexes))
+
+	_, err = execute(t, mb, "DROP INDEX users_pkey;")
+	assert.Equal(t, CannotDropPrimaryKey, err)
+
+	_, err = execute(t, mb, "DROP INDEX missing;")
+	assert.Equal(t, IndexDoesNotExist, err)
+
+	_, err = execute(t, mb, "DROP INDEX IF EXISTS missing;")
+	assert.Nil(t, err)
+
+	_, err = execute(t, mb, "DROP TABLE users;")
+	assert.Nil(t, err)
+
+	_, err = execute(t, mb, "SELECT id FROM users;")
+	assert.Equal(t, TableDoesNotExists, err)
+
+	_, err = execute(t, mb, "DROP TABLE users;")
+	assert.Equal(t, TableDoesNotExists, err)
+
+	_, err = execute(t, mb, "DROP TABLE IF EXISTS users; CREATE TABLE users (id INT PRIMARY KEY);")
+	assert.Nil(t, err)
+
+	_, err = execute(t, mb, "TRUNCATE TABLE missing;")
+	assert.Equal(t, TableDoesNotExists, err)
+}
+
+func TestMemoryBackend_CreateIndex(t *testing.T) {
+	mb := NewMemoryBackend()
+	_, err := execute(t, mb, "CREATE TABLE users (id INT, name TEXT); INSERT INTO users VALUES (1, 'a'); INSERT INTO users VALUES (2, 'b'); INSERT INTO users VALUES (3, 'b');")
+	assert.Nil(t, err)
+
+	_, err = execute(t, mb, "CREATE UNIQUE INDEX users_name ON users (name);")
+	assert.Equal(t, ViolatesUniqueConstraint, err)
+	assert.Equal(t, 0, len(mb.tables["users"].indexes))
+
+	_, err = execute(t, mb, "CREATE INDEX users_name ON users (name); CREATE UNIQUE INDEX users_id ON users (id);")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, mb.tables["users"].indexes[0].tree.Len())
+	assert.Equal(t, 3, mb.tables["users"].indexes[1].tree.Len())
+
+	_, err = execute(t, mb, "CREATE INDEX users_id ON users (name);")
+	assert.Equal(t, IndexAlreadyExists, err)
+
+	results, err := execute(t, mb, "SELECT id FROM users WHERE name = 'b';")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(results.Rows))
+	assert.Equal(t, int32(2), results.Rows[0][0].AsInt())
+	assert.Equal(t, int32(3), results.Rows[1][0].AsInt())
+
+	_, err = execute(t, mb, "DROP INDEX users_name; CREATE INDEX users_name ON users (id);")
+	assert.Nil(t, err)
+}
+
+func TestMemoryBackend_InsertIndexes(t *testing.T) {
+	mb := NewMemoryBackend()
+	_, err := execute(t, mb, "CREATE TABLE users (id INT PRIMARY KEY, name TEXT); CREATE UNIQUE INDEX users_name ON users (name); INSERT INTO users VALUES (1, 'a'); INSERT INTO users VALUES (2, 'b');")
+	assert.Nil(t, err)
+
+	users := mb.tables["users"]
+	pkey := users.indexes[0]
+	name := users.indexes[1]
+	assert.Equal(t, 2, pkey.tree.Len())
+	assert.Equal(t, 2, name.tree.Len())
+
+	_, err = execute(t, mb, "INSERT INTO users VALUES (1, 'c');")
+	assert.Equal(t, ViolatesUniqueConstraint, err)
+
+	// Rejected by the second index, so the first one has to let go of it
+	_, err = execute(t, mb, "INSERT INTO users VALUES (3, 'a');")
+	assert.Equal(t, ViolatesUniqueConstraint, err)
+	assert.Equal(t, 2, len(users.rows))
+	assert.Equal(t, 2, pkey.tree.Len())
+	assert.Equal(t, 2, name.tree.Len())
+
+	_, err = execute(t, mb, "INSERT INTO users VALUES (3, 'c');")
+	assert.Nil(t, err)
+
+	tests := []struct {
+		query string
+		ids   []int32
+	}{
+		{
+			query: "SELECT id FROM users WHERE id = 2;",
+			ids:   []int32{2},
+		},
+		{
+			query: "SELECT id FROM users WHERE name = 'c';",
+			ids:   []int32{3},
+		},
+		{
+			query: "SELECT id FROM users WHERE id != 2;",
+			ids:   []int32{1, 3},
+		},
+	}
+
+	for _, test := range tests {
+		results, err := execute(t, mb, test.query)
+		assert.Nil(t, err, test.query)
+
+		ids := []int32{}
+		for _, row := range results.Rows {
+			ids = append(ids, row[0].AsInt())
+		}
+		assert.Equal(t, test.ids, ids, test.query)
+	}
+}
+
+func TestMemoryBackend_comparisons(t *testing.T) {
+	mb := NewMemoryBackend()
+	_, err := execute(t, mb, "CREATE TABLE users (id INT PRIMARY KEY, age INT, name TEXT); INSERT INTO users VALUES (1, 30, 'carol'); INSERT INTO users VALUES (2, 10, 'alice'); INSERT INTO users VALUES (3, 20, 'bob'); INSERT INTO users VALUES (300, 20, 'dave');")
+	assert.Nil(t, err)
+
+	tests := []struct {
+		query string
+		ids   []int32
+		err   error
+	}{
+		// Through the primary key index
+		{query: "SELECT id FROM users WHERE id > 2;", ids: []int32{3, 300}},
+		{query: "SELECT id FROM users WHERE id >= 2;", ids: []int32{2, 3, 300}},
+		{query: "SELECT id FROM users WHERE id < 3;", ids: []int32{1, 2}},
+		{query: "SELECT id FROM users WHERE id <= 3;", ids: []int32{1, 2, 3}},
+		{query: "SELECT id FROM users WHERE id <> 2;", ids: []int32{1, 3, 300}},
+		{query: "SELECT id FROM users WHERE id != 2;", ids: []int32{1, 3, 300}},
+		// Without an index
+		{query: "SELECT id FROM users WHERE age > 10;", ids: []int32{1, 3, 300}},
+		{query: "SELECT id FROM users WHERE age <= 20;", ids: []int32{2, 3, 300}},
+		{query: "SELECT id FROM users WHERE name < 'bob';", ids: []int32{2}},
+		{query: "SELECT id FROM users WHERE name >= 'bob';", ids: []int32{1, 3, 300}},
+		{query: "SELECT id FROM users WHERE 15 < age;", ids: []int32{1, 3, 300}},
+		{query: "SELECT id FROM users WHERE NOT age = 20;", ids: []int32{1, 2}},
+		{query: "SELECT id FROM users WHERE NOT name > 'bob';", ids: []int32{2, 3}},
+		{query: "SELECT id FROM users WHERE NOT NOT id = 3;", ids: []int32{3}},
+		// Type errors
+		{query: "SELECT id FROM users WHERE name > 1;", err: InvalidOperands},
+		{query: "SELECT id FROM users WHERE NOT name;", err: InvalidOperands},
+	}
+
+	for _, test := range tests {
+		results, err := execute(t, mb, test.query)
+		assert.Equal(t, test.err, err, test.query)
+		if err != nil {
+			continue
+		}
+
+		ids := []int32{}
+		for _, row := range results.Rows {
+			ids = append(ids, row[0].AsInt())
+		}
+		assert.Equal(t, test.ids, ids, test.query)
+	}
+}
+
+func TestMemoryBackend_arithmetic(t *testing.T) {
+	mb := NewMemoryBackend()
+	_, err := execute(t, mb, "CREATE TABLE t (id INT PRIMARY KEY, a INT, b INT); INSERT INTO t VALUES (-5, 7, 2); INSERT INTO t VALUES (0, -7, 2); INSERT INTO t VALUES (5, 2147483647, -1);")
+	assert.Nil(t, err)
+
+	tests := []struct {
+		query  string
+		values []int32
+		err    error
+	}{
+		{query: "SELECT a - b FROM t WHERE id = -5;", values: []int32{5}},
+		{query: "SELECT a + b * 3 FROM t WHERE id = -5;", values: []int32{13}},
+		{query: "SELECT (a + b) * 3 FROM t WHERE id = -5;", values: []int32{27}},
+		{query: "SELECT a / b FROM t WHERE id <= 0;", values: []int32{3, -3}},
+		{query: "SELECT a % b FROM t WHERE id <= 0;", values: []int32{1, -1}},
+		{query: "SELECT -a FROM t WHERE id < 5;", values: []int32{-7, 7}},
+		{query: "SELECT id FROM t WHERE id < 0;", values: []int32{-5}},
+		{query: "SELECT id FROM t WHERE id >= -5;", values: []int32{-5, 0, 5}},
+		{query: "SELECT id FROM t WHERE id > -1;", values: []int32{0, 5}},
+		{query: "SELECT id FROM t WHERE -id = 5;", values: []int32{-5}},
+		{query: "SELECT a * b FROM t WHERE id = 5;", values: []int32{-2147483647}},
+		{query: "SELECT a - b FROM t WHERE id = 5;", err: IntegerOutOfRange},
+		{query: "SELECT a * a FROM t WHERE id = 5;", err: IntegerOutOfRange},
+		{query: "SELECT a / id FROM t WHERE id = 0;", err: DivisionByZero},
+		{query: "SELECT a % id FROM t WHERE id = 0;", err: DivisionByZero},
+		{query: "SELECT id FROM t WHERE id = 1 / 0 AND id > 10;", err: DivisionByZero},
+		{query: "SELECT -(a - b - 1) FROM t WHERE id = 5;", err: IntegerOutOfRange},
+	}
+
+	for _, test := range tests {
+		results, err := execute(t, mb, test.query)
+		assert.Equal(t, test.err, err, test.query)
+		if err != nil {
+			continue
+		}
+
+		values := []int32{}
+		for _, row := range results.Rows {
+			values = append(values, row[0].AsInt())
+		}
+		assert.Equal(t, test.values, values, test.query)
+	}
+}
+
+func TestTable_threeValuedLogic(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected string
+	}{
+		{source: "NULL AND true", expected: "null"},
+		{source: "NULL AND false", expected: "false"},
+		{source: "false AND NULL", expected: "false"},
+		{source: "NULL AND NULL", expected: "null"},
+		{source: "NULL OR true", expected: "true"},
+		{source: "true OR NULL", expected: "true"},
+		{source: "NULL OR false", expected: "null"},
+		{source: "NULL OR NULL", expected: "null"},
+		{source: "NOT NULL", expected: "null"},
+		{source: "NOT false", expected: "true"},
+		{source: "NULL = NULL", expected: "null"},
+		{source: "1 = NULL", expected: "null"},
+		{source: "1 <> NULL", expected: "null"},
+		{source: "1 < NULL", expected: "null"},
+		{source: "NULL + 1 = 2", expected: "null"},
+		{source: "NULL IS NULL", expected: "true"},
+		{source: "NULL IS NOT NULL", expected: "false"},
+		{source: "1 IS NULL", expected: "false"},
+		{source: "false IS NULL", expected: "false"},
+		{source: "'' IS NULL", expected: "false"},
+		{source: "1 + NULL IS NULL", expected: "true"},
+		{source: "1 = 1 IS NOT NULL", expected: "true"},
+		{source: "false = false", expected: "true"},
+		{source: "false <> true", expected: "true"},
+	}
+
+	for _, test := range tests {
+		exp, err := parseExpressionSource(test.source)
+		assert.Nil(t, err, test.source)
+
+		value, _, typ, err := newTable().evaluateCell(0, *exp)
+		assert.Nil(t, err, test.source)
+		assert.Equal(t, BoolType, typ, test.source)
+
+		result := "false"
+		if value.IsNull() {
+			result = "null"
+		} else if value.AsBool() {
+			result = "true"
+		}
+		assert.Equal(t, test.expected, result, test.source)
+	}
+}
+
+func TestMemoryBackend_null(t *testing.T) {
+	mb := NewMemoryBackend()
+	_, err := execute(t, mb, "CREATE TABLE users (id INT PRIMARY KEY, name TEXT NULL, age INT NOT NULL); INSERT INTO users VALUES (1, NULL, 10); INSERT INTO users VALUES (2, 'b', 20); INSERT INTO users VALUES (3, '', 30);")
+	assert.Nil(t, err)
+
+	_, err = execute(t, mb, "INSERT INTO users VALUES (NULL, 'x', 1);")
+	assert.Equal(t, ViolatesNonNullConstraint, err)
+
+	_, err = execute(t, mb, "INSERT INTO users VALUES (4, 'x', NULL);")
+	assert.Equal(t, ViolatesNonNullConstraint, err)
+
+	_, err = execute(t, mb, "UPDATE users SET age = NULL WHERE id = 1;")
+	assert.Equal(t, ViolatesNonNullConstraint, err)
+
+	// NULLs do not collide in unique indexes, and are not the empty string
+	_, err = execute(t, mb, "CREATE UNIQUE INDEX users_name ON users (name); INSERT INTO users VALUES (4, NULL, 40);")
+	assert.Nil(t, err)
+
+	_, err = execute(t, mb, "INSERT INTO users VALUES (5, '', 50);")
+	assert.Equal(t, ViolatesUniqueConstraint, err)
+
+	tests := []struct {
+		query string
+		ids   []int32
+	}{
+		{query: "SELECT id FROM users WHERE name IS NULL;", ids: []int32{1, 4}},
+		{query: "SELECT id FROM users WHERE name IS NOT NULL;", ids: []int32{2, 3}},
+		{query: "SELECT id FROM users WHERE name = NULL;", ids: []int32{}},
+		{query: "SELECT id FROM users WHERE name = '';", ids: []int32{3}},
+		{query: "SELECT id FROM users WHERE name <> 'b';", ids: []int32{3}},
+		{query: "SELECT id FROM users WHERE NOT name = 'b';", ids: []int32{3}},
+		{query: "SELECT id FROM users WHERE name = 'b' OR name IS NULL;", ids: []int32{1, 2, 4}},
+		{query: "SELECT id FROM users WHERE name < 'c';", ids: []int32{2, 3}},
+	}
+
+	for _, test := range tests {
+		results, err := execute(t, mb, test.query)
+		assert.Nil(t, err, test.query)
+
+		ids := []int32{}
+		for _, row := range results.Rows {
+			ids = append(ids, row[0].AsInt())
+		}
+		assert.Equal(t, test.ids, ids, test.query)
+	}
+
+	_, err = execute(t, mb, "UPDATE users SET name = NULL WHERE id = 2;")
+	assert.Nil(t, err)
+
+	results, err := execute(t, mb, "SELECT name FROM users WHERE id = 2;")
+	assert.Nil(t, err)
+	assert.True(t, results.Rows[0][0].IsNull())
+}
+
+func TestMemoryBackend_orderBy(t *testing.T) {
+	mb := NewMemoryBackend()
+	_, err := execute(t, mb, "CREATE TABLE users (id INT PRIMARY KEY, age INT, name TEXT); INSERT INTO users VALUES (1, 30, 'carol'); INSERT INTO users VALUES (2, -10, 'alice'); INSERT INTO users VALUES (3, 20, NULL); INSERT INTO users VALUES (4, NULL, 'bob'); INSERT INTO users VALUES (5, 20, 'dave'); INSERT INTO users VALUES (6, -300, 'alice');")
+	assert.Nil(t, err)
+
+	tests := []struct {
+		query string
+		ids   []int32
+	}{
+		// Negative ints sort before positive ones, NULLs last when ascending
+		{query: "SELECT id FROM users ORDER BY age;", ids: []int32{6, 2, 3, 5, 1, 4}},
+		{query: "SELECT id FROM users ORDER BY age ASC;", ids: []int32{6, 2, 3, 5, 1, 4}},
+		// NULLs first when descending, and ties keep their order
+		{query: "SELECT id FROM users ORDER BY age DESC;", ids: []int32{4, 1, 3, 5, 2, 6}},
+		{query: "SELECT id FROM users ORDER BY age NULLS FIRST;", ids: []int32{4, 6, 2, 3, 5, 1}},
+		{query: "SELECT id FROM users ORDER BY age DESC NULLS LAST;", ids: []int32{1, 3, 5, 2, 6, 4}},
+		{query: "SELECT id FROM users ORDER BY name, id DESC;", ids: []int32{6, 2, 4, 1, 5, 3}},
+		{query: "SELECT id FROM users ORDER BY age DESC, name DESC NULLS LAST;", ids: []int32{4, 1, 5, 3, 2, 6}},
+		{query: "SELECT id FROM users WHERE age > 0 ORDER BY -age;", ids: []int32{1, 3, 5}},
+		// Aliases of select items
+		{query: "SELECT id, age * -1 AS x FROM users ORDER BY x;", ids: []int32{1, 3, 5, 2, 6, 4}},
+		{query: "SELECT id AS age FROM users ORDER BY age DESC;", ids: []int32{6, 5, 4, 3, 2, 1}},
+		// Columns that are not selected
+		{query: "SELECT id FROM users WHERE id < 4 ORDER BY name NULLS FIRST;", ids: []int32{3, 2, 1}},
+	}
+
+	for _, test := range tests {
+		results, err := execute(t, mb, test.query)
+		assert.Nil(t, err, test.query)
+
+		ids := []int32{}
+		for _, row := range results.Rows {
+			ids = append(ids, row[0].AsInt())
+		}
+		assert.Equal(t, test.ids, ids, test.query)
+	}
+
+	_, err = execute(t, mb, "SELECT id FROM users ORDER BY missing;")
+	assert.Equal(t, ColumnDoesNotExist, err)
+}
+
+func TestMemoryBackend_limit(t *testing.T) {
+	mb := NewMemoryBackend()
+	_, err := execute(t, mb, "CREATE TABLE t (id INT PRIMARY KEY, a INT); INSERT INTO t VALUES (1, 10); INSERT INTO t VALUES (2, 20); INSERT INTO t VALUES (3, 10); INSERT INTO t VALUES (4, 20); INSERT INTO t VALUES (5, 10);")
+	assert.Nil(t, err)
+
+	tests := []struct {
+		query string
+		ids   []int32
+		err   error
+	}{
+		{query: "SELECT id FROM t LIMIT 2;", ids: []int32{1, 2}},
+		{query: "SELECT id FROM t LIMIT 0;", ids: []int32{}},
+		{query: "SELECT id FROM t LIMIT 10;", ids: []int32{1, 2, 3, 4, 5}},
+		{query: "SELECT id FROM t LIMIT NULL;", ids: []int32{1, 2, 3, 4, 5}},
+		{query: "SELECT id FROM t LIMIT 1 + 1 OFFSET 2;", ids: []int32{3, 4}},
+		{query: "SELECT id FROM t OFFSET 3;", ids: []int32{4, 5}},
+		{query: "SELECT id FROM t LIMIT 2 OFFSET 4;", ids: []int32{5}},
+		{query: "SELECT id FROM t LIMIT 2 OFFSET 5;", ids: []int32{}},
+		{query: "SELECT id FROM t OFFSET 100;", ids: []int32{}},
+		// With WHERE filters, through an index and without
+		{query: "SELECT id FROM t WHERE id > 1 LIMIT 2;", ids: []int32{2, 3}},
+		{query: "SELECT id FROM t WHERE id >= 2 LIMIT 2 OFFSET 2;", ids: []int32{4, 5}},
+		{query: "SELECT id FROM t WHERE a = 10 LIMIT 2;", ids: []int32{1, 3}},
+		{query: "SELECT id FROM t WHERE a = 10 LIMIT 2 OFFSET 1;", ids: []int32{3, 5}},
+		{query: "SELECT id FROM t WHERE a = 20 OFFSET 2;", ids: []int32{}},
+		// With ORDER BY, rows are sorted before being cut
+		{query: "SELECT id FROM t ORDER BY id DESC LIMIT 2;", ids: []int32{5, 4}},
+		{query: "SELECT id FROM t WHERE a = 10 ORDER BY id DESC LIMIT 2 OFFSET 1;", ids: []int32{3, 1}},
+		{query: "SELECT id FROM t ORDER BY a, id LIMIT 3 OFFSET 2;", ids: []int32{5, 2, 4}},
+		{query: "SELECT id FROM t ORDER BY id OFFSET 10;", ids: []int32{}},
+		// Rows past the limit are never evaluated
+		{query: "SELECT id, 10 / (3 - id) FROM t LIMIT 2;", ids: []int32{1, 2}},
+		{query: "SELECT id, 10 / (id - 1) FROM t LIMIT 1 OFFSET 1;", ids: []int32{2}},
+		{query: "SELECT id, 10 / (3 - id) FROM t LIMIT 3;", err: DivisionByZero},
+		{query: "SELECT id FROM t LIMIT -1;", err: InvalidLimit},
+		{query: "SELECT id FROM t OFFSET 'a';", err: InvalidLimit},
+	}
+
+	for _, test := range tests {
+		results, err := execute(t, mb, test.query)
+		assert.Equal(t, test.err, err, test.query)
+		if err != nil {
+			continue
+		}
+
+		ids := []int32{}
+		for _, row := range results.Rows {
+			ids = append(ids, row[0].AsInt())
+		}
+		assert.Equal(t, test.ids, ids, test.query)
+	}
+}
+
+func TestMemoryBackend_aggregates(t *testing.T) {
+	mb := NewMemoryBackend()
+	_, err := execute(t, mb, "CREATE TABLE sales (id INT PRIMARY KEY, region TEXT, amount INT); INSERT INTO sales VALUES (1, 'north', 10); INSERT INTO sales VALUES (2, 'south', 5); INSERT INTO sales VALUES (3, 'north', -4); INSERT INTO sales VALUES (4, NULL, 7); INSERT INTO sales VALUES (5, 'south', NULL); INSERT INTO sales VALUES (6, 'north', 3);")
+	assert.Nil(t, err)
+
+	tests := []struct {
+		query   string
+		columns []ResultsColumn
+		rows    [][]string
+		err     error
+	}{
+		{
+			query:   "SELECT count(*), count(amount), sum(amount), min(amount), max(amount), avg(amount) FROM sales;",
+			columns: []ResultsColumn{{BigIntType, "count"}, {BigIntType, "count"}, {BigIntType, "sum"}, {IntType, "min"}, {IntType, "max"}, {FloatType, "avg"}},
+			rows:    [][]string{{"6", "5", "21", "-4", "10", "4.2"}},
+		},
+		{
+			query:   "SELECT min(region), max(region) FROM sales;",
+			columns: []ResultsColumn{{TextType, "min"}, {TextType, "max"}},
+			rows:    [][]string{{"north", "south"}},
+		},
+		{
+			query:   "SELECT count(*), sum(amount) FROM sales WHERE amount > 100;",
+			columns: []ResultsColumn{{BigIntType, "count"}, {BigIntType, "sum"}},
+			rows:    [][]string{{"0", "NULL"}},
+		},
+		{
+			query:   "SELECT region, count(*), sum(amount) FROM sales GROUP BY region ORDER BY region;",
+			columns: []ResultsColumn{{TextType, "region"}, {BigIntType, "count"}, {BigIntType, "sum"}},
+			rows:    [][]string{{"north", "3", "9"}, {"south", "2", "5"}, {"NULL", "1", "7"}},
+		},
+		{
+			query:   "SELECT region FROM sales WHERE id > 1 GROUP BY region HAVING count(*) > 1 ORDER BY region;",
+			columns: []ResultsColumn{{TextType, "region"}},
+			rows:    [][]string{{"north"}, {"south"}},
+		},
+		{
+			query:   "SELECT region, max(amount) - min(amount) FROM sales GROUP BY region HAVING sum(amount) > 6 ORDER BY max(amount) - min(amount) DESC;",
+			columns: []ResultsColumn{{TextType, "region"}, {IntType, "?column?"}},
+			rows:    [][]string{{"north", "14"}, {"NULL", "0"}},
+		},
+		{
+			query:   "SELECT amount > 0, count(*) FROM sales GROUP BY amount > 0 ORDER BY count(*) DESC LIMIT 2;",
+			columns: []ResultsColumn{{BoolType, "?column?"}, {BigIntType, "count"}},
+			rows:    [][]string{{"true", "4"}, {"false", "1"}},
+		},
+		{
+			query:   "SELECT count(*) FROM sales GROUP BY region, amount % 2 ORDER BY count(*);",
+			columns: []ResultsColumn{{BigIntType, "count"}},
+			rows:    [][]string{{"1"}, {"1"}, {"1"}, {"1"}, {"2"}},
+		},
+		// Select items are grouped by position or alias, while names
+		// of columns win over aliases
+		{
+			query:   "SELECT amount > 0 AS positive, count(*) FROM sales GROUP BY positive ORDER BY positive;",
+			columns: []ResultsColumn{{BoolType, "positive"}, {BigIntType, "count"}},
+			rows:    [][]string{{"false", "1"}, {"true", "4"}, {"NULL", "1"}},
+		},
+		{
+			query:   "SELECT amount % 2, count(*) FROM sales GROUP BY 1 ORDER BY count(*) DESC;",
+			columns: []ResultsColumn{{IntType, "?column?"}, {BigIntType, "count"}},
+			rows:    [][]string{{"1", "3"}, {"0", "2"}, {"NULL", "1"}},
+		},
+		{query: "SELECT id % 2 AS amount, count(*) FROM sales GROUP BY amount;", err: ColumnNotGrouped},
+		{query: "SELECT region, count(*) FROM sales GROUP BY 3;", err: InvalidGroupByPosition},
+		{query: "SELECT region, count(*) FROM sales GROUP BY 0;", err: InvalidGroupByPosition},
+		{query: "SELECT region, count(*) FROM sales GROUP BY 1.5;", err: InvalidGroupByPosition},
+		{query: "SELECT count(*) AS n FROM sales GROUP BY n;", err: AggregateNotAllowed},
+		{query: "SELECT region, count(*) FROM sales;", err: ColumnNotGrouped},
+		{query: "SELECT amount FROM sales GROUP BY region;", err: ColumnNotGrouped},
+		{query: "SELECT region FROM sales GROUP BY region HAVING amount > 1;", err: ColumnNotGrouped},
+		{query: "SELECT region FROM sales GROUP BY region ORDER BY amount;", err: ColumnNotGrouped},
+		{query: "SELECT amount + 1 FROM sales GROUP BY amount;", err: nil},
+		{query: "SELECT id FROM sales WHERE count(*) > 1;", err: AggregateNotAllowed},
+		{query: "SELECT sum(count(*)) FROM sales;", err: AggregateNotAllowed},
+		{query: "SELECT count(*) FROM sales GROUP BY count(*);", err: AggregateNotAllowed},
+		{query: "SELECT sum(region) FROM sales;", err: InvalidOperands},
+		{query: "SELECT sum(*) FROM sales;", err: FunctionDoesNotExist},
+		{query: "SELECT sum(amount, id) FROM sales;", err: FunctionDoesNotExist},
+		{query: "SELECT nope(amount) FROM sales;", err: FunctionDoesNotExist},
+	}
+
+	for _, test := range tests {
+		results, err := execute(t, mb, test.query)
+		assert.Equal(t, test.err, err, test.query)
+		if err != nil || test.columns == nil {
+			continue
+		}
+
+		assert.Equal(t, test.columns, results.Columns, test.query)
+
+		rows := [][]string{}
+		for _, row := range results.Rows {
+			var cells []string
+			for i, cell := range row {
+				switch {
+				case cell.IsNull():
+					cells = append(cells, "NULL")
+				case test.columns[i].Type == IntType:
+					cells = append(cells, fmt.Sprintf("%d", cell.AsInt()))
+				case test.columns[i].Type == BigIntType:
+					cells = append(cells, fmt.Sprintf("%d", cell.AsInt64()))
+				case test.columns[i].Type == FloatType:
+					cells = append(cells, fmt.Sprintf("%g", cell.AsFloat()))
+				case test.columns[i].Type == BoolType:
+					cells = append(cells, fmt.Sprintf("%t", cell.AsBool()))
+				default:
+					cells = append(cells, cell.AsText())
+				}
+			}
+			rows = append(rows, cells)
+		}
+		assert.Equal(t, test.rows, rows, test.query)
+	}
+
+	// There are no groups at all when no row matches a GROUP BY
+	results, err := execute(t, mb, "SELECT region, count(*) FROM sales WHERE id < 0 GROUP BY region;")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(results.Rows))
+
+	results, err = execute(t, mb, "SELECT region, count(*) AS n FROM sales GROUP BY region ORDER BY n DESC LIMIT 1;")
+	assert.Nil(t, err)
+	assert.Equal(t, "north", results.Rows[0][0].AsText())
+}
+
+func TestMemoryBackend_join(t *testing.T) {
+	mb := NewMemoryBackend()
+	_, err := execute(t, mb, "CREATE TABLE users (id INT PRIMARY KEY, name TEXT); INSERT INTO users VALUES (1, 'alice'); INSERT INTO users VALUES (2, 'bob'); INSERT INTO users VALUES (3, 'carol');")
+	assert.Nil(t, err)
+	_, err = execute(t, mb, "CREATE TABLE orders (id INT PRIMARY KEY, user_id INT, total INT); INSERT INTO orders VALUES (10, 1, 5); INSERT INTO orders VALUES (11, 1, 7); INSERT INTO orders VALUES (12, 2, 3); INSERT INTO orders VALUES (13, 4, 9); INSERT INTO orders VALUES (14, NULL, 1);")
+	assert.Nil(t, err)
+
+	tests := []struct {
+		query string
+		rows  [][]string
+		err   error
+	}{
+		{
+			query: "SELECT users.name, orders.id FROM users JOIN orders ON users.id = orders.user_id;",
+			rows:  [][]string{{"alice", "10"}, {"alice", "11"}, {"bob", "12"}},
+		},
+		{
+			query: "SELECT u.name, o.total FROM users AS u INNER JOIN orders o ON o.user_id = u.id AND o.total > 4;",
+			rows:  [][]string{{"alice", "5"}, {"alice", "7"}},
+		},
+		{
+			query: "SELECT name, o.id FROM users u LEFT JOIN orders o ON u.id = o.user_id;",
+			rows:  [][]string{{"alice", "10"}, {"alice", "11"}, {"bob", "12"}, {"carol", "NULL"}},
+		},
+		{
+			query: "SELECT name, o.id FROM users u RIGHT OUTER JOIN orders o ON u.id = o.user_id;",
+			rows:  [][]string{{"alice", "10"}, {"alice", "11"}, {"bob", "12"}, {"NULL", "13"}, {"NULL", "14"}},
+		},
+		{
+			query: "SELECT name, o.id FROM users u FULL JOIN orders o ON u.id = o.user_id;",
+			rows:  [][]string{{"alice", "10"}, {"alice", "11"}, {"bob", "12"}, {"carol", "NULL"}, {"NULL", "13"}, {"NULL", "14"}},
+		},
+		// Not an equi-join, so every pair is tried
+		{
+			query: "SELECT u.id, o.id FROM users u JOIN orders o ON o.total < u.id;",
+			rows:  [][]string{{"2", "14"}, {"3", "14"}},
+		},
+		{
+			query: "SELECT u.id, o.id FROM users u LEFT JOIN orders o ON u.id = o.user_id OR o.total = 9 WHERE u.id > 1;",
+			rows:  [][]string{{"2", "12"}, {"2", "13"}, {"3", "13"}},
+		},
+		// Comma and CROSS joins pair every row
+		{
+			query: "SELECT u.id, o.id FROM users u, orders o WHERE u.id = o.user_id AND o.total < 6;",
+			rows:  [][]string{{"1", "10"}, {"2", "12"}},
+		},
+		{
+			query: "SELECT count(*) FROM users CROSS JOIN orders;",
+			rows:  [][]string{{"15"}},
+		},
+		// Three tables, with a table joined to itself
+		{
+			query: "SELECT a.name, b.name FROM users a JOIN orders o ON a.id = o.user_id JOIN users b ON b.id = o.user_id + 1 WHERE o.total > 5;",
+			rows:  [][]string{{"alice", "bob"}},
+		},
+		{
+			query: "SELECT u.name, count(o.id) FROM users u LEFT JOIN orders o ON u.id = o.user_id GROUP BY u.name ORDER BY count(o.id) DESC, u.name;",
+			rows:  [][]string{{"alice", "2"}, {"bob", "1"}, {"carol", "0"}},
+		},
+		{query: "SELECT id FROM users JOIN orders ON users.id = orders.user_id;", err: AmbiguousColumn},
+		{query: "SELECT users.total FROM users JOIN orders ON users.id = orders.user_id;", err: ColumnDoesNotExist},
+		{query: "SELECT u.id FROM users u JOIN orders u ON u.id = 1;", err: DuplicateTableName},
+		{query: "SELECT users.id FROM users u;", err: ColumnDoesNotExist},
+		{query: "SELECT 1 FROM users JOIN missing ON true;", err: TableDoesNotExists},
+		{query: "SELECT 1 FROM users JOIN orders ON users.id;", err: InvalidOperands},
+	}
+
+	for _, test := range tests {
+		results, err := execute(t, mb, test.query)
+		assert.Equal(t, test.err, err, test.query)
+		if err != nil {
+			continue
+		}
+
+		rows := [][]string{}
+		for _, row := range results.Rows {
+			var cells []string
+			for i, cell := range row {
+				switch {
+				case cell.IsNull():
+					cells = append(cells, "NULL")
+				case results.Columns[i].Type == IntType, results.Columns[i].Type == BigIntType:
+					cells = append(cells, fmt.Sprintf("%d", cell.AsInt64()))
+				default:
+					cells = append(cells, cell.AsText())
+				}
+			}
+			rows = append(rows, cells)
+		}
+		assert.Equal(t, test.rows, rows, test.query)
+	}
+}
+
+func TestEquiJoinKeys(t *testing.T) {
+	mb := NewMemoryBackend()
+	_, err := execute(t, mb, "CREATE TABLE a (id INT, name TEXT); CREATE TABLE b (id INT, a_id INT, label TEXT);")
+	assert.Nil(t, err)
+
+	tests := []struct {
+		on   string
+		keys []string
+	}{
+		{on: "a.id = b.a_id", keys: []string{`"a.id" = "b.a_id"`}},
+		{on: "b.a_id = a.id", keys: []string{`"a.id" = "b.a_id"`}},
+		{on: "a_id = a.id + 1", keys: []string{`("a.id" + 1) = "a_id"`}},
+		{on: "a.id = b.a_id and name = label and b.id > 1", keys: []string{`"a.id" = "b.a_id"`, `"name" = "label"`}},
+		// Hash joins are not used for these
+		{on: "a.id = b.a_id or name = label", keys: nil},
+		{on: "a.id = a.id", keys: nil},
+		{on: "a.id = 1", keys: nil},
+		{on: "a.id < b.a_id", keys: nil},
+		{on: "name = b.a_id", keys: nil},
+		{on: "id = b.id", keys: nil},
+	}
+
+	for _, test := range tests {
+		on, err := parseExpressionSource(test.on)
+		assert.Nil(t, err, test.on)
+
+		leftKeys, rightKeys := equiJoinKeys(mb.tables["a"], mb.tables["b"], on)
+		var keys []string
+		for i := range leftKeys {
+			keys = append(keys, leftKeys[i].generateCode()+" = "+rightKeys[i].generateCode())
+		}
+		assert.Equal(t, test.keys, keys, test.on)
+	}
+}
+
+func TestMemoryBackend_subqueries(t *testing.T) {
+	mb := NewMemoryBackend()
+	_, err := execute(t, mb, "CREATE TABLE users (id INT PRIMARY KEY, name TEXT); INSERT INTO users VALUES (1, 'alice'); INSERT INTO users VALUES (2, 'bob'); INSERT INTO users VALUES (3, 'carol');")
+	assert.Nil(t, err)
+	_, err = execute(t, mb, "CREATE TABLE orders (id INT PRIMARY KEY, user_id INT, total INT); INSERT INTO orders VALUES (10, 1, 5); INSERT INTO orders VALUES (11, 1, 7); INSERT INTO orders VALUES (12, 2, 3); INSERT INTO orders VALUES (13, 4, 9); INSERT INTO orders VALUES (14, NULL, 1);")
+	assert.Nil(t, err)
+	_, err = execute(t, mb, "CREATE TABLE notes (user_id INT);")
+	assert.Nil(t, err)
+
+	tests := []struct {
+		query string
+		rows  [][]string
+		err   error
+	}{
+		{
+			query: "SELECT name, (SELECT max(total) FROM orders) FROM users WHERE id = 1;",
+			rows:  [][]string{{"alice", "9"}},
+		},
+		{
+			query: "SELECT id FROM orders WHERE total = (SELECT max(total) FROM orders);",
+			rows:  [][]string{{"13"}},
+		},
+		// Correlated subqueries read the row of the enclosing query
+		{
+			query: "SELECT name, (SELECT count(*) FROM orders WHERE user_id = users.id) AS n FROM users;",
+			rows:  [][]string{{"alice", "2"}, {"bob", "1"}, {"carol", "0"}},
+		},
+		{
+			query: "SELECT u.name FROM users u WHERE (SELECT sum(o.total) FROM orders o WHERE o.user_id = u.id) > 4;",
+			rows:  [][]string{{"alice"}},
+		},
+		{
+			query: "SELECT name, (SELECT total FROM orders WHERE user_id = users.id AND total > 6) FROM users;",
+			rows:  [][]string{{"alice", "7"}, {"bob", "NULL"}, {"carol", "NULL"}},
+		},
+		{
+			query: "SELECT user_id, (SELECT name FROM users WHERE id = user_id) FROM orders GROUP BY user_id ORDER BY user_id;",
+			rows:  [][]string{{"1", "alice"}, {"2", "bob"}, {"4", "NULL"}, {"NULL", "NULL"}},
+		},
+		{query: "SELECT (SELECT id FROM orders) FROM users;", err: SubqueryTooManyRows},
+		{query: "SELECT (SELECT id, total FROM orders) FROM users;", err: SubqueryTooManyColumns},
+		{query: "SELECT id FROM users WHERE id IN (SELECT * FROM orders);", err: SubqueryTooManyColumns},
+		{query: "SELECT (SELECT missing FROM orders) FROM users;", err: ColumnDoesNotExist},
+		{
+			query: "SELECT name FROM users WHERE EXISTS (SELECT 1 FROM orders WHERE orders.user_id = users.id);",
+			rows:  [][]string{{"alice"}, {"bob"}},
+		},
+		{
+			query: "SELECT name FROM users u WHERE NOT EXISTS (SELECT * FROM orders o WHERE o.user_id = u.id);",
+			rows:  [][]string{{"carol"}},
+		},
+		{
+			query: "SELECT name FROM users u WHERE EXISTS (SELECT 1 FROM orders o WHERE u.id = o.user_id AND o.total > 5);",
+			rows:  [][]string{{"alice"}},
+		},
+		{
+			query: "SELECT name FROM users WHERE id IN (SELECT user_id FROM orders);",
+			rows:  [][]string{{"alice"}, {"bob"}},
+		},
+		{
+			query: "SELECT name FROM users u WHERE 7 IN (SELECT total FROM orders o WHERE o.user_id = u.id);",
+			rows:  [][]string{{"alice"}},
+		},
+		// Integers are found among bigints
+		{
+			query: "SELECT name FROM users WHERE id IN (SELECT count(*) FROM orders GROUP BY user_id);",
+			rows:  [][]string{{"alice"}, {"bob"}},
+		},
+		{
+			query: "SELECT user_id FROM orders GROUP BY user_id HAVING count(*) IN (SELECT id + 1 FROM users WHERE id = 1);",
+			rows:  [][]string{{"1"}},
+		},
+		// NOT IN is NULL rather than true when the subquery returns a NULL
+		{
+			query: "SELECT name FROM users WHERE id NOT IN (SELECT user_id FROM orders);",
+			rows:  [][]string{},
+		},
+		{
+			query: "SELECT name FROM users WHERE id NOT IN (SELECT user_id FROM orders WHERE user_id IS NOT NULL);",
+			rows:  [][]string{{"carol"}},
+		},
+		{
+			query: "SELECT id FROM orders WHERE user_id NOT IN (SELECT id FROM users);",
+			rows:  [][]string{{"13"}},
+		},
+		// Subqueries that are not only correlated by equalities run for
+		// every row
+		{
+			query: "SELECT name FROM users u WHERE EXISTS (SELECT 1 FROM orders o WHERE o.total < u.id);",
+			rows:  [][]string{{"bob"}, {"carol"}},
+		},
+		{
+			query: "SELECT name FROM users u WHERE EXISTS (SELECT 1 FROM orders o WHERE o.user_id = u.id OR o.total = 9);",
+			rows:  [][]string{{"alice"}, {"bob"}, {"carol"}},
+		},
+		{
+			query: "SELECT name FROM users u WHERE EXISTS (SELECT 1 FROM orders o WHERE o.user_id = u.id AND EXISTS (SELECT 1 FROM orders p WHERE p.user_id = u.id AND p.total > o.total));",
+			rows:  [][]string{{"alice"}},
+		},
+		{
+			query: "SELECT name FROM users u WHERE EXISTS (SELECT 1 FROM orders o WHERE o.user_id = u.id AND NOT EXISTS (SELECT 1 FROM notes n WHERE n.user_id = u.id));",
+			rows:  [][]string{{"alice"}, {"bob"}},
+		},
+		{
+			query: "SELECT name FROM users u WHERE id IN (SELECT user_id FROM orders o WHERE o.total > u.id * 4);",
+			rows:  [][]string{{"alice"}},
+		},
+		// Subqueries of FROM are read like tables
+		{
+			query: "SELECT s.user_id, s.n FROM (SELECT user_id, count(*) AS n FROM orders GROUP BY user_id) AS s WHERE s.n > 1;",
+			rows:  [][]string{{"1", "2"}},
+		},
+		{
+			query: "SELECT name, s.total FROM users JOIN (SELECT user_id, sum(total) AS total FROM orders GROUP BY user_id) s ON users.id = s.user_id ORDER BY name;",
+			rows:  [][]string{{"alice", "12"}, {"bob", "3"}},
+		},
+		{
+			query: "SELECT * FROM (SELECT id, total FROM orders WHERE total > 5) big ORDER BY id DESC;",
+			rows:  [][]string{{"13", "9"}, {"11", "7"}},
+		},
+		{
+			query: "SELECT name FROM users u WHERE EXISTS (SELECT 1 FROM (SELECT user_id FROM orders WHERE user_id = u.id) o);",
+			rows:  [][]string{{"alice"}, {"bob"}},
+		},
+		{query: "SELECT total FROM (SELECT id FROM orders) o;", err: ColumnDoesNotExist},
+		{query: "SELECT id FROM (SELECT id FROM orders) o FOR UPDATE;", err: LockingNotAllowed},
+	}
+
+	for _, test := range tests {
+		results, err := execute(t, mb, test.query)
+		assert.Equal(t, test.err, err, test.query)
+		if err != nil {
+			continue
+		}
+
+		rows := [][]string{}
+		for _, row := range results.Rows {
+			var cells []string
+			for i, cell := range row {
+				switch {
+				case cell.IsNull():
+					cells = append(cells, "NULL")
+				case results.Columns[i].Type == IntType, results.Columns[i].Type == BigIntType:
+					cells = append(cells, fmt.Sprintf("%d", cell.AsInt64()))
+				default:
+					cells = append(cells, cell.AsText())
+				}
+			}
+			rows = append(rows, cells)
+		}
+		assert.Equal(t, test.rows, rows, test.query)
+	}
+
+	// Subqueries of statements that change rows
+	_, err = execute(t, mb, "UPDATE orders SET total = (SELECT count(*) FROM orders o WHERE o.user_id = orders.user_id) WHERE user_id IN (SELECT id FROM users WHERE name = 'alice');")
+	assert.Nil(t, err)
+	results, err := execute(t, mb, "SELECT sum(total) FROM orders WHERE user_id = 1;")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(4), results.Rows[0][0].AsInt64())
+
+	_, err = execute(t, mb, "DELETE FROM users WHERE NOT EXISTS (SELECT 1 FROM orders WHERE orders.user_id = users.id); INSERT INTO users VALUES ((SELECT max(id) FROM users) + 1, 'dave');")
+	assert.Nil(t, err)
+	results, err = execute(t, mb, "SELECT id, name FROM users WHERE id > 2;")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(results.Rows))
+	assert.Equal(t, int32(3), results.Rows[0][0].AsInt())
+	assert.Equal(t, "dave", results.Rows[0][1].AsText())
+
+	_, err = execute(t, mb, "CREATE TABLE d (a INT DEFAULT (SELECT 1 FROM users));")
+	assert.Equal(t, SubqueryNotAllowed, err)
+
+	// A table read through an alias hides the rows deleted by the
+	// transaction as well
+	results, err = execute(t, mb, "BEGIN; DELETE FROM orders WHERE id = 10; SELECT count(*) FROM orders o WHERE EXISTS (SELECT 1 FROM orders p WHERE p.id = o.id);")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(4), results.Rows[0][0].AsInt64())
+}
+
+func TestTable_planSemiJoin(t *testing.T) {
+	mb := NewMemoryBackend()
+	_, err := execute(t, mb, "CREATE TABLE users (id INT, name TEXT); CREATE TABLE orders (id INT, user_id INT, total INT);")
+	assert.Nil(t, err)
+
+	tx, err := mb.Begin()
+	assert.Nil(t, err)
+	defer mb.Rollback(tx)
+
+	users := mb.tables["users"].view(mb.newScope(tx))
+	users.name = "u"
+
+	tests := []struct {
+		source  string
+		planned bool
+		keys    []string
+	}{
+		{source: "exists (SELECT 1 FROM orders o WHERE o.user_id = u.id)", planned: true, keys: []string{`"u.id"`}},
+		{source: "exists (SELECT * FROM orders WHERE id = 1 and u.id + 1 = user_id)", planned: true, keys: []string{`("u.id" + 1)`}},
+		{source: "u.id in (SELECT user_id FROM orders)", planned: true},
+		{source: "u.id in (SELECT total FROM orders o WHERE o.user_id = u.id and name = 'x')", planned: false},
+		{source: "u.id in (SELECT total FROM orders o WHERE o.user_id = u.id and o.total > 1)", planned: true, keys: []string{`"u.id"`}},
+		// These run for every row
+		{source: "exists (SELECT 1 FROM orders o WHERE o.total < u.id)"},
+		{source: "exists (SELECT 1 FROM orders o WHERE o.user_id = u.id or o.total = 1)"},
+		{source: "exists (SELECT count(*) FROM orders o WHERE o.user_id = u.id)"},
+		{source: "exists (SELECT 1 FROM orders o WHERE o.user_id = u.id LIMIT 1)"},
+		{source: "exists (SELECT 1 FROM orders o WHERE o.user_id = u.name)"},
+		{source: "u.id in (SELECT u.id FROM orders)"},
+		{source: "u.name in (SELECT user_id FROM orders)"},
+		{source: "(SELECT 1 FROM orders)"},
+	}
+
+	for _, test := range tests {
+		exp, err := parseExpressionSource(test.source)
+		assert.Nil(t, err, test.source)
+
+		sj, err := users.planSemiJoin(exp.subquery)
+		assert.Nil(t, err, test.source)
+		assert.Equal(t, test.planned, sj != nil, test.source)
+		if sj == nil {
+			continue
+		}
+
+		var keys []string
+		for _, key := range sj.outerKeys {
+			keys = append(keys, key.generateCode())
+		}
+		assert.Equal(t, test.keys, keys, test.source)
+	}
+}
+
+func TestMemoryBackend_selectAsterisk(t *testing.T) {
+	mb := NewMemoryBackend()
+	_, err := execute(t, mb, "CREATE TABLE users (id INT PRIMARY KEY, name TEXT); INSERT INTO users VALUES (1, 'alice'); INSERT INTO users VALUES (2, 'bob');")
+	assert.Nil(t, err)
+	_, err = execute(t, mb, "CREATE TABLE orders (id INT PRIMARY KEY, user_id INT); INSERT INTO orders VALUES (10, 2);")
+	assert.Nil(t, err)
+
+	tests := []struct {
+		query   string
+		columns []ResultsColumn
+		rows    [][]string
+		err     error
+	}{
+		{
+			query:   "SELECT * FROM users;",
+			columns: []ResultsColumn{{IntType, "id"}, {TextType, "name"}},
+			rows:    [][]string{{"1", "alice"}, {"2", "bob"}},
+		},
+		{
+			query:   "SELECT *, -id, * FROM users WHERE id = 2;",
+			columns: []ResultsColumn{{IntType, "id"}, {TextType, "name"}, {IntType, "?column?"}, {IntType, "id"}, {TextType, "name"}},
+			rows:    [][]string{{"2", "bob", "-2", "2", "bob"}},
+		},
+		{
+			query:   "SELECT u.* FROM users u ORDER BY id DESC;",
+			columns: []ResultsColumn{{IntType, "id"}, {TextType, "name"}},
+			rows:    [][]string{{"2", "bob"}, {"1", "alice"}},
+		},
+		{
+			query:   "SELECT * FROM users JOIN orders ON users.id = orders.user_id;",
+			columns: []ResultsColumn{{IntType, "id"}, {TextType, "name"}, {IntType, "id"}, {IntType, "user_id"}},
+			rows:    [][]string{{"2", "bob", "10", "2"}},
+		},
+		{
+			query:   "SELECT orders.*, name FROM users JOIN orders ON users.id = orders.user_id;",
+			columns: []ResultsColumn{{IntType, "id"}, {IntType, "user_id"}, {TextType, "name"}},
+			rows:    [][]string{{"10", "2", "bob"}},
+		},
+		{query: "SELECT orders.* FROM users;", err: TableDoesNotExists},
+		{query: "SELECT *;", err: InvalidSelectItem},
+		{query: "SELECT * FROM users GROUP BY id;", err: ColumnNotGrouped},
+	}
+
+	for _, test := range tests {
+		results, err := execute(t, mb, test.query)
+		assert.Equal(t, test.err, err, test.query)
+		if err != nil {
+			continue
+		}
+
+		assert.Equal(t, test.columns, results.Columns, test.query)
+
+		rows := [][]string{}
+		for _, row := range results.Rows {
+			var cells []string
+			for i, cell := range row {
+				if test.columns[i].Type == IntType {
+					cells = append(cells, fmt.Sprintf("%d", cell.AsInt()))
+				} else {
+					cells = append(cells, cell.AsText())
+				}
+			}
+			rows = append(rows, cells)
+		}
+		assert.Equal(t, test.rows, rows, test.query)
+	}
+}
+
+func TestMemoryBackend_resultsColumns(t *testing.T) {
+	mb := NewMemoryBackend()
+	_, err := execute(t, mb, "CREATE TABLE users (id INT PRIMARY KEY, name TEXT); INSERT INTO users VALUES (1, 'alice'); CREATE TABLE empty (a INT, b TEXT);")
+	assert.Nil(t, err)
+
+	tests := []struct {
+		query   string
+		columns []ResultsColumn
+		err     error
+	}{
+		{query: "SELECT id AS key, name FROM users;", columns: []ResultsColumn{{IntType, "key"}, {TextType, "name"}}},
+		{query: "SELECT u.id, u.name AS n FROM users u;", columns: []ResultsColumn{{IntType, "id"}, {TextType, "n"}}},
+		{query: "SELECT (id + 1) * 2, id + 1 AS next FROM users;", columns: []ResultsColumn{{IntType, "?column?"}, {IntType, "next"}}},
+		{query: "SELECT 1 + 2, 'a' || 'b', id = 1 OR name = 'x', NOT true FROM users;", columns: []ResultsColumn{{IntType, "?column?"}, {TextType, "?column?"}, {BoolType, "?column?"}, {BoolType, "?column?"}}},
+		{query: "SELECT NULL, NULL + 1, name IS NULL FROM users;", columns: []ResultsColumn{{NullType, "?column?"}, {IntType, "?column?"}, {BoolType, "?column?"}}},
+		{query: "SELECT count(*) AS n, max(name) FROM users;", columns: []ResultsColumn{{BigIntType, "n"}, {TextType, "max"}}},
+		// Results without rows still have columns
+		{query: "SELECT id AS key, name FROM users WHERE id < 0;", columns: []ResultsColumn{{IntType, "key"}, {TextType, "name"}}},
+		{query: "SELECT *, a * 2 AS twice FROM empty;", columns: []ResultsColumn{{IntType, "a"}, {TextType, "b"}, {IntType, "twice"}}},
+		{query: "SELECT b, count(*) FROM empty GROUP BY b;", columns: []ResultsColumn{{TextType, "b"}, {BigIntType, "count"}}},
+		{query: "SELECT id FROM users LIMIT 0;", columns: []ResultsColumn{{IntType, "id"}}},
+		{query: "SELECT (SELECT max(id) FROM users), (SELECT a AS x FROM empty), EXISTS (SELECT * FROM empty), id IN (SELECT a FROM empty) FROM users;", columns: []ResultsColumn{{IntType, "max"}, {IntType, "x"}, {BoolType, "exists"}, {BoolType, "?column?"}}},
+		// Even when no row is evaluated
+		{query: "SELECT c FROM empty;", err: ColumnDoesNotExist},
+		{query: "SELECT a || b FROM empty;", err: InvalidOperands},
+	}
+
+	for _, test := range tests {
+		results, err := execute(t, mb, test.query)
+		assert.Equal(t, test.err, err, test.query)
+		if err == nil {
+			assert.Equal(t, test.columns, results.Columns, test.query)
+		}
+	}
+}
+
+func TestMemoryBackend_selectWithoutFrom(t *testing.T) {
+	mb := NewMemoryBackend()
+
+	tests := []struct {
+		query   string
+		columns []columnType
+		rows    [][]string
+	}{
+		{
+			query:   "SELECT 1 + 2 AS x, 'a' || 'b', NULL;",
+			columns: []columnType{IntType, TextType, NullType},
+			rows:    [][]string{{"3", "ab", "NULL"}},
+		},
+		{
+			query:   "SELECT extract(year FROM DATE '2024-05-17'), date_trunc('month', TIMESTAMP '2024-05-17 10:00'), now() > TIMESTAMP '2000-01-01';",
+			columns: []columnType{FloatType, TimestampType, BoolType},
+			rows:    [][]string{{"2024", "2024-05-01T00:00:00", "true"}},
+		},
+		{
+			query:   "SELECT count(*), max(2);",
+			columns: []columnType{BigIntType, IntType},
+			rows:    [][]string{{"1", "2"}},
+		},
+		{
+			query:   "SELECT (SELECT 1), EXISTS (SELECT 1 WHERE false);",
+			columns: []columnType{IntType, BoolType},
+			rows:    [][]string{{"1", "false"}},
+		},
+		{query: "SELECT 1 WHERE false;", columns: []columnType{IntType}, rows: [][]string{}},
+		{query: "SELECT 1 LIMIT 0;", columns: []columnType{IntType}, rows: [][]string{}},
+	}
+
+	for _, test := range tests {
+		results, err := execute(t, mb, test.query)
+		assert.Nil(t, err, test.query)
+		if err != nil {
+			continue
+		}
+
+		types := []columnType{}
+		for _, col := range results.Columns {
+			types = append(types, col.Type)
+		}
+		assert.Equal(t, test.columns, types, test.query)
+
+		rows := [][]string{}
+		for _, row := range results.Rows {
+			var cells []string
+			for i, cell := range row {
+				if cell.IsNull() {
+					cells = append(cells, "NULL")
+				} else {
+					cells = append(cells, formatCell(cell.(memoryCell), types[i]))
+				}
+			}
+			rows = append(rows, cells)
+		}
+		assert.Equal(t, test.rows, rows, test.query)
+	}
+}
+
+func TestMemoryBackend_InsertRows(t *testing.T) {
+	mb := NewMemoryBackend()
+	_, err := execute(t, mb, "CREATE TABLE t (id INT PRIMARY KEY, name TEXT DEFAULT 'anon', age INT NOT NULL DEFAULT 18, note TEXT);")
+	assert.Nil(t, err)
+
+	_, err = execute(t, mb, "INSERT INTO t VALUES (1, 'a', 20, 'x'), (2, 'b', 30, NULL);")
+	assert.Nil(t, err)
+
+	_, err = execute(t, mb, "INSERT INTO t (id) VALUES (3);")
+	assert.Nil(t, err)
+
+	_, err = execute(t, mb, "INSERT INTO t (age, id) VALUES (40, 4), (41, 5);")
+	assert.Nil(t, err)
+
+	tests := []struct {
+		query string
+		err   error
+	}{
+		{query: "INSERT INTO t (id, id) VALUES (6, 6);", err: DuplicateColumn},
+		{query: "INSERT INTO t (id, missing) VALUES (6, 6);", err: ColumnDoesNotExist},
+		{query: "INSERT INTO t VALUES (6);", err: MissingValues},
+		{query: "INSERT INTO t (id) VALUES (6, 'x');", err: TooManyValues},
+		{query: "INSERT INTO t (id) VALUES (6), (7, 'x');", err: TooManyValues},
+		// Rows before the failing one are taken out again
+		{query: "INSERT INTO t (id) VALUES (10), (11), (1);", err: ViolatesUniqueConstraint},
+		{query: "INSERT INTO t (id) VALUES (12), (12);", err: ViolatesUniqueConstraint},
+		{query: "INSERT INTO t (id, age) VALUES (13, 1), (14, NULL);", err: ViolatesNonNullConstraint},
+	}
+
+	for _, test := range tests {
+		_, err := execute(t, mb, test.query)
+		assert.Equal(t, test.err, err, test.query)
+	}
+
+	results, err := execute(t, mb, "SELECT id, name, age, note FROM t;")
+	assert.Nil(t, err)
+
+	rows := [][]string{}
+	for _, row := range results.Rows {
+		var cells []string
+		for i, cell := range row {
+			switch {
+			case cell.IsNull():
+				cells = append(cells, "NULL")
+			case results.Columns[i].Type == IntType:
+				cells = append(cells, fmt.Sprintf("%d", cell.AsInt()))
+			default:
+				cells = append(cells, cell.AsText())
+			}
+		}
+		rows = append(rows, cells)
+	}
+	assert.Equal(t, [][]string{
+		{"1", "a", "20", "x"},
+		{"2", "b", "30", "NULL"},
+		{"3", "anon", "18", "NULL"},
+		{"4", "anon", "40", "NULL"},
+		{"5", "anon", "41", "NULL"},
+	}, rows)
+
+	// The index no longer has the rows of failed statements
+	assert.Equal(t, 5, mb.tables["t"].indexes[0].tree.Len())
+	_, err = execute(t, mb, "INSERT INTO t (id) VALUES (10), (11), (12), (13);")
+	assert.Nil(t, err)
+}
+
+func TestMemoryBackend_InsertSelect(t *testing.T) {
+	mb := NewMemoryBackend()
+	_, err := execute(t, mb, "CREATE TABLE t (id INT PRIMARY KEY, name TEXT); INSERT INTO t VALUES (1, 'a'), (2, 'b'), (3, 'c');")
+	assert.Nil(t, err)
+	_, err = execute(t, mb, "CREATE TABLE copy (id INT PRIMARY KEY, name TEXT DEFAULT 'none');")
+	assert.Nil(t, err)
+
+	_, err = execute(t, mb, "INSERT INTO copy SELECT id, name FROM t WHERE id < 3;")
+	assert.Nil(t, err)
+
+	_, err = execute(t, mb, "INSERT INTO copy (id) SELECT id + 10 FROM t ORDER BY id DESC LIMIT 2;")
+	assert.Nil(t, err)
+
+	_, err = execute(t, mb, "INSERT INTO copy SELECT id FROM t;")
+	assert.Equal(t, MissingValues, err)
+
+	_, err = execute(t, mb, "INSERT INTO copy SELECT id, name FROM t WHERE id > 100;")
+	assert.Nil(t, err)
+
+	// Rows are selected before any of them is inserted
+	_, err = execute(t, mb, "INSERT INTO copy SELECT id + 100, name FROM copy;")
+	assert.Nil(t, err)
+
+	// The duplicate 1 fails the whole statement
+	_, err = execute(t, mb, "INSERT INTO copy SELECT id, name FROM t;")
+	assert.Equal(t, ViolatesUniqueConstraint, err)
+
+	results, err := execute(t, mb, "SELECT id, name FROM copy ORDER BY id;")
+	assert.Nil(t, err)
+
+	rows := []string{}
+	for _, row := range results.Rows {
+		rows = append(rows, fmt.Sprintf("%d %s", row[0].AsInt(), row[1].AsText()))
+	}
+	assert.Equal(t, []string{"1 a", "2 b", "12 none", "13 none", "101 a", "102 b", "112 none", "113 none"}, rows)
+}
+
+func TestMemoryBackend_InsertTypes(t *testing.T) {
+	mb := NewMemoryBackend()
+	_, err := execute(t, mb, "CREATE TABLE t (id INT PRIMARY KEY, name TEXT);")
+	assert.Nil(t, err)
+
+	_, err = execute(t, mb, "INSERT INTO t VALUES (1 + 2, 'a' || 'b'), (-(4), 5), (' 6 ', 1 < 2), (7, NULL);")
+	assert.Nil(t, err)
+
+	tests := []struct {
+		query string
+		err   error
+	}{
+		{query: "INSERT INTO t VALUES ('abc', 'x');", err: &ColumnTypeError{Column: "id", Expected: IntType, Actual: TextType}},
+		{query: "INSERT INTO t (id) VALUES (true);", err: &ColumnTypeError{Column: "id", Expected: IntType, Actual: BoolType}},
+		{query: "INSERT INTO t (id) VALUES ('99999999999');", err: IntegerOutOfRange},
+		{query: "INSERT INTO t (id) VALUES (id);", err: ColumnDoesNotExist},
+		// The error comes before any row is inserted
+		{query: "INSERT INTO t (id) VALUES (8), ('x');", err: &ColumnTypeError{Column: "id", Expected: IntType, Actual: TextType}},
+		{query: "INSERT INTO t (id) SELECT name FROM t;", err: &ColumnTypeError{Column: "id", Expected: IntType, Actual: TextType}},
+	}
+
+	for _, test := range tests {
+		_, err := execute(t, mb, test.query)
+		assert.Equal(t, test.err, err, test.query)
+	}
+
+	_, err = execute(t, mb, "INSERT INTO t (id, name) VALUES ('x', 'y');")
+	assert.EqualError(t, err, `Column "id" is of type int but expression is of type text`)
+
+	results, err := execute(t, mb, "SELECT id, name FROM t ORDER BY id;")
+	assert.Nil(t, err)
+
+	rows := []string{}
+	for _, row := range results.Rows {
+		rows = append(rows, fmt.Sprintf("%d %s", row[0].AsInt(), row[1].AsText()))
+	}
+	assert.Equal(t, []string{"-4 5", "3 ab", "6 true", "7 "}, rows)
+}
+
+func TestMemoryBackend_numericTypes(t *testing.T) {
+	mb := NewMemoryBackend()
+	_, err := execute(t, mb, "CREATE TABLE t (id BIGINT PRIMARY KEY, n INT, x DOUBLE, y FLOAT, ok BOOLEAN, s VARCHAR(5));")
+	assert.Nil(t, err)
+
+	_, err = execute(t, mb, "INSERT INTO t VALUES (5000000000, 1, 1.5, 2, true, 'abcde'), (2, -3, -0.25, 1e3, 'false', ''), ('7', 4, '2.5', 1, 'T', 12345);")
+	assert.Nil(t, err)
+
+	tests := []struct {
+		query   string
+		columns []columnType
+		rows    [][]string
+		err     error
+	}{
+		{
+			query:   "SELECT id, x, ok FROM t ORDER BY id;",
+			columns: []columnType{BigIntType, FloatType, BoolType},
+			rows:    [][]string{{"2", "-0.25", "false"}, {"7", "2.5", "true"}, {"5000000000", "1.5", "true"}},
+		},
+		{
+			query:   "SELECT n + id, n * 2, n + x, n / 2, x / 2, 7 % 3, 2147483647 + id FROM t WHERE id = 2;",
+			columns: []columnType{BigIntType, IntType, FloatType, IntType, FloatType, IntType, BigIntType},
+			rows:    [][]string{{"-1", "-6", "-3.25", "-1", "-0.125", "1", "2147483649"}},
+		},
+		{
+			query:   "SELECT -id, -x, -NULL + 1.5 FROM t WHERE id > 3 ORDER BY id;",
+			columns: []columnType{BigIntType, FloatType, FloatType},
+			rows:    [][]string{{"-7", "-2.5", "NULL"}, {"-5000000000", "-1.5", "NULL"}},
+		},
+		// Numbers of different types are compared by value, with and
+		// without the index of id
+		{
+			query:   "SELECT id FROM t WHERE id = 2 OR id = 7.0 OR x = 1.5 ORDER BY id;",
+			columns: []columnType{BigIntType},
+			rows:    [][]string{{"2"}, {"7"}, {"5000000000"}},
+		},
+		{
+			query:   "SELECT id FROM t WHERE id >= 7 AND id < 5000000000.5 AND n > 0.5 ORDER BY id;",
+			columns: []columnType{BigIntType},
+			rows:    [][]string{{"7"}, {"5000000000"}},
+		},
+		{
+			query:   "SELECT id FROM t WHERE id = 2;",
+			columns: []columnType{BigIntType},
+			rows:    [][]string{{"2"}},
+		},
+		{
+			query:   "SELECT id FROM t WHERE ok AND y > x ORDER BY id;",
+			columns: []columnType{BigIntType},
+			rows:    [][]string{{"5000000000"}},
+		},
+		{
+			query:   "SELECT sum(id), sum(x), avg(n), min(x), max(id) FROM t;",
+			columns: []columnType{BigIntType, FloatType, FloatType, FloatType, BigIntType},
+			rows:    [][]string{{"5000000009", "3.75", "0.6666666666666666", "-0.25", "5000000000"}},
+		},
+		{query: "SELECT 9223372036854775807 + id FROM t;", err: IntegerOutOfRange},
+		{query: "SELECT -9223372036854775807 - id FROM t;", err: IntegerOutOfRange},
+		{query: "SELECT id * 5000000000 FROM t;", err: IntegerOutOfRange},
+		{query: "SELECT 1e308 * 10 FROM t;", err: FloatOutOfRange},
+		{query: "SELECT 1e400;", err: FloatOutOfRange},
+		{query: "SELECT x / 0 FROM t;", err: DivisionByZero},
+		{query: "SELECT x % 2 FROM t;", err: InvalidOperands},
+		{query: "SELECT ok + 1 FROM t;", err: InvalidOperands},
+		{query: "SELECT s < 1 FROM t;", err: InvalidOperands},
+		{query: "SELECT -ok FROM t;", err: InvalidOperands},
+	}
+
+	for _, test := range tests {
+		results, err := execute(t, mb, test.query)
+		assert.Equal(t, test.err, err, test.query)
+		if err != nil {
+			continue
+		}
+
+		types := []columnType{}
+		for _, col := range results.Columns {
+			types = append(types, col.Type)
+		}
+		assert.Equal(t, test.columns, types, test.query)
+
+		rows := [][]string{}
+		for _, row := range results.Rows {
+			var cells []string
+			for i, cell := range row {
+				if cell.IsNull() {
+					cells = append(cells, "NULL")
+				} else {
+					cells = append(cells, formatCell(cell.(memoryCell), types[i]))
+				}
+			}
+			rows = append(rows, cells)
+		}
+		assert.Equal(t, test.rows, rows, test.query)
+	}
+
+	insertTests := []struct {
+		query string
+		err   error
+	}{
+		{query: "INSERT INTO t (id, n) VALUES (10, 5000000000);", err: IntegerOutOfRange},
+		{query: "INSERT INTO t (id, n) VALUES (10, 1.5);", err: &ColumnTypeError{Column: "n", Expected: IntType, Actual: FloatType}},
+		{query: "INSERT INTO t (id, ok) VALUES (10, 1);", err: &ColumnTypeError{Column: "ok", Expected: BoolType, Actual: IntType}},
+		{query: "INSERT INTO t (id, ok) VALUES (10, 'maybe');", err: &ColumnTypeError{Column: "ok", Expected: BoolType, Actual: TextType}},
+		{query: "INSERT INTO t (id, s) VALUES (10, 'abcdef');", err: ValueTooLong},
+		{query: "INSERT INTO t (id, s) VALUES (10, 123456);", err: ValueTooLong},
+		{query: "UPDATE t SET s = s || 'x';", err: ValueTooLong},
+		{query: "UPDATE t SET n = id;", err: IntegerOutOfRange},
+		{query: "UPDATE t SET n = x;", err: &ColumnTypeError{Column: "n", Expected: IntType, Actual: FloatType}},
+		{query: "SELECT 2147483647 + 1;", err: IntegerOutOfRange},
+		{query: "UPDATE t SET ok = 'maybe';", err: &ColumnTypeError{Column: "ok", Expected: BoolType, Actual: TextType}},
+		{query: "UPDATE t SET ok = 'true';", err: nil},
+		{query: "UPDATE t SET ok = 'off';", err: nil},
+		{query: "CREATE TABLE u (a INT(3));", err: InvalidDatatype},
+		{query: "CREATE TABLE u (a TEXT(0));", err: InvalidDatatype},
+	}
+
+	for _, test := range insertTests {
+		_, err := execute(t, mb, test.query)
+		assert.Equal(t, test.err, err, test.query)
+	}
+
+	// Multibyte characters count once
+	_, err = execute(t, mb, "INSERT INTO t (id, s) VALUES (11, 'héllo');")
+	assert.Nil(t, err)
+
+	// Numbers are widened, and ints narrowed when they fit
+	_, err = execute(t, mb, "UPDATE t SET x = n, id = id + 100 WHERE n > 0; UPDATE t SET n = id - 90 WHERE id = 107;")
+	assert.Nil(t, err)
+
+	results, err := execute(t, mb, "SELECT id, n, x FROM t WHERE n > 0 ORDER BY id;")
+	assert.Nil(t, err)
+
+	rows := []string{}
+	for _, row := range results.Rows {
+		rows = append(rows, fmt.Sprintf("%d %d %g", row[0].AsInt64(), row[1].AsInt(), row[2].AsFloat()))
+	}
+	assert.Equal(t, []string{"107 17 4", "5000000100 1 1"}, rows)
+}
+
+func TestParseBool(t *testing.T) {
+	tests := []struct {
+		text  string
+		value bool
+		ok    bool
+	}{
+		{"true", true, true},
+		{"TRUE", true, true},
+		{" t ", true, true},
+		{"yes", true, true},
+		{"Y", true, true},
+		{"on", true, true},
+		{"1", true, true},
+		{"false", false, true},
+		{"fal", false, true},
+		{"no", false, true},
+		{"n", false, true},
+		{"OFF", false, true},
+		{"of", false, true},
+		{"0", false, true},
+		{"o", false, false},
+		{"", false, false},
+		{"truest", false, false},
+		{"2", false, false},
+		{"maybe", false, false},
+	}
+
+	for _, test := range tests {
+		value, ok := parseBool(test.text)
+		assert.Equal(t, test.ok, ok, test.text)
+		assert.Equal(t, test.value, value, test.text)
+	}
+}
+
+func TestMemoryCell_order(t *testing.T) {
+	// Encoded values sort as bytes in the same order as their values, which
+	// is what index trees rely on
+	floats := []float64{math.Inf(-1), -1e300, -2.5, -1, -1e-300, 0, 1e-300, 0.5, 1, 3, 1e300, math.Inf(1)}
+	for i := 1; i < len(floats); i++ {
+		assert.Equal(t, -1, bytes.Compare(floatCell(floats[i-1]), floatCell(floats[i])), floats[i])
+		assert.Equal(t, floats[i], floatCell(floats[i]).AsFloat())
+	}
+	assert.Equal(t, floatCell(0), floatCell(math.Copysign(0, -1)))
+
+	ints := []int64{math.MinInt64, -5000000000, -1, 0, 1, 5000000000, math.MaxInt64}
+	for i := 1; i < len(ints); i++ {
+		assert.Equal(t, -1, bytes.Compare(bigIntCell(ints[i-1]), bigIntCell(ints[i])), ints[i])
+		assert.Equal(t, ints[i], bigIntCell(ints[i]).AsInt64())
+	}
+	assert.Equal(t, int64(-7), intCell(-7).AsInt64())
+}
+
+func TestMemoryBackend_datetime(t *testing.T) {
+	mb := NewMemoryBackend()
+	_, err := execute(t, mb, "CREATE TABLE t (id INT PRIMARY KEY, d DATE, tm TIME, ts TIMESTAMP, iv INTERVAL);")
+	assert.Nil(t, err)
+
+	_, err = execute(t, mb, "CREATE INDEX ts_idx ON t (ts);")
+	assert.Nil(t, err)
+
+	_, err = execute(t, mb, `INSERT INTO t VALUES
+		(1, '2024-01-31', '10:30', '2024-01-31 23:59:59.5', '1 month'),
+		(2, DATE '1999-12-31', TIME '00:00:01', TIMESTAMP '2000-01-01T00:00', INTERVAL 'P1DT2H'),
+		(3, NULL, NULL, '2024-01-31', '-1 day 01:00:00 ago');`)
+	assert.Nil(t, err)
+
+	tests := []struct {
+		query   string
+		columns []columnType
+		rows    [][]string
+		err     error
+	}{
+		{
+			query:   "SELECT id, d, tm, ts, iv FROM t ORDER BY ts;",
+			columns: []columnType{IntType, DateType, TimeType, TimestampType, IntervalType},
+			rows: [][]string{
+				{"2", "1999-12-31", "00:00:01", "2000-01-01T00:00:00", "P1DT2H"},
+				{"3", "NULL", "NULL", "2024-01-31T00:00:00", "P1DT-1H"},
+				{"1", "2024-01-31", "10:30:00", "2024-01-31T23:59:59.5", "P1M"},
+			},
+		},
+		// String literals compared to dates are read as dates, with and
+		// without the index of ts
+		{
+			query:   "SELECT id FROM t WHERE ts = '2024-01-31' OR d < '2000-01-01' ORDER BY id;",
+			columns: []columnType{IntType},
+			rows:    [][]string{{"2"}, {"3"}},
+		},
+		{
+			query:   "SELECT id FROM t WHERE ts > d AND ts >= DATE '2024-01-31' ORDER BY id;",
+			columns: []columnType{IntType},
+			rows:    [][]string{{"1"}},
+		},
+		{
+			query:   "SELECT id FROM t WHERE iv > INTERVAL '29 days' AND iv = '30 days' ORDER BY id;",
+			columns: []columnType{IntType},
+			rows:    [][]string{{"1"}},
+		},
+		// Months added to the end of a month end on the last day of the
+		// resulting month
+		{
+			query:   "SELECT d + iv, d + 1, d - DATE '1999-01-01', ts + iv, ts - iv, d + tm FROM t WHERE id = 1;",
+			columns: []columnType{TimestampType, DateType, IntType, TimestampType, TimestampType, TimestampType},
+			rows:    [][]string{{"2024-02-29T00:00:00", "2024-02-01", "9161", "2024-02-29T23:59:59.5", "2023-12-31T23:59:59.5", "2024-01-31T10:30:00"}},
+		},
+		{
+			query:   "SELECT ts - TIMESTAMP '2000-01-01', tm - TIME '12:00', tm + INTERVAL '14 hours', iv * 1.5, iv / 2, -iv, iv + iv FROM t WHERE id = 1;",
+			columns: []columnType{IntervalType, IntervalType, TimeType, IntervalType, IntervalType, IntervalType, IntervalType},
+			rows:    [][]string{{"P8796DT23H59M59.5S", "PT-1H-30M", "00:30:00", "P1M15D", "P15D", "P-1M", "P2M"}},
+		},
+		{
+			query:   "SELECT INTERVAL '1 year 2 mons 3 days 04:05:06.5', INTERVAL 'PT90M', d - 1, d + NULL FROM t WHERE id = 3;",
+			columns: []columnType{IntervalType, IntervalType, DateType, DateType},
+			rows:    [][]string{{"P1Y2M3DT4H5M6.5S", "PT1H30M", "NULL", "NULL"}},
+		},
+		{
+			query:   "SELECT extract(year FROM ts), extract(doy FROM d), extract(second FROM ts), extract(hour FROM tm), extract(month FROM iv), extract(epoch FROM iv) FROM t WHERE id = 1;",
+			columns: []columnType{FloatType, FloatType, FloatType, FloatType, FloatType, FloatType},
+			rows:    [][]string{{"2024", "31", "59.5", "10", "1", "2.592e+06"}},
+		},
+		{
+			query:   "SELECT date_trunc('month', ts), date_trunc('hour', ts), date_trunc('week', d), date_trunc('day', iv), date_trunc('century', ts) FROM t WHERE id = 1;",
+			columns: []columnType{TimestampType, TimestampType, TimestampType, IntervalType, TimestampType},
+			rows:    [][]string{{"2024-01-01T00:00:00", "2024-01-31T23:00:00", "2024-01-29T00:00:00", "P1M", "2001-01-01T00:00:00"}},
+		},
+		{
+			query:   "SELECT now() > TIMESTAMP '2000-01-01', extract(year FROM NULL) FROM t WHERE id = 1;",
+			columns: []columnType{BoolType, FloatType},
+			rows:    [][]string{{"true", "NULL"}},
+		},
+		// Dates are subtracted from and to timestamps as their midnight
+		{
+			query:   "SELECT ts - d, d - ts, ts - DATE '2024-02-01' FROM t WHERE id = 1;",
+			columns: []columnType{IntervalType, IntervalType, IntervalType},
+			rows:    [][]string{{"PT23H59M59.5S", "PT-23H-59M-59.5S", "PT-0.5S"}},
+		},
+		// Intervals are summed and averaged field by field, like in
+		// PostgreSQL
+		{
+			query:   "SELECT sum(iv), avg(iv), min(iv), max(iv) FROM t;",
+			columns: []columnType{IntervalType, IntervalType, IntervalType, IntervalType},
+			rows:    [][]string{{"P1M2DT1H", "P10DT16H20M", "P1DT-1H", "P1M"}},
+		},
+		{
+			query:   "SELECT sum(iv), avg(iv) FROM t WHERE id > 10;",
+			columns: []columnType{IntervalType, IntervalType},
+			rows:    [][]string{{"NULL", "NULL"}},
+		},
+		{query: "SELECT DATE '2024-02-30';", err: InvalidDatetime},
+		{query: "SELECT INTERVAL '1 fortnight';", err: InvalidDatetime},
+		{query: "SELECT id FROM t WHERE d = 'yesterday';", err: InvalidDatetime},
+		{query: "SELECT TIMESTAMP '9999-12-31 23:00' + INTERVAL '1 day';", err: DatetimeOutOfRange},
+		{query: "SELECT extract(year FROM tm) FROM t;", err: InvalidDatetimeField},
+		{query: "SELECT extract(fortnight FROM ts) FROM t;", err: InvalidDatetimeField},
+		{query: "SELECT date_trunc('week', iv) FROM t;", err: InvalidDatetimeField},
+		{query: "SELECT ts + ts FROM t;", err: InvalidOperands},
+		{query: "SELECT tm < d FROM t;", err: InvalidOperands},
+		{query: "SELECT iv % 2 FROM t;", err: InvalidOperands},
+		{query: "SELECT d + ts FROM t;", err: InvalidOperands},
+		{query: "SELECT sum(d) FROM t;", err: InvalidOperands},
+		{query: "SELECT avg(tm) FROM t;", err: InvalidOperands},
+		{query: "SELECT today();", err: FunctionDoesNotExist},
+		{query: "SELECT extract(ts) FROM t;", err: FunctionDoesNotExist},
+	}
+
+	for _, test := range tests {
+		results, err := execute(t, mb, test.query)
+		assert.Equal(t, test.err, err, test.query)
+		if err != nil || test.rows == nil {
+			continue
+		}
+
+		types := []columnType{}
+		for _, col := range results.Columns {
+			types = append(types, col.Type)
+		}
+		assert.Equal(t, test.columns, types, test.query)
+
+		rows := [][]string{}
+		for _, row := range results.Rows {
+			var cells []string
+			for i, cell := range row {
+				if cell.IsNull() {
+					cells = append(cells, "NULL")
+				} else {
+					cells = append(cells, formatCell(cell.(memoryCell), types[i]))
+				}
+			}
+			rows = append(rows, cells)
+		}
+		assert.Equal(t, test.rows, rows, test.query)
+	}
+
+	insertTests := []struct {
+		query string
+		err   error
+	}{
+		{query: "INSERT INTO t (id, d) VALUES (10, 'not a date');", err: &ColumnTypeError{Column: "d", Expected: DateType, Actual: TextType}},
+		{query: "INSERT INTO t (id, d) VALUES (10, 20240101);", err: &ColumnTypeError{Column: "d", Expected: DateType, Actual: IntType}},
+		{query: "INSERT INTO t (id, ts) VALUES (10, TIME '10:00');", err: &ColumnTypeError{Column: "ts", Expected: TimestampType, Actual: TimeType}},
+		{query: "INSERT INTO t (id, ts) VALUES (10, DATE '2024-05-01');", err: nil},
+		{query: "UPDATE t SET ts = '2024-02-01 10:00' WHERE id = 1;", err: nil},
+		{query: "UPDATE t SET ts = d WHERE id = 2;", err: nil},
+		{query: "UPDATE t SET d = 'soon' WHERE id = 2;", err: &ColumnTypeError{Column: "d", Expected: DateType, Actual: TextType}},
+		{query: "UPDATE t SET d = tm WHERE id = 2;", err: &ColumnTypeError{Column: "d", Expected: DateType, Actual: TimeType}},
+	}
+
+	for _, test := range insertTests {
+		_, err := execute(t, mb, test.query)
+		assert.Equal(t, test.err, err, test.query)
+	}
+
+	results, err := execute(t, mb, "SELECT ts FROM t WHERE id < 3 ORDER BY id;")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(results.Rows))
+	assert.Equal(t, "2024-02-01T10:00:00", results.Rows[0][0].AsTime().Format(TimestampFormat))
+	assert.Equal(t, "1999-12-31T00:00:00", results.Rows[1][0].AsTime().Format(TimestampFormat))
+}
+
+func TestMemoryBackend_transactions(t *testing.T) {
+	mb := NewMemoryBackend()
+	_, err := execute(t, mb, "CREATE TABLE t (id INT PRIMARY KEY, name TEXT); CREATE UNIQUE INDEX t_name ON t (name); INSERT INTO t VALUES (1, 'a'), (2, 'b');")
+	assert.Nil(t, err)
+
+	rows := func(tx *Transaction) []string {
+		results, err := executeIn(t, mb, &tx, "SELECT id, name FROM t ORDER BY id;")
+		assert.Nil(t, err)
+
+		rows := []string{}
+		for _, row := range results.Rows {
+			rows = append(rows, fmt.Sprintf("%d %s", row[0].AsInt(), row[1].AsText()))
+		}
+		return rows
+	}
+
+	// Every kind of change is undone, indexes included
+	var tx *Transaction
+	_, err = executeIn(t, mb, &tx, `BEGIN;
+		INSERT INTO t VALUES (3, 'c');
+		UPDATE t SET name = 'x' WHERE id = 1;
+		DELETE FROM t WHERE id = 2;
+		UPDATE t SET id = 2 WHERE id = 3;
+		CREATE TABLE u (a INT); INSERT INTO u VALUES (1);
+		DROP INDEX t_name;
+		CREATE INDEX t_id ON t (id);
+		TRUNCATE t;
+		INSERT INTO t VALUES (4, 'a');`)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"4 a"}, rows(tx))
+
+	_, err = executeIn(t, mb, &tx, "ROLLBACK;")
+	assert.Nil(t, err)
+	assert.Nil(t, tx)
+	assert.Equal(t, []string{"1 a", "2 b"}, rows(nil))
+
+	table := mb.tables["t"]
+	assert.Nil(t, mb.tables["u"])
+	assert.Equal(t, []string{"t_pkey", "t_name"}, []string{table.indexes[0].name, table.indexes[1].name})
+	assert.Equal(t, 2, table.indexes[0].tree.Len())
+	assert.Equal(t, 2, table.indexes[1].tree.Len())
+
+	_, err = execute(t, mb, "INSERT INTO t VALUES (3, 'a');")
+	assert.Equal(t, ViolatesUniqueConstraint, err)
+
+	_, err = execute(t, mb, "INSERT INTO t VALUES (3, 'c');")
+	assert.Nil(t, err)
+
+	// Committed changes stay
+	_, err = executeIn(t, mb, &tx, "BEGIN; UPDATE t SET name = name || '!' WHERE id > 1; DELETE FROM t WHERE id = 1; COMMIT;")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"2 b!", "3 c!"}, rows(nil))
+
+	// A failing statement aborts the transaction, which can then only be
+	// rolled back
+	_, err = executeIn(t, mb, &tx, "BEGIN; INSERT INTO t VALUES (4, 'd');")
+	assert.Nil(t, err)
+
+	_, err = executeIn(t, mb, &tx, "INSERT INTO t VALUES (5, 'e'), (2, 'f');")
+	assert.Equal(t, ViolatesUniqueConstraint, err)
+
+	_, err = executeIn(t, mb, &tx, "SELECT id FROM t;")
+	assert.Equal(t, TransactionAborted, err)
+
+	_, err = executeIn(t, mb, &tx, "COMMIT;")
+	assert.Equal(t, TransactionAborted, err)
+	assert.Equal(t, []string{"2 b!", "3 c!"}, rows(nil))
+
+	// Ended transactions cannot be used again
+	tx, err = mb.Begin()
+	assert.Nil(t, err)
+	assert.Nil(t, mb.Commit(tx))
+	assert.Equal(t, TransactionClosed, mb.Commit(tx))
+	assert.Equal(t, TransactionClosed, mb.Rollback(tx))
+	_, err = mb.Select(tx, &SelectStatement{})
+	assert.Equal(t, TransactionClosed, err)
+}
+
+func TestMemoryBackend_savepoints(t *testing.T) {
+	mb := NewMemoryBackend()
+	_, err := execute(t, mb, "CREATE TABLE t (id INT PRIMARY KEY, name TEXT); CREATE UNIQUE INDEX t_name ON t (name); INSERT INTO t VALUES (1, 'a');")
+	assert.Nil(t, err)
+
+	var tx *Transaction
+	ids := func() []int32 {
+		results, err := executeIn(t, mb, &tx, "SELECT id FROM t ORDER BY id;")
+		assert.Nil(t, err)
+
+		ids := []int32{}
+		for _, row := range results.Rows {
+			ids = append(ids, row[0].AsInt())
+		}
+		return ids
+	}
+
+	_, err = executeIn(t, mb, &tx, "SAVEPOINT a;")
+	assert.Equal(t, NotInTransaction, err)
+
+	// Rows inserted, updated and deleted since a savepoint go back to how
+	// they were, and so do the values taken in unique indexes
+	_, err = executeIn(t, mb, &tx, "BEGIN; INSERT INTO t VALUES (2, 'b'); SAVEPOINT a; INSERT INTO t VALUES (3, 'c'); UPDATE t SET name = 'x' WHERE id = 2; DELETE FROM t WHERE id = 1;")
+	assert.Nil(t, err)
+	assert.Equal(t, []int32{2, 3}, ids())
+
+	_, err = executeIn(t, mb, &tx, "SAVEPOINT b; INSERT INTO t VALUES (4, 'd'); ROLLBACK TO SAVEPOINT a;")
+	assert.Nil(t, err)
+	assert.Equal(t, []int32{1, 2}, ids())
+	assert.Equal(t, 2, mb.tables["t"].indexes[0].tree.Len())
+	assert.Equal(t, 2, mb.tables["t"].indexes[1].tree.Len())
+
+	_, err = executeIn(t, mb, &tx, "INSERT INTO t VALUES (3, 'x');")
+	assert.Nil(t, err)
+	_, err = executeIn(t, mb, &tx, "ROLLBACK TO a; INSERT INTO t VALUES (1, 'z');")
+	assert.Equal(t, ViolatesUniqueConstraint, err)
+
+	// Rolling back to a savepoint recovers from a failed statement, but
+	// later savepoints are gone
+	_, err = executeIn(t, mb, &tx, "SELECT 1;")
+	assert.Equal(t, TransactionAborted, err)
+	_, err = executeIn(t, mb, &tx, "RELEASE a;")
+	assert.Equal(t, TransactionAborted, err)
+	_, err = executeIn(t, mb, &tx, "ROLLBACK TO a; RELEASE b;")
+	assert.Equal(t, SavepointDoesNotExist, err)
+	_, err = executeIn(t, mb, &tx, "ROLLBACK TO a; INSERT INTO t VALUES (3, 'c'); SAVEPOINT a; INSERT INTO t VALUES (4, 'd');")
+	assert.Nil(t, err)
+
+	// The latest savepoint of a name is released first, and the changes
+	// made since are kept
+	_, err = executeIn(t, mb, &tx, "RELEASE SAVEPOINT a; ROLLBACK TO SAVEPOINT a; INSERT INTO t VALUES (5, 'e'); RELEASE a; COMMIT;")
+	assert.Nil(t, err)
+	assert.Equal(t, []int32{1, 2, 5}, ids())
+	assert.Equal(t, 3, mb.tables["t"].indexes[1].tree.Len())
+}
+
+func TestMemoryBackend_snapshots(t *testing.T) {
+	mb := NewMemoryBackend()
+	_, err := execute(t, mb, "CREATE TABLE t (id INT PRIMARY KEY, n INT); INSERT INTO t VALUES (1, 10), (2, 20);")
+	assert.Nil(t, err)
+
+	sum := func(tx *Transaction) int64 {
+		results, err := executeIn(t, mb, &tx, "SELECT sum(n) FROM t;")
+		assert.Nil(t, err)
+		return results.Rows[0][0].AsInt64()
+	}
+
+	// Readers do not wait for writers, and only see what was committed
+	// when they began
+	var a, b *Transaction
+	_, err = executeIn(t, mb, &a, "BEGIN; UPDATE t SET n = n + 1; INSERT INTO t VALUES (3, 30);")
+	assert.Nil(t, err)
+	_, err = executeIn(t, mb, &b, "BEGIN;")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(62), sum(a))
+	assert.Equal(t, int64(30), sum(b))
+	assert.Equal(t, int64(30), sum(nil))
+
+	_, err = executeIn(t, mb, &a, "COMMIT;")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(30), sum(b))
+	assert.Equal(t, int64(62), sum(nil))
+
+	// Rows still seen by a running transaction are kept until it ends
+	_, err = execute(t, mb, "DELETE FROM t WHERE id = 3;")
+	assert.Nil(t, err)
+	assert.Equal(t, 5, mb.tables["t"].indexes[0].tree.Len())
+
+	// A value that is deleted, but not committed yet, is still taken
+	_, err = executeIn(t, mb, &a, "BEGIN; DELETE FROM t WHERE id = 1;")
+	assert.Nil(t, err)
+	_, err = execute(t, mb, "INSERT INTO t VALUES (1, 0);")
+	assert.Equal(t, ViolatesUniqueConstraint, err)
+
+	// A row changed by a transaction that committed after another one
+	// began cannot be changed by it
+	_, err = executeIn(t, mb, &b, "UPDATE t SET n = 0 WHERE id = 1;")
+	assert.Equal(t, SerializationFailure, err)
+
+	// A row changed by a running transaction is locked until it ends
+	_, err = executeIn(t, mb, &b, "ROLLBACK; BEGIN;")
+	assert.Nil(t, err)
+	b.LockTimeout = 10 * time.Millisecond
+	_, err = executeIn(t, mb, &b, "UPDATE t SET n = 0 WHERE id = 1;")
+	assert.Equal(t, LockWaitTimeout, err)
+
+	_, err = executeIn(t, mb, &b, "ROLLBACK; BEGIN;")
+	assert.Nil(t, err)
+	done := make(chan error)
+	go func() {
+		_, err := executeIn(t, mb, &b, "UPDATE t SET n = 0 WHERE id = 1;")
+		done <- err
+	}()
+	_, err = executeIn(t, mb, &a, "COMMIT;")
+	assert.Nil(t, err)
+	assert.Equal(t, SerializationFailure, <-done)
+	_, err = executeIn(t, mb, &b, "ROLLBACK;")
+	assert.Nil(t, err)
+
+	results, err := execute(t, mb, "SELECT id, n FROM t;")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(results.Rows))
+	assert.Equal(t, int32(21), results.Rows[0][1].AsInt())
+	assert.Equal(t, 1, mb.tables["t"].indexes[0].tree.Len())
+	assert.Equal(t, 0, len(mb.active))
+}
+
+func TestMemoryBackend_purge(t *testing.T) {
+	mb := NewMemoryBackend()
+	_, err := execute(t, mb, "CREATE TABLE t (id INT PRIMARY KEY); INSERT INTO t VALUES (1), (2);")
+	assert.Nil(t, err)
+
+	// A deleted row is kept while a transaction that began before the
+	// deletion committed runs, but not for the ones that began after
+	var a, b *Transaction
+	_, err = executeIn(t, mb, &a, "BEGIN; SELECT id FROM t;")
+	assert.Nil(t, err)
+	_, err = execute(t, mb, "DELETE FROM t WHERE id = 1;")
+	assert.Nil(t, err)
+	_, err = executeIn(t, mb, &b, "BEGIN; SELECT id FROM t;")
+	assert.Nil(t, err)
+	assert.NotNil(t, mb.tables["t"].rows[0])
+
+	results, err := executeIn(t, mb, &a, "SELECT id FROM t; COMMIT;")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(results.Rows))
+	assert.Nil(t, mb.tables["t"].rows[0])
+	assert.Equal(t, 1, mb.tables["t"].indexes[0].tree.Len())
+
+	// Rows deleted while b runs wait for it
+	_, err = execute(t, mb, "DELETE FROM t WHERE id = 2;")
+	assert.Nil(t, err)
+	assert.NotNil(t, mb.tables["t"].rows[1])
+
+	results, err = executeIn(t, mb, &b, "SELECT id FROM t; COMMIT;")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(results.Rows))
+	assert.Nil(t, mb.tables["t"].rows[1])
+	assert.Equal(t, 0, len(mb.dead))
+}
+
+func TestMemoryBackend_locks(t *testing.T) {
+	mb := NewMemoryBackend()
+	_, err := execute(t, mb, "CREATE TABLE t (id INT PRIMARY KEY, n INT); INSERT INTO t VALUES (1, 10), (2, 20); CREATE TABLE u (id INT);")
+	assert.Nil(t, err)
+
+	var a, b *Transaction
+	_, err = executeIn(t, mb, &b, "BEGIN;")
+	assert.Nil(t, err)
+	b.LockTimeout = 10 * time.Millisecond
+
+	// Rows locked by FOR UPDATE cannot be changed or locked by others, but
+	// can still be read
+	results, err := executeIn(t, mb, &a, "BEGIN; SELECT n FROM t WHERE id = 1 FOR UPDATE;")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(results.Rows))
+	_, err = executeIn(t, mb, &b, "SELECT n FROM t AS x WHERE id = 1;")
+	assert.Nil(t, err)
+	_, err = executeIn(t, mb, &b, "UPDATE t SET n = 0 WHERE id = 2; SELECT n FROM t WHERE id = 1 FOR SHARE;")
+	assert.Equal(t, LockWaitTimeout, err)
+
+	// Rows locked by FOR SHARE can be locked by others the same way
+	_, err = executeIn(t, mb, &b, "ROLLBACK; BEGIN; SELECT n FROM t WHERE id = 2 FOR SHARE;")
+	assert.Nil(t, err)
+	b.LockTimeout = 10 * time.Millisecond
+	_, err = executeIn(t, mb, &a, "SELECT n FROM t WHERE id = 2 FOR SHARE;")
+	assert.Nil(t, err)
+	_, err = executeIn(t, mb, &b, "DELETE FROM t WHERE id = 2;")
+	assert.Equal(t, LockWaitTimeout, err)
+
+	// Tables read by a running transaction cannot be dropped or truncated
+	_, err = executeIn(t, mb, &b, "ROLLBACK;")
+	assert.Nil(t, err)
+	_, err = executeIn(t, mb, &a, "COMMIT; BEGIN; SELECT id FROM u;")
+	assert.Nil(t, err)
+	for _, source := range []string{"DROP TABLE u;", "TRUNCATE u;"} {
+		_, err = executeIn(t, mb, &b, "BEGIN;")
+		assert.Nil(t, err)
+		b.LockTimeout = 10 * time.Millisecond
+		_, err = executeIn(t, mb, &b, source)
+		assert.Equal(t, LockWaitTimeout, err)
+		_, err = executeIn(t, mb, &b, "ROLLBACK;")
+		assert.Nil(t, err)
+	}
+	_, err = executeIn(t, mb, &a, "COMMIT;")
+	assert.Nil(t, err)
+
+	_, err = execute(t, mb, "SELECT t.id FROM t JOIN u ON t.id = u.id FOR UPDATE;")
+	assert.Equal(t, LockingNotAllowed, err)
+	_, err = execute(t, mb, "SELECT count(*) FROM t FOR SHARE;")
+	assert.Equal(t, LockingNotAllowed, err)
+	assert.Equal(t, 0, len(mb.locks.holders))
+}
+
+func TestMemoryBackend_deadlocks(t *testing.T) {
+	mb := NewMemoryBackend()
+	_, err := execute(t, mb, "CREATE TABLE t (id INT PRIMARY KEY, n INT); INSERT INTO t VALUES (1, 10), (2, 20);")
+	assert.Nil(t, err)
+
+	waiting := func(tx *Transaction) bool {
+		mb.locks.mu.Lock()
+		defer mb.locks.mu.Unlock()
+
+		_, ok := mb.locks.waiting[tx.id]
+		return ok
+	}
+
+	// Whichever transaction closes the cycle, the youngest one is aborted
+	for _, youngestWaitsFirst := range []bool{false, true} {
+		var a, b *Transaction
+		_, err = executeIn(t, mb, &a, "BEGIN; UPDATE t SET n = n + 1 WHERE id = 1;")
+		assert.Nil(t, err)
+		_, err = executeIn(t, mb, &b, "BEGIN; UPDATE t SET n = n + 1 WHERE id = 2;")
+		assert.Nil(t, err)
+
+		first, second := &a, &b
+		firstRow, secondRow := 2, 1
+		if youngestWaitsFirst {
+			first, second = &b, &a
+			firstRow, secondRow = 1, 2
+		}
+
+		// The aborted transaction has to end for the other one to go on
+		done := make(chan error)
+		go func() {
+			_, err := executeIn(t, mb, first, fmt.Sprintf("UPDATE t SET n = n + 1 WHERE id = %d;", firstRow))
+			if err != nil {
+				mb.Rollback(*first)
+			}
+			done <- err
+		}()
+		for !waiting(*first) {
+			time.Sleep(time.Millisecond)
+		}
+
+		_, err = executeIn(t, mb, second, fmt.Sprintf("UPDATE t SET n = n + 1 WHERE id = %d;", secondRow))
+		if youngestWaitsFirst {
+			assert.Nil(t, err)
+			assert.Equal(t, DeadlockDetected, <-done)
+		} else {
+			assert.Equal(t, DeadlockDetected, err)
+			_, err = executeIn(t, mb, &b, "COMMIT;")
+			assert.Equal(t, TransactionAborted, err)
+			assert.Nil(t, <-done)
+		}
+
+		_, err = executeIn(t, mb, &a, "COMMIT;")
+		assert.Nil(t, err)
+	}
+
+	results, err := execute(t, mb, "SELECT n FROM t ORDER BY id;")
+	assert.Nil(t, err)
+	assert.Equal(t, int32(12), results.Rows[0][0].AsInt())
+	assert.Equal(t, int32(22), results.Rows[1][0].AsInt())
+}
+
+func TestSettings(t *testing.T) {
+	tests := []struct {
+		source  string
+		timeout time.Duration
+		err     error
+	}{
+		{"SET lock_timeout = 500;", 500 * time.Millisecond, nil},
+		{"SET lock_timeout TO '2 seconds';", 2 * time.Second, nil},
+		{"SET lock_timeout = '1.5';", 1500 * time.Microsecond, nil},
+		{"SET lock_timeout = DEFAULT;", 0, nil},
+		{"SET lock_timeout = 'soon';", 0, InvalidSettingValue},
+		{"SET lock_timeout = '1 second ago';", 0, InvalidSettingValue},
+		{"SET statement_timeout = 1;", 0, UnknownSetting},
+	}
+
+	for _, test := range tests {
+		a, err := Parse(test.source)
+		assert.Nil(t, err, test.source)
+
+		settings := Settings{}
+		err = settings.Set(a.Statements[0].Set)
+		assert.Equal(t, test.err, err, test.source)
+		assert.Equal(t, test.timeout, settings.LockTimeout, test.source)
+	}
+}
+
+func TestMemoryBackend_concurrency(t *testing.T) {
+	mb := NewMemoryBackend()
+	_, err := execute(t, mb, "CREATE TABLE accounts (id INT PRIMARY KEY, balance INT); CREATE INDEX accounts_balance ON accounts (balance);")
+	assert.Nil(t, err)
+
+	const accounts = 10
+	for i := 0; i < accounts; i++ {
+		_, err = execute(t, mb, fmt.Sprintf("INSERT INTO accounts VALUES (%d, 100);", i))
+		assert.Nil(t, err)
+	}
+
+	// Transfers keep the total balance, which every snapshot must show
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				from, to := (w+i)%accounts, (w+2*i+1)%accounts
+				var tx *Transaction
+				_, err := executeIn(t, mb, &tx, fmt.Sprintf("BEGIN; UPDATE accounts SET balance = balance - 1 WHERE id = %d; UPDATE accounts SET balance = balance + 1 WHERE id = %d; COMMIT;", from, to))
+				if err != nil {
+					assert.Contains(t, []error{SerializationFailure, DeadlockDetected}, err)
+					if tx != nil {
+						mb.Rollback(tx)
+					}
+				}
+			}
+		}(w)
+	}
+
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				results, err := execute(t, mb, "SELECT sum(balance), count(*) FROM accounts WHERE balance >= 0;")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				assert.Equal(t, int64(100*accounts), results.Rows[0][0].AsInt64())
+				assert.Equal(t, int64(accounts), results.Rows[0][1].AsInt64())
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	results, err := execute(t, mb, "SELECT sum(balance) FROM accounts;")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(100*accounts), results.Rows[0][0].AsInt64())
+	assert.Equal(t, accounts, mb.tables["accounts"].indexes[0].tree.Len())
+}
+
+func TestMemoryBackend_longQuery(t *testing.T) {
+	mb := NewMemoryBackend()
+	_, err := execute(t, mb, "CREATE TABLE t (a INT);")
+	assert.Nil(t, err)
+
+	for i := 0; i < 500; i++ {
+		_, err = execute(t, mb, fmt.Sprintf("INSERT INTO t VALUES (%d);", i))
+		assert.Nil(t, err)
+	}
+
+	// The query stops halfway through the table until it is resumed
+	paused, resume := make(chan struct{}), make(chan struct{})
+	scalarFunctions["pause"] = func(args []memoryCell, types []columnType) (memoryCell, columnType, error) {
+		if len(args) == 1 && !args[0].IsNull() && args[0].AsInt() == 250 {
+			close(paused)
+			<-resume
+		}
+
+		return trueMemoryCell, BoolType, nil
+	}
+	defer delete(scalarFunctions, "pause")
+
+	queried := make(chan *Results)
+	go func() {
+		results, err := execute(t, mb, "SELECT count(*) FROM t WHERE pause(a);")
+		assert.Nil(t, err)
+		queried <- results
+	}()
+
+	<-paused
+	inserted := make(chan error)
+	go func() {
+		_, err := execute(t, mb, "INSERT INTO t VALUES (500);")
+		inserted <- err
+	}()
+
+	select {
+	case err := <-inserted:
+		assert.Nil(t, err)
+	case <-time.After(5 * time.Second):
+		close(resume)
+		t.Fatal("the INSERT waited for the query to finish")
+	}
+
+	// The row inserted meanwhile is not in the snapshot of the query
+	close(resume)
+	results := <-queried
+	assert.Equal(t, int64(500), results.Rows[0][0].AsInt64())
+}
@@ -0,0 +1,504 @@
+package src
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"sort"
+)
+
+// The rows of a table are kept on pages of their own, while the definitions
+// of the tables and their indexes are kept together on catalog pages.
+type dataPage struct {
+	// The table whose rows the page holds, nil for a catalog page
+	table *table
+
+	// Length of the record of every row on the page, by position
+	rows map[uint]int
+	used int
+}
+
+// The pages holding the rows of a table, and the page every row is on.
+type tablePages struct {
+	pages []uint32
+	rows  map[uint]uint32
+}
+
+// A pager keeps track of what every page of a data file holds, so that a
+// checkpoint only writes the pages whose contents changed since the last
+// one, in place.
+//
+// The pages are first written to a journal next to the data file, which is
+// synced before any page of the data file is overwritten. A checkpoint cut
+// short by a crash is completed from the journal on startup, while a journal
+// that is cut short itself is thrown away, leaving the data file as it was.
+type pager struct {
+	file    *os.File
+	journal *os.File
+
+	// Pages by number, nil for the header and for free pages
+	pages []*dataPage
+	free  []uint32
+
+	// Catalog pages in order, and the catalog records they hold
+	catalog        []uint32
+	catalogRecords [][]byte
+
+	tables map[*table]*tablePages
+
+	// Rows inserted or deleted by the transactions committed or rolled back
+	// since the last checkpoint, and the pages to write at the next one
+	changed map[*table]map[uint]bool
+	dirty   map[uint32]bool
+}
+
+// A journal is a list of pages, each preceded by its number (uint32), and
+// ends with the number of pages (uint32) and a checksum of the whole list
+// (uint32).
+const journalTrailerSize = 8
+
+func openPager(path string) (*pager, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	journal, err := os.OpenFile(path+".journal", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	p := &pager{
+		file:    f,
+		journal: journal,
+		pages:   []*dataPage{nil},
+		tables:  map[*table]*tablePages{},
+		changed: map[*table]map[uint]bool{},
+		dirty:   map[uint32]bool{},
+	}
+
+	err = p.recover()
+	if err != nil {
+		p.close()
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// recover completes the checkpoint left in the journal, if it was written
+// whole.
+func (p *pager) recover() error {
+	info, err := p.journal.Stat()
+	if err != nil {
+		return err
+	}
+
+	contents := make([]byte, info.Size())
+	_, err = p.journal.ReadAt(contents, 0)
+	if err != nil {
+		return err
+	}
+
+	if len(contents) < journalTrailerSize {
+		return p.clearJournal()
+	}
+
+	list := contents[:len(contents)-journalTrailerSize]
+	count := binary.BigEndian.Uint32(contents[len(list):])
+	checksum := binary.BigEndian.Uint32(contents[len(list)+4:])
+	if len(list) != int(count)*(4+pageSize) || crc32.ChecksumIEEE(list) != checksum {
+		return p.clearJournal()
+	}
+
+	for len(list) > 0 {
+		no := binary.BigEndian.Uint32(list)
+		_, err = p.file.WriteAt(list[4:4+pageSize], int64(no)*pageSize)
+		if err != nil {
+			return err
+		}
+
+		list = list[4+pageSize:]
+	}
+
+	err = p.file.Sync()
+	if err != nil {
+		return err
+	}
+
+	return p.clearJournal()
+}
+
+func (p *pager) clearJournal() error {
+	err := p.journal.Truncate(0)
+	if err != nil {
+		return err
+	}
+
+	return p.journal.Sync()
+}
+
+// read returns the contents of a page of the data file.
+func (p *pager) read(no uint32) ([]byte, error) {
+	page := newPage()
+	_, err := p.file.ReadAt(page, int64(no)*pageSize)
+	if err != nil {
+		return nil, CorruptedDataFile
+	}
+
+	return page, nil
+}
+
+// change records the rows inserted or deleted by a transaction that ended,
+// for the next checkpoint to write out. The rows of a transaction that rolled
+// back are gone, and are taken off their pages if they were written.
+func (p *pager) change(changes []change) {
+	for _, c := range changes {
+		if c.row.table == nil {
+			continue
+		}
+
+		rows, ok := p.changed[c.row.table]
+		if !ok {
+			rows = map[uint]bool{}
+			p.changed[c.row.table] = rows
+		}
+
+		rows[c.row.rowIndex] = true
+	}
+}
+
+// allocate returns a free page, or a new one at the end of the file, to hold
+// the rows of a table, or the catalog when t is nil.
+func (p *pager) allocate(t *table) uint32 {
+	var no uint32
+	if len(p.free) > 0 {
+		no = p.free[len(p.free)-1]
+		p.free = p.free[:len(p.free)-1]
+	} else {
+		no = uint32(len(p.pages))
+		p.pages = append(p.pages, nil)
+	}
+
+	p.pages[no] = &dataPage{table: t, rows: map[uint]int{}}
+	p.dirty[no] = true
+	return no
+}
+
+// release frees a page, which is written out empty.
+func (p *pager) release(no uint32) {
+	p.pages[no] = nil
+	p.free = append(p.free, no)
+	p.dirty[no] = true
+}
+
+// checkpoint lays out the changes made to the tables of mb since the last
+// checkpoint, and writes the pages they touched along with a header for lsn.
+// Once a checkpoint fails, what the data file holds is unknown, so the next
+// one writes every page again. The tables of mb are only latched while the
+// pages are laid out and while the rows of each page are encoded.
+func (p *pager) checkpoint(mb *MemoryBackend, lsn uint64) error {
+	mb.latch.RLock()
+	err := p.layout(mb)
+	mb.latch.RUnlock()
+
+	if err == nil {
+		err = p.write(lsn)
+	}
+
+	if err != nil {
+		mb.latch.RLock()
+		p.reset(mb)
+		mb.latch.RUnlock()
+	}
+
+	return err
+}
+
+// layout updates the pages to hold the catalog of mb and its changed rows.
+func (p *pager) layout(mb *MemoryBackend) error {
+	records, err := catalogRecords(mb)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(bytes.Join(records, nil), bytes.Join(p.catalogRecords, nil)) {
+		for _, no := range p.catalog {
+			p.release(no)
+		}
+
+		p.catalog = nil
+		for range packRecords(records) {
+			p.catalog = append(p.catalog, p.allocate(nil))
+		}
+		p.catalogRecords = records
+	}
+
+	stored := map[*table]bool{}
+	for _, t := range mb.tables {
+		stored[t] = true
+	}
+
+	// The pages of dropped tables are freed
+	for t, tp := range p.tables {
+		if stored[t] {
+			continue
+		}
+
+		for _, no := range tp.pages {
+			p.release(no)
+		}
+		delete(p.tables, t)
+	}
+
+	for t, rows := range p.changed {
+		if !stored[t] {
+			continue
+		}
+
+		err := p.layoutRows(mb, t, rows)
+		if err != nil {
+			return err
+		}
+	}
+
+	p.changed = map[*table]map[uint]bool{}
+	return nil
+}
+
+// layoutRows takes changed rows of a table off their pages, and puts the
+// ones that are live on a page of the table with room for them, the latest
+// first. Rows of running transactions are left out until they commit, as
+// they may still be rolled back.
+func (p *pager) layoutRows(mb *MemoryBackend, t *table, rows map[uint]bool) error {
+	tp, ok := p.tables[t]
+	if !ok {
+		tp = &tablePages{rows: map[uint]uint32{}}
+		p.tables[t] = tp
+	}
+
+	rowIndexes := []uint{}
+	for rowIndex := range rows {
+		rowIndexes = append(rowIndexes, rowIndex)
+	}
+	sort.Slice(rowIndexes, func(i, j int) bool {
+		return rowIndexes[i] < rowIndexes[j]
+	})
+
+	t.latch.RLock()
+	defer t.latch.RUnlock()
+
+	for _, rowIndex := range rowIndexes {
+		if no, ok := tp.rows[rowIndex]; ok {
+			page := p.pages[no]
+			page.used -= page.rows[rowIndex]
+			delete(page.rows, rowIndex)
+			delete(tp.rows, rowIndex)
+			p.dirty[no] = true
+		}
+
+		// Rows deleted by committed transactions may wait to be purged
+		// until running ones end
+		if t.rows[rowIndex] == nil || t.xmax[rowIndex] != 0 || mb.active[t.xmin[rowIndex]] {
+			continue
+		}
+
+		record, err := rowRecordOf(t, rowIndex)
+		if err != nil {
+			return err
+		}
+
+		no := uint32(0)
+		for i := len(tp.pages) - 1; i >= 0; i-- {
+			if pageHeaderSize+p.pages[tp.pages[i]].used+len(record) <= pageSize {
+				no = tp.pages[i]
+				break
+			}
+		}
+
+		if no == 0 {
+			no = p.allocate(t)
+			tp.pages = append(tp.pages, no)
+		}
+
+		page := p.pages[no]
+		page.rows[rowIndex] = len(record)
+		page.used += len(record)
+		tp.rows[rowIndex] = no
+		p.dirty[no] = true
+	}
+
+	pages := []uint32{}
+	for _, no := range tp.pages {
+		if len(p.pages[no].rows) == 0 {
+			p.release(no)
+			continue
+		}
+
+		pages = append(pages, no)
+	}
+	tp.pages = pages
+
+	return nil
+}
+
+// write writes the dirty pages to the journal, then in place.
+func (p *pager) write(lsn uint64) error {
+	p.dirty[0] = true
+
+	nos := []uint32{}
+	for no := range p.dirty {
+		nos = append(nos, no)
+	}
+	sort.Slice(nos, func(i, j int) bool {
+		return nos[i] < nos[j]
+	})
+
+	catalogPages := packRecords(p.catalogRecords)
+	catalogPage := map[uint32][]byte{}
+	for i, no := range p.catalog {
+		catalogPage[no] = catalogPages[i]
+	}
+
+	list := new(bytes.Buffer)
+	contents := [][]byte{}
+	for _, no := range nos {
+		var page []byte
+		switch {
+		case no == 0:
+			page = newPage()
+			copy(page, diskMagic)
+			binary.BigEndian.PutUint32(page[4:], diskVersion)
+			binary.BigEndian.PutUint32(page[8:], uint32(len(p.pages)))
+			binary.BigEndian.PutUint64(page[12:], lsn)
+		case p.pages[no] == nil:
+			page = newPage()
+		case p.pages[no].table == nil:
+			page = catalogPage[no]
+		default:
+			var err error
+			page, err = p.encodeRows(p.pages[no])
+			if err != nil {
+				return err
+			}
+		}
+
+		binary.Write(list, binary.BigEndian, no)
+		list.Write(page)
+		contents = append(contents, page)
+	}
+
+	trailer := make([]byte, journalTrailerSize)
+	binary.BigEndian.PutUint32(trailer, uint32(len(nos)))
+	binary.BigEndian.PutUint32(trailer[4:], crc32.ChecksumIEEE(list.Bytes()))
+	list.Write(trailer)
+
+	_, err := p.journal.WriteAt(list.Bytes(), 0)
+	if err != nil {
+		return err
+	}
+
+	err = p.journal.Sync()
+	if err != nil {
+		return err
+	}
+
+	for i, no := range nos {
+		_, err = p.file.WriteAt(contents[i], int64(no)*pageSize)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = p.file.Sync()
+	if err != nil {
+		return err
+	}
+
+	p.dirty = map[uint32]bool{}
+	return p.clearJournal()
+}
+
+// encodeRows returns the contents of a page holding rows, in position order.
+func (p *pager) encodeRows(page *dataPage) ([]byte, error) {
+	rowIndexes := []uint{}
+	for rowIndex := range page.rows {
+		rowIndexes = append(rowIndexes, rowIndex)
+	}
+	sort.Slice(rowIndexes, func(i, j int) bool {
+		return rowIndexes[i] < rowIndexes[j]
+	})
+
+	page.table.latch.RLock()
+	defer page.table.latch.RUnlock()
+
+	contents := newPage()
+	used := 0
+	for _, rowIndex := range rowIndexes {
+		record, err := rowRecordOf(page.table, rowIndex)
+		if err != nil {
+			return nil, err
+		}
+
+		copy(contents[pageHeaderSize+used:], record)
+		used += len(record)
+	}
+	binary.BigEndian.PutUint16(contents, uint16(used))
+
+	return contents, nil
+}
+
+// reset forgets the layout of the data file, so that the next checkpoint
+// lays out every table again and writes every page.
+func (p *pager) reset(mb *MemoryBackend) {
+	p.free = nil
+	for no := range p.pages[1:] {
+		p.release(uint32(no + 1))
+	}
+
+	p.catalog = nil
+	p.catalogRecords = nil
+	p.tables = map[*table]*tablePages{}
+	p.changed = map[*table]map[uint]bool{}
+	for _, t := range mb.tables {
+		t.latch.RLock()
+		count := len(t.rows)
+		t.latch.RUnlock()
+
+		for rowIndex := 0; rowIndex < count; rowIndex++ {
+			p.change([]change{{row: rowRef{table: t, rowIndex: uint(rowIndex)}}})
+		}
+	}
+}
+
+func (p *pager) close() error {
+	p.journal.Close()
+	return p.file.Close()
+}
+
+// packRecords lays out records on as many pages as they take, in order.
+func packRecords(records [][]byte) [][]byte {
+	pages := [][]byte{}
+	page := newPage()
+	used := 0
+	for _, record := range records {
+		if pageHeaderSize+used+len(record) > pageSize {
+			binary.BigEndian.PutUint16(page, uint16(used))
+			pages = append(pages, page)
+			page = newPage()
+			used = 0
+		}
+
+		copy(page[pageHeaderSize+used:], record)
+		used += len(record)
+	}
+
+	if used > 0 {
+		binary.BigEndian.PutUint16(page, uint16(used))
+		pages = append(pages, page)
+	}
+
+	return pages
+}
@@ -44,6 +44,22 @@ func Parse(source string) (*ast, error) {
 	return &a, nil
 }
 
+// parseExpressionSource parses a standalone expression, such as one
+// produced by expression.generateCode.
+func parseExpressionSource(source string) (*expression, error) {
+	tokens, err := lex(source)
+	if err != nil {
+		return nil, err
+	}
+
+	exp, cursor, ok := parseExpression(tokens, 0, []token{}, 0)
+	if !ok || cursor != uint(len(tokens)) {
+		return nil, errors.New("Failed to parse, expected expression")
+	}
+
+	return exp, nil
+}
+
 func parseStatements(tokens []*token, initialCursor uint, delimiter token) (*Statement, uint, bool) {
 	cursor := initialCursor
 
@@ -72,9 +88,176 @@ func parseStatements(tokens []*token, initialCursor uint, delimiter token) (*Sta
 		}, newCursor, true
 	}
 
+	ci, newCursor, ok := parseCreateIndexStatement(tokens, cursor, semiColonToken)
+	if ok {
+		return &Statement{
+			Kind:        CreateIndexAstKind,
+			CreateIndex: ci,
+		}, newCursor, true
+	}
+
+	upd, newCursor, ok := parseUpdateStatement(tokens, cursor, semiColonToken)
+	if ok {
+		return &Statement{
+			Kind:   UpdateAstKind,
+			Update: upd,
+		}, newCursor, true
+	}
+
+	del, newCursor, ok := parseDeleteStatement(tokens, cursor, semiColonToken)
+	if ok {
+		return &Statement{
+			Kind:   DeleteAstKind,
+			Delete: del,
+		}, newCursor, true
+	}
+
+	dt, newCursor, ok := parseDropTableStatement(tokens, cursor, semiColonToken)
+	if ok {
+		return &Statement{
+			Kind:      DropTableAstKind,
+			DropTable: dt,
+		}, newCursor, true
+	}
+
+	di, newCursor, ok := parseDropIndexStatement(tokens, cursor, semiColonToken)
+	if ok {
+		return &Statement{
+			Kind:      DropIndexAstKind,
+			DropIndex: di,
+		}, newCursor, true
+	}
+
+	trunc, newCursor, ok := parseTruncateStatement(tokens, cursor, semiColonToken)
+	if ok {
+		return &Statement{
+			Kind:     TruncateAstKind,
+			Truncate: trunc,
+		}, newCursor, true
+	}
+
+	set, newCursor, ok := parseSetStatement(tokens, cursor)
+	if ok {
+		return &Statement{
+			Kind: SetAstKind,
+			Set:  set,
+		}, newCursor, true
+	}
+
+	// ROLLBACK TO SAVEPOINT goes before ROLLBACK
+	kind, sp, newCursor, ok := parseSavepointStatement(tokens, cursor)
+	if ok {
+		return &Statement{
+			Kind:      kind,
+			Savepoint: sp,
+		}, newCursor, true
+	}
+
+	kind, newCursor, ok = parseTransactionStatement(tokens, cursor)
+	if ok {
+		return &Statement{
+			Kind: kind,
+		}, newCursor, true
+	}
+
 	return nil, initialCursor, false
 }
 
+// parseTransactionStatement parses BEGIN, COMMIT and ROLLBACK, which only
+// consist of their keyword.
+func parseTransactionStatement(tokens []*token, initialCursor uint) (astKind, uint, bool) {
+	kinds := []struct {
+		keyword keyword
+		kind    astKind
+	}{
+		{Begin, BeginAstKind},
+		{Commit, CommitAstKind},
+		{Rollback, RollbackAstKind},
+	}
+
+	for _, k := range kinds {
+		_, cursor, ok := parseToken(tokens, initialCursor, k.keyword.toToken())
+		if ok {
+			return k.kind, cursor, true
+		}
+	}
+
+	return 0, initialCursor, false
+}
+
+// parseSavepointStatement parses SAVEPOINT name, ROLLBACK TO [SAVEPOINT] name
+// and RELEASE [SAVEPOINT] name.
+func parseSavepointStatement(tokens []*token, initialCursor uint) (astKind, *SavepointStatement, uint, bool) {
+	cursor := initialCursor
+	var kind astKind
+
+	if _, newCursor, ok := parseToken(tokens, cursor, Savepoint.toToken()); ok {
+		kind, cursor = SavepointAstKind, newCursor
+	} else if _, newCursor, ok := parseToken(tokens, cursor, Release.toToken()); ok {
+		kind, cursor = ReleaseSavepointAstKind, newCursor
+	} else if _, newCursor, ok := parseToken(tokens, cursor, Rollback.toToken()); ok {
+		_, newCursor, ok = parseToken(tokens, newCursor, To.toToken())
+		if !ok {
+			return 0, nil, initialCursor, false
+		}
+
+		kind, cursor = RollbackToSavepointAstKind, newCursor
+	} else {
+		return 0, nil, initialCursor, false
+	}
+
+	// The SAVEPOINT keyword is optional after ROLLBACK TO and RELEASE
+	if kind != SavepointAstKind {
+		_, cursor, _ = parseToken(tokens, cursor, Savepoint.toToken())
+	}
+
+	name, cursor, ok := parseTokenKind(tokens, cursor, IdentifierKind)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected savepoint name")
+		return 0, nil, initialCursor, false
+	}
+
+	return kind, &SavepointStatement{name: *name}, cursor, true
+}
+
+// parseSetStatement parses SET name = value, or SET name TO value.
+func parseSetStatement(tokens []*token, initialCursor uint) (*SetStatement, uint, bool) {
+	_, cursor, ok := parseToken(tokens, initialCursor, Set.toToken())
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	name, cursor, ok := parseTokenKind(tokens, cursor, IdentifierKind)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected setting name")
+		return nil, initialCursor, false
+	}
+
+	_, newCursor, ok := parseToken(tokens, cursor, Equal.toToken())
+	if !ok {
+		_, newCursor, ok = parseToken(tokens, cursor, To.toToken())
+		if !ok {
+			helpMessage(tokens, cursor, "Expected = or TO")
+			return nil, initialCursor, false
+		}
+	}
+	cursor = newCursor
+
+	value, newCursor, ok := parseTokenKind(tokens, cursor, NumericKind)
+	if !ok {
+		value, newCursor, ok = parseTokenKind(tokens, cursor, StringKind)
+	}
+	if !ok {
+		value, newCursor, ok = parseToken(tokens, cursor, Default.toToken())
+	}
+	if !ok {
+		helpMessage(tokens, cursor, "Expected setting value")
+		return nil, initialCursor, false
+	}
+
+	return &SetStatement{name: *name, value: *value}, newCursor, true
+}
+
 func parseInsertStatement(tokens []*token, initialCursor uint, delimiter token) (*InsertStatement, uint, bool) {
 	cursor := initialCursor
 	var ok bool
@@ -100,45 +283,217 @@ func parseInsertStatement(tokens []*token, initialCursor uint, delimiter token)
 	}
 	cursor = newCursor
 
+	inst := InsertStatement{table: *table}
+
+	// Column list
+	_, newCursor, ok = parseToken(tokens, cursor, LeftParen.toToken())
+	if ok {
+		cursor = newCursor
+
+		var columns []*token
+		for {
+			column, newCursor, ok := parseTokenKind(tokens, cursor, IdentifierKind)
+			if !ok {
+				helpMessage(tokens, cursor, "Expected column name")
+				return nil, initialCursor, false
+			}
+			cursor = newCursor
+			columns = append(columns, column)
+
+			_, cursor, ok = parseToken(tokens, cursor, Comma.toToken())
+			if !ok {
+				break
+			}
+		}
+
+		_, cursor, ok = parseToken(tokens, cursor, RightParen.toToken())
+		if !ok {
+			helpMessage(tokens, cursor, "Expected right paren")
+			return nil, initialCursor, false
+		}
+
+		inst.columns = &columns
+	}
+
+	// SELECT
+	slct, newCursor, ok := parseSelectStatement(tokens, cursor, delimiter)
+	if ok {
+		inst.selection = slct
+		return &inst, newCursor, true
+	}
+
 	// VALUES
 	_, cursor, ok = parseToken(tokens, cursor, Values.toToken())
 	if !ok {
-		helpMessage(tokens, cursor, "Expected VALUES")
+		helpMessage(tokens, cursor, "Expected VALUES or SELECT")
 		return nil, initialCursor, false
 	}
 
-	// Left paren
-	_, cursor, ok = parseToken(tokens, cursor, LeftParen.toToken())
+	var rows [][]*expression
+	for {
+		// Left paren
+		_, cursor, ok = parseToken(tokens, cursor, LeftParen.toToken())
+		if !ok {
+			helpMessage(tokens, cursor, "Expected left paren")
+			return nil, initialCursor, false
+		}
+
+		// Expression list
+		values, newCursor, ok := parseExpressions(tokens, cursor, []token{RightParen.toToken()})
+		if !ok {
+			return nil, initialCursor, false
+		}
+		cursor = newCursor
+
+		// Right paren
+		_, cursor, ok = parseToken(tokens, cursor, RightParen.toToken())
+		if !ok {
+			helpMessage(tokens, cursor, "Expected right paren")
+			return nil, initialCursor, false
+		}
+
+		rows = append(rows, *values)
+
+		_, cursor, ok = parseToken(tokens, cursor, Comma.toToken())
+		if !ok {
+			break
+		}
+	}
+	inst.values = &rows
+
+	return &inst, cursor, true
+}
+
+func parseUpdateStatement(tokens []*token, initialCursor uint, delimiter token) (*UpdateStatement, uint, bool) {
+	cursor := initialCursor
+	var ok bool
+
+	// Update
+	_, cursor, ok = parseToken(tokens, cursor, Update.toToken())
 	if !ok {
-		helpMessage(tokens, cursor, "Expected left paren")
 		return nil, initialCursor, false
 	}
 
-	// Expression list
-	values, newCursor, ok := parseExpressions(tokens, cursor, []token{RightParen.toToken()})
+	// Table name
+	table, newCursor, ok := parseTokenKind(tokens, cursor, IdentifierKind)
 	if !ok {
+		helpMessage(tokens, cursor, "Expected table name")
 		return nil, initialCursor, false
 	}
 	cursor = newCursor
 
-	// Right paren
-	_, cursor, ok = parseToken(tokens, cursor, RightParen.toToken())
+	// Set
+	_, cursor, ok = parseToken(tokens, cursor, Set.toToken())
 	if !ok {
-		helpMessage(tokens, cursor, "Expected right paren")
+		helpMessage(tokens, cursor, "Expected SET")
 		return nil, initialCursor, false
 	}
 
-	return &InsertStatement{
-		table:  *table,
-		values: values,
-	}, cursor, true
+	whereToken := Where.toToken()
+	set := []*setItem{}
+	for {
+		if len(set) > 0 {
+			_, cursor, ok = parseToken(tokens, cursor, Comma.toToken())
+			if !ok {
+				break
+			}
+		}
+
+		column, newCursor, ok := parseTokenKind(tokens, cursor, IdentifierKind)
+		if !ok {
+			helpMessage(tokens, cursor, "Expected column name")
+			return nil, initialCursor, false
+		}
+		cursor = newCursor
+
+		_, cursor, ok = parseToken(tokens, cursor, Equal.toToken())
+		if !ok {
+			helpMessage(tokens, cursor, "Expected =")
+			return nil, initialCursor, false
+		}
+
+		exp, newCursor, ok := parseExpression(tokens, cursor, []token{Comma.toToken(), whereToken, delimiter}, 0)
+		if !ok {
+			helpMessage(tokens, cursor, "Expected expression")
+			return nil, initialCursor, false
+		}
+		cursor = newCursor
+
+		set = append(set, &setItem{
+			column: *column,
+			exp:    *exp,
+		})
+	}
+
+	upd := UpdateStatement{
+		table: *table,
+		set:   &set,
+	}
+
+	// Where
+	_, cursor, ok = parseToken(tokens, cursor, whereToken)
+	if ok {
+		where, newCursor, ok := parseExpression(tokens, cursor, []token{delimiter}, 0)
+		if !ok {
+			helpMessage(tokens, cursor, "Expected WHERE conditionals")
+			return nil, initialCursor, false
+		}
+		upd.where = where
+		cursor = newCursor
+	}
+
+	return &upd, cursor, true
 }
 
-func parseCreateStatement(tokens []*token, initialCursor uint, delimiter token) (*CreateTableStatement, uint, bool) {
+func parseDeleteStatement(tokens []*token, initialCursor uint, delimiter token) (*DeleteStatement, uint, bool) {
 	cursor := initialCursor
 	var ok bool
 
-	_, cursor, ok = parseToken(tokens, cursor, Create.toToken())
+	// Delete
+	_, cursor, ok = parseToken(tokens, cursor, Delete.toToken())
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	// From
+	_, cursor, ok = parseToken(tokens, cursor, From.toToken())
+	if !ok {
+		helpMessage(tokens, cursor, "Expected FROM")
+		return nil, initialCursor, false
+	}
+
+	// Table name
+	table, newCursor, ok := parseTokenKind(tokens, cursor, IdentifierKind)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected table name")
+		return nil, initialCursor, false
+	}
+	cursor = newCursor
+
+	del := DeleteStatement{
+		table: *table,
+	}
+
+	// Where
+	_, cursor, ok = parseToken(tokens, cursor, Where.toToken())
+	if ok {
+		where, newCursor, ok := parseExpression(tokens, cursor, []token{delimiter}, 0)
+		if !ok {
+			helpMessage(tokens, cursor, "Expected WHERE conditionals")
+			return nil, initialCursor, false
+		}
+		del.where = where
+		cursor = newCursor
+	}
+
+	return &del, cursor, true
+}
+
+func parseDropTableStatement(tokens []*token, initialCursor uint, delimiter token) (*DropTableStatement, uint, bool) {
+	cursor := initialCursor
+	var ok bool
+
+	_, cursor, ok = parseToken(tokens, cursor, Drop.toToken())
 	if !ok {
 		return nil, initialCursor, false
 	}
@@ -148,6 +503,11 @@ func parseCreateStatement(tokens []*token, initialCursor uint, delimiter token)
 		return nil, initialCursor, false
 	}
 
+	ifExists, cursor, ok := parseIfExists(tokens, cursor)
+	if !ok {
+		return nil, initialCursor, false
+	}
+
 	name, newCursor, ok := parseTokenKind(tokens, cursor, IdentifierKind)
 	if !ok {
 		helpMessage(tokens, cursor, "Expected table name")
@@ -155,127 +515,645 @@ func parseCreateStatement(tokens []*token, initialCursor uint, delimiter token)
 	}
 	cursor = newCursor
 
-	_, cursor, ok = parseToken(tokens, cursor, LeftParen.toToken())
+	return &DropTableStatement{
+		name:     *name,
+		ifExists: ifExists,
+	}, cursor, true
+}
+
+func parseDropIndexStatement(tokens []*token, initialCursor uint, delimiter token) (*DropIndexStatement, uint, bool) {
+	cursor := initialCursor
+	var ok bool
+
+	_, cursor, ok = parseToken(tokens, cursor, Drop.toToken())
 	if !ok {
-		helpMessage(tokens, cursor, "Expected left parenthesis")
 		return nil, initialCursor, false
 	}
 
-	cols, newCursor, ok := parseColumnDefinitions(tokens, cursor, RightParen.toToken())
+	_, cursor, ok = parseToken(tokens, cursor, Index.toToken())
 	if !ok {
 		return nil, initialCursor, false
 	}
-	cursor = newCursor
 
-	_, cursor, ok = parseToken(tokens, cursor, RightParen.toToken())
+	ifExists, cursor, ok := parseIfExists(tokens, cursor)
 	if !ok {
-		helpMessage(tokens, cursor, "Expected right parenthesis")
 		return nil, initialCursor, false
 	}
 
-	return &CreateTableStatement{
-		name: *name,
-		cols: cols,
+	name, newCursor, ok := parseTokenKind(tokens, cursor, IdentifierKind)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected index name")
+		return nil, initialCursor, false
+	}
+	cursor = newCursor
+
+	return &DropIndexStatement{
+		name:     *name,
+		ifExists: ifExists,
 	}, cursor, true
 }
 
-func parseColumnDefinitions(tokens []*token, initialCursor uint, delimiter token) (*[]*columnDefinition, uint, bool) {
-	cursor := initialCursor
+// parseIfExists parses an optional IF EXISTS clause.
+func parseIfExists(tokens []*token, initialCursor uint) (bool, uint, bool) {
+	cursor := initialCursor
+	var ok bool
+
+	_, cursor, ok = parseToken(tokens, cursor, If.toToken())
+	if !ok {
+		return false, initialCursor, true
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, Exists.toToken())
+	if !ok {
+		helpMessage(tokens, cursor, "Expected EXISTS")
+		return false, initialCursor, false
+	}
+
+	return true, cursor, true
+}
+
+func parseTruncateStatement(tokens []*token, initialCursor uint, delimiter token) (*TruncateStatement, uint, bool) {
+	cursor := initialCursor
+	var ok bool
+
+	_, cursor, ok = parseToken(tokens, cursor, Truncate.toToken())
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	// TABLE is optional
+	_, cursor, _ = parseToken(tokens, cursor, Table.toToken())
+
+	table, newCursor, ok := parseTokenKind(tokens, cursor, IdentifierKind)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected table name")
+		return nil, initialCursor, false
+	}
+	cursor = newCursor
+
+	return &TruncateStatement{
+		table: *table,
+	}, cursor, true
+}
+
+func parseCreateStatement(tokens []*token, initialCursor uint, delimiter token) (*CreateTableStatement, uint, bool) {
+	cursor := initialCursor
+	var ok bool
+
+	_, cursor, ok = parseToken(tokens, cursor, Create.toToken())
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, Table.toToken())
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	name, newCursor, ok := parseTokenKind(tokens, cursor, IdentifierKind)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected table name")
+		return nil, initialCursor, false
+	}
+	cursor = newCursor
+
+	_, cursor, ok = parseToken(tokens, cursor, LeftParen.toToken())
+	if !ok {
+		helpMessage(tokens, cursor, "Expected left parenthesis")
+		return nil, initialCursor, false
+	}
+
+	cols, newCursor, ok := parseColumnDefinitions(tokens, cursor, RightParen.toToken())
+	if !ok {
+		return nil, initialCursor, false
+	}
+	cursor = newCursor
+
+	_, cursor, ok = parseToken(tokens, cursor, RightParen.toToken())
+	if !ok {
+		helpMessage(tokens, cursor, "Expected right parenthesis")
+		return nil, initialCursor, false
+	}
+
+	return &CreateTableStatement{
+		name: *name,
+		cols: cols,
+	}, cursor, true
+}
+
+func parseCreateIndexStatement(tokens []*token, initialCursor uint, delimiter token) (*CreateIndexStatement, uint, bool) {
+	cursor := initialCursor
+	var ok bool
+
+	_, cursor, ok = parseToken(tokens, cursor, Create.toToken())
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	unique := false
+	_, cursor, ok = parseToken(tokens, cursor, Unique.toToken())
+	if ok {
+		unique = true
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, Index.toToken())
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	name, newCursor, ok := parseTokenKind(tokens, cursor, IdentifierKind)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected index name")
+		return nil, initialCursor, false
+	}
+	cursor = newCursor
+
+	_, cursor, ok = parseToken(tokens, cursor, On.toToken())
+	if !ok {
+		helpMessage(tokens, cursor, "Expected ON")
+		return nil, initialCursor, false
+	}
+
+	table, newCursor, ok := parseTokenKind(tokens, cursor, IdentifierKind)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected table name")
+		return nil, initialCursor, false
+	}
+	cursor = newCursor
+
+	_, cursor, ok = parseToken(tokens, cursor, LeftParen.toToken())
+	if !ok {
+		helpMessage(tokens, cursor, "Expected left parenthesis")
+		return nil, initialCursor, false
+	}
+
+	exp, newCursor, ok := parseExpression(tokens, cursor, []token{RightParen.toToken()}, 0)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected index expression")
+		return nil, initialCursor, false
+	}
+	cursor = newCursor
+
+	_, cursor, ok = parseToken(tokens, cursor, RightParen.toToken())
+	if !ok {
+		helpMessage(tokens, cursor, "Expected right parenthesis")
+		return nil, initialCursor, false
+	}
+
+	return &CreateIndexStatement{
+		table:  *table,
+		name:   *name,
+		unique: unique,
+		exp:    *exp,
+	}, cursor, true
+}
+
+func parseColumnDefinitions(tokens []*token, initialCursor uint, delimiter token) (*[]*columnDefinition, uint, bool) {
+	cursor := initialCursor
+
+	cds := []*columnDefinition{}
+	for {
+		if cursor >= uint(len(tokens)) {
+			return nil, initialCursor, false
+		}
+
+		current := tokens[cursor]
+		if delimiter.equals(current) {
+			break
+		}
+
+		if len(cds) > 0 {
+			var ok bool
+			_, cursor, ok = parseToken(tokens, cursor, Comma.toToken())
+			if !ok {
+				helpMessage(tokens, cursor, "Expected comma")
+				return nil, initialCursor, false
+			}
+		}
+
+		id, newCursor, ok := parseTokenKind(tokens, cursor, IdentifierKind)
+		if !ok {
+			helpMessage(tokens, cursor, "Expected column name")
+			return nil, initialCursor, false
+		}
+		cursor = newCursor
+
+		// Date and time type names are not reserved, so they are identifiers
+		ty, newCursor, ok := parseTokenKind(tokens, cursor, KeywordKind)
+		if !ok {
+			ty, newCursor, ok = parseTokenKind(tokens, cursor, IdentifierKind)
+		}
+		if !ok {
+			helpMessage(tokens, cursor, "Expected column type")
+			return nil, initialCursor, false
+		}
+		cursor = newCursor
+
+		cd := columnDefinition{
+			name:     *id,
+			dataType: *ty,
+		}
+
+		// A length, as in varchar(10)
+		_, newCursor, ok = parseToken(tokens, cursor, LeftParen.toToken())
+		if ok {
+			cd.length, newCursor, ok = parseTokenKind(tokens, newCursor, NumericKind)
+			if !ok {
+				helpMessage(tokens, cursor, "Expected column length")
+				return nil, initialCursor, false
+			}
+
+			_, newCursor, ok = parseToken(tokens, newCursor, RightParen.toToken())
+			if !ok {
+				helpMessage(tokens, cursor, "Expected closing parenthesis")
+				return nil, initialCursor, false
+			}
+
+			cursor = newCursor
+		}
+
+		// Constraints, in any order
+		for {
+			_, newCursor, ok = parseToken(tokens, cursor, PrimaryKey.toToken())
+			if ok {
+				cd.primaryKey = true
+				cursor = newCursor
+				continue
+			}
+
+			_, newCursor, ok = parseToken(tokens, cursor, Not.toToken())
+			if ok {
+				_, newCursor, ok = parseTokenKind(tokens, newCursor, NullKind)
+				if !ok {
+					helpMessage(tokens, cursor, "Expected NULL")
+					return nil, initialCursor, false
+				}
+
+				cd.notNull = true
+				cursor = newCursor
+				continue
+			}
+
+			// NULL only says the column is nullable, which is the default
+			_, newCursor, ok = parseTokenKind(tokens, cursor, NullKind)
+			if ok {
+				cursor = newCursor
+				continue
+			}
+
+			_, newCursor, ok = parseToken(tokens, cursor, Default.toToken())
+			if ok {
+				// The expression ends at the next constraint or column
+				delimiters := []token{
+					Comma.toToken(),
+					delimiter,
+					PrimaryKey.toToken(),
+					Not.toToken(),
+					{kind: NullKind, value: string(Null)},
+					Default.toToken(),
+				}
+				exp, newCursor, ok := parseExpression(tokens, newCursor, delimiters, 0)
+				if !ok {
+					helpMessage(tokens, cursor, "Expected DEFAULT expression")
+					return nil, initialCursor, false
+				}
+
+				cd.defaultValue = exp
+				cursor = newCursor
+				continue
+			}
+
+			break
+		}
+
+		cds = append(cds, &cd)
+	}
+
+	return &cds, cursor, true
+}
+
+func parseSelectStatement(tokens []*token, initialCursor uint, delimiter token) (*SelectStatement, uint, bool) {
+	var ok bool
+	cursor := initialCursor
+	_, cursor, ok = parseToken(tokens, cursor, Select.toToken())
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	slct := SelectStatement{}
+
+	fromToken := From.toToken()
+	whereToken := Where.toToken()
+	orderToken := Order.toToken()
+	limitToken := Limit.toToken()
+	offsetToken := Offset.toToken()
+	groupToken := Group.toToken()
+	havingToken := Having.toToken()
+	forToken := For.toToken()
+	item, newCursor, ok := parseSelectItem(tokens, cursor, []token{fromToken, whereToken, groupToken, havingToken, orderToken, limitToken, offsetToken, forToken, delimiter})
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	slct.item = item
+	cursor = newCursor
+
+	_, cursor, ok = parseToken(tokens, cursor, fromToken)
+	if ok {
+		from, newCursor, ok := parseFromItem(tokens, cursor, []token{whereToken, groupToken, havingToken, orderToken, limitToken, offsetToken, forToken, delimiter})
+		if !ok {
+			helpMessage(tokens, cursor, "Expected FROM item")
+			return nil, initialCursor, false
+		}
+		slct.from = from
+		cursor = newCursor
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, whereToken)
+	if ok {
+		where, newCursor, ok := parseExpression(tokens, cursor, []token{groupToken, havingToken, orderToken, limitToken, offsetToken, forToken, delimiter}, 0)
+		if !ok {
+			helpMessage(tokens, cursor, "Expected WHERE conditionals")
+			return nil, initialCursor, false
+		}
+		slct.where = where
+		cursor = newCursor
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, groupToken)
+	if ok {
+		_, cursor, ok = parseToken(tokens, cursor, By.toToken())
+		if !ok {
+			helpMessage(tokens, cursor, "Expected BY after GROUP")
+			return nil, initialCursor, false
+		}
+
+		var groupBy []*expression
+		for {
+			exp, newCursor, ok := parseExpression(tokens, cursor, []token{Comma.toToken(), havingToken, orderToken, limitToken, offsetToken, forToken, delimiter}, 0)
+			if !ok {
+				helpMessage(tokens, cursor, "Expected GROUP BY expression")
+				return nil, initialCursor, false
+			}
+			cursor = newCursor
+			groupBy = append(groupBy, exp)
+
+			_, cursor, ok = parseToken(tokens, cursor, Comma.toToken())
+			if !ok {
+				break
+			}
+		}
+		slct.groupBy = &groupBy
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, havingToken)
+	if ok {
+		having, newCursor, ok := parseExpression(tokens, cursor, []token{orderToken, limitToken, offsetToken, forToken, delimiter}, 0)
+		if !ok {
+			helpMessage(tokens, cursor, "Expected HAVING conditionals")
+			return nil, initialCursor, false
+		}
+		slct.having = having
+		cursor = newCursor
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, orderToken)
+	if ok {
+		_, cursor, ok = parseToken(tokens, cursor, By.toToken())
+		if !ok {
+			helpMessage(tokens, cursor, "Expected BY after ORDER")
+			return nil, initialCursor, false
+		}
+
+		orderBy, newCursor, ok := parseOrderByItems(tokens, cursor, []token{limitToken, offsetToken, forToken, delimiter})
+		if !ok {
+			return nil, initialCursor, false
+		}
+		slct.orderBy = orderBy
+		cursor = newCursor
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, limitToken)
+	if ok {
+		limit, newCursor, ok := parseExpression(tokens, cursor, []token{offsetToken, forToken, delimiter}, 0)
+		if !ok {
+			helpMessage(tokens, cursor, "Expected LIMIT expression")
+			return nil, initialCursor, false
+		}
+		slct.limit = limit
+		cursor = newCursor
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, offsetToken)
+	if ok {
+		offset, newCursor, ok := parseExpression(tokens, cursor, []token{forToken, delimiter}, 0)
+		if !ok {
+			helpMessage(tokens, cursor, "Expected OFFSET expression")
+			return nil, initialCursor, false
+		}
+		slct.offset = offset
+		cursor = newCursor
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, forToken)
+	if ok {
+		if _, newCursor, ok := parseToken(tokens, cursor, Update.toToken()); ok {
+			slct.lock = exclusiveLock
+			cursor = newCursor
+		} else if _, newCursor, ok := parseToken(tokens, cursor, Share.toToken()); ok {
+			slct.lock = sharedLock
+			cursor = newCursor
+		} else {
+			helpMessage(tokens, cursor, "Expected UPDATE or SHARE after FOR")
+			return nil, initialCursor, false
+		}
+	}
+
+	return &slct, cursor, true
+}
+
+// parseFromItem parses tables joined together, left to right.
+func parseFromItem(tokens []*token, initialCursor uint, delimiters []token) (*fromItem, uint, bool) {
+	item, cursor, ok := parseTableReference(tokens, initialCursor)
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	commaToken := Comma.toToken()
+	joinDelimiters := append([]token{
+		commaToken,
+		Join.toToken(),
+		Inner.toToken(),
+		Left.toToken(),
+		Right.toToken(),
+		Full.toToken(),
+		Cross.toToken(),
+	}, delimiters...)
 
-	cds := []*columnDefinition{}
 	for {
-		if cursor >= uint(len(tokens)) {
-			return nil, initialCursor, false
+		kind := crossJoin
+		if _, newCursor, ok := parseToken(tokens, cursor, commaToken); ok {
+			cursor = newCursor
+		} else if kind, newCursor, ok = parseJoinKind(tokens, cursor); ok {
+			cursor = newCursor
+		} else {
+			break
 		}
 
-		current := tokens[cursor]
-		if delimiter.equals(current) {
-			break
+		b, newCursor, ok := parseTableReference(tokens, cursor)
+		if !ok {
+			helpMessage(tokens, cursor, "Expected table to join")
+			return nil, initialCursor, false
 		}
+		cursor = newCursor
 
-		if len(cds) > 0 {
-			var ok bool
-			_, cursor, ok = parseToken(tokens, cursor, Comma.toToken())
+		join := joinExpression{a: *item, b: *b, kind: kind}
+		if kind != crossJoin {
+			_, cursor, ok = parseToken(tokens, cursor, On.toToken())
 			if !ok {
-				helpMessage(tokens, cursor, "Expected comma")
+				helpMessage(tokens, cursor, "Expected ON")
 				return nil, initialCursor, false
 			}
-		}
 
-		id, newCursor, ok := parseTokenKind(tokens, cursor, IdentifierKind)
-		if !ok {
-			helpMessage(tokens, cursor, "Expected column name")
-			return nil, initialCursor, false
+			on, newCursor, ok := parseExpression(tokens, cursor, joinDelimiters, 0)
+			if !ok {
+				helpMessage(tokens, cursor, "Expected join condition")
+				return nil, initialCursor, false
+			}
+			cursor = newCursor
+			join.on = on
 		}
-		cursor = newCursor
 
-		ty, newCursor, ok := parseTokenKind(tokens, cursor, KeywordKind)
-		if !ok {
-			helpMessage(tokens, cursor, "Expected column type")
-			return nil, initialCursor, false
-		}
+		item = &fromItem{join: &join}
+	}
+
+	return item, cursor, true
+}
+
+func parseJoinKind(tokens []*token, initialCursor uint) (joinKind, uint, bool) {
+	cursor := initialCursor
+	outer := false
+
+	kind := innerJoin
+	if _, newCursor, ok := parseToken(tokens, cursor, Inner.toToken()); ok {
+		cursor = newCursor
+	} else if _, newCursor, ok := parseToken(tokens, cursor, Left.toToken()); ok {
+		kind = leftJoin
+		outer = true
+		cursor = newCursor
+	} else if _, newCursor, ok := parseToken(tokens, cursor, Right.toToken()); ok {
+		kind = rightJoin
+		outer = true
 		cursor = newCursor
+	} else if _, newCursor, ok := parseToken(tokens, cursor, Full.toToken()); ok {
+		kind = fullJoin
+		outer = true
+		cursor = newCursor
+	} else if _, newCursor, ok := parseToken(tokens, cursor, Cross.toToken()); ok {
+		kind = crossJoin
+		cursor = newCursor
+	}
 
-		primaryKey := false
-		_, cursor, ok = parseToken(tokens, cursor, PrimaryKey.toToken())
-		if ok {
-			primaryKey = true
+	if outer {
+		if _, newCursor, ok := parseToken(tokens, cursor, Outer.toToken()); ok {
+			cursor = newCursor
 		}
+	}
 
-		cds = append(cds, &columnDefinition{
-			name:       *id,
-			dataType:   *ty,
-			primaryKey: primaryKey,
-		})
+	_, cursor, ok := parseToken(tokens, cursor, Join.toToken())
+	if !ok {
+		return 0, initialCursor, false
 	}
 
-	return &cds, cursor, true
+	return kind, cursor, true
 }
 
-func parseSelectStatement(tokens []*token, initialCursor uint, delimiter token) (*SelectStatement, uint, bool) {
-	var ok bool
-	cursor := initialCursor
-	_, cursor, ok = parseToken(tokens, cursor, Select.toToken())
+// parseTableReference parses a table name followed by an optional alias,
+// with or without AS, or a subquery followed by its alias.
+func parseTableReference(tokens []*token, initialCursor uint) (*fromItem, uint, bool) {
+	item := fromItem{}
+	if slct, cursor, ok := parseSubquery(tokens, initialCursor); ok {
+		_, cursor, _ = parseToken(tokens, cursor, As.toToken())
+		alias, cursor, ok := parseTokenKind(tokens, cursor, IdentifierKind)
+		if !ok {
+			helpMessage(tokens, cursor, "Expected alias of subquery")
+			return nil, initialCursor, false
+		}
+
+		item.subquery = slct
+		item.as = alias
+		return &item, cursor, true
+	}
+
+	table, cursor, ok := parseTokenKind(tokens, initialCursor, IdentifierKind)
 	if !ok {
 		return nil, initialCursor, false
 	}
 
-	slct := SelectStatement{}
+	item.table = table
 
-	fromToken := From.toToken()
-	item, newCursor, ok := parseSelectItem(tokens, cursor, []token{fromToken, delimiter})
-	if !ok {
+	_, newCursor, hasAs := parseToken(tokens, cursor, As.toToken())
+	if alias, newCursor, ok := parseTokenKind(tokens, newCursor, IdentifierKind); ok {
+		item.as = alias
+		cursor = newCursor
+	} else if hasAs {
+		helpMessage(tokens, newCursor, "Expected identifier after AS")
 		return nil, initialCursor, false
 	}
 
-	slct.item = item
-	cursor = newCursor
+	return &item, cursor, true
+}
 
-	whereToken := Where.toToken()
+func parseOrderByItems(tokens []*token, initialCursor uint, delimiters []token) (*[]*orderByItem, uint, bool) {
+	cursor := initialCursor
 
-	_, cursor, ok = parseToken(tokens, cursor, fromToken)
-	if ok {
-		from, newCursor, ok := parseTokenKind(tokens, cursor, IdentifierKind)
+	ascToken := Asc.toToken()
+	descToken := Desc.toToken()
+	nullsToken := Nulls.toToken()
+	commaToken := Comma.toToken()
+
+	var items []*orderByItem
+	for {
+		exp, newCursor, ok := parseExpression(tokens, cursor, append(delimiters, commaToken, ascToken, descToken, nullsToken), 0)
 		if !ok {
-			helpMessage(tokens, cursor, "Expected FROM item")
+			helpMessage(tokens, cursor, "Expected ORDER BY expression")
 			return nil, initialCursor, false
 		}
-		slct.from = from
 		cursor = newCursor
-	}
 
-	_, cursor, ok = parseToken(tokens, cursor, whereToken)
-	if ok {
-		where, newCursor, ok := parseExpression(tokens, cursor, []token{delimiter}, 0)
+		item := orderByItem{exp: *exp}
+		if _, newCursor, ok := parseToken(tokens, cursor, descToken); ok {
+			item.desc = true
+			cursor = newCursor
+		} else if _, newCursor, ok := parseToken(tokens, cursor, ascToken); ok {
+			cursor = newCursor
+		}
+
+		item.nullsFirst = item.desc
+		if _, newCursor, ok := parseToken(tokens, cursor, nullsToken); ok {
+			cursor = newCursor
+
+			// FIRST and LAST are not reserved, so that they can still
+			// name columns.
+			position, newCursor, ok := parseTokenKind(tokens, cursor, IdentifierKind)
+			if !ok || (position.value != "first" && position.value != "last") {
+				helpMessage(tokens, cursor, "Expected FIRST or LAST after NULLS")
+				return nil, initialCursor, false
+			}
+
+			item.nullsFirst = position.value == "first"
+			cursor = newCursor
+		}
+
+		items = append(items, &item)
+
+		_, cursor, ok = parseToken(tokens, cursor, commaToken)
 		if !ok {
-			helpMessage(tokens, cursor, "Expected WHERE conditionals")
-			return nil, initialCursor, false
+			break
 		}
-		slct.where = where
-		cursor = newCursor
 	}
 
-	return &slct, cursor, true
+	return &items, cursor, true
 }
 
 func parseSelectItem(tokens []*token, initialCursor uint, delimiters []token) (*[]*selectItem, uint, bool) {
@@ -308,6 +1186,9 @@ outer:
 		_, cursor, ok = parseToken(tokens, cursor, Asterisk.toToken())
 		if ok {
 			si = selectItem{asterisk: true}
+		} else if table, newCursor, ok := parseQualifiedAsterisk(tokens, cursor); ok {
+			cursor = newCursor
+			si = selectItem{asterisk: true, table: table}
 		} else {
 			asToken := As.toToken()
 			delimiters := append(delimiters, Comma.toToken(), asToken)
@@ -339,17 +1220,42 @@ outer:
 	return &s, cursor, true
 }
 
+// parseQualifiedAsterisk parses the table.* select item.
+func parseQualifiedAsterisk(tokens []*token, initialCursor uint) (*token, uint, bool) {
+	table, cursor, ok := parseTokenKind(tokens, initialCursor, IdentifierKind)
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, Dot.toToken())
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, Asterisk.toToken())
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	return table, cursor, true
+}
+
 func parseExpression(tokens []*token, initialCursor uint, delimiters []token, minBp uint) (*expression, uint, bool) {
 	cursor := initialCursor
 
 	var exp *expression
 	var ok bool
-	_, newCursor, ok := parseToken(tokens, cursor, LeftParen.toToken())
-	if ok {
+	if slct, newCursor, ok := parseSubquery(tokens, cursor); ok {
+		cursor = newCursor
+		exp = &expression{
+			subquery: &subquery{form: scalarSubquery, slct: slct},
+			kind:     subqueryKind,
+		}
+	} else if _, newCursor, ok := parseToken(tokens, cursor, LeftParen.toToken()); ok {
 		cursor = newCursor
 		rightParenToken := RightParen.toToken()
 
-		exp, newCursor, ok = parseExpression(tokens, cursor, append(delimiters, rightParenToken), minBp)
+		exp, newCursor, ok = parseExpression(tokens, cursor, append(delimiters, rightParenToken), 0)
 		if !ok {
 			helpMessage(tokens, cursor, "Expected expression after opening paren")
 			return nil, initialCursor, false
@@ -362,6 +1268,67 @@ func parseExpression(tokens []*token, initialCursor uint, delimiters []token, mi
 			return nil, initialCursor, false
 		}
 
+	} else if _, newCursor, ok := parseToken(tokens, cursor, Exists.toToken()); ok {
+		slct, newCursor, ok := parseSubquery(tokens, newCursor)
+		if !ok {
+			helpMessage(tokens, newCursor, "Expected subquery after EXISTS")
+			return nil, initialCursor, false
+		}
+		cursor = newCursor
+
+		exp = &expression{
+			subquery: &subquery{form: existsSubquery, slct: slct},
+			kind:     subqueryKind,
+		}
+	} else if op, newCursor, ok := parseToken(tokens, cursor, Not.toToken()); ok {
+		cursor = newCursor
+
+		// The operand takes every operator binding tighter than NOT
+		a, newCursor, ok := parseExpression(tokens, cursor, delimiters, op.bindingPower())
+		if !ok {
+			helpMessage(tokens, cursor, "Expected operand")
+			return nil, initialCursor, false
+		}
+		cursor = newCursor
+
+		exp = &expression{
+			unary: &unaryExpression{
+				*a,
+				*op,
+			},
+			kind: unaryKind,
+		}
+	} else if op, newCursor, ok := parseToken(tokens, cursor, Minus.toToken()); ok {
+		cursor = newCursor
+
+		// Negative numbers are folded into the literal itself, like they
+		// would be by lexNumeric if it could tell them apart from a minus.
+		if num, newCursor, ok := parseTokenKind(tokens, cursor, NumericKind); ok {
+			cursor = newCursor
+			exp = &expression{
+				literal: &token{
+					value: "-" + num.value,
+					kind:  NumericKind,
+					loc:   op.loc,
+				},
+				kind: literal,
+			}
+		} else {
+			a, newCursor, ok := parseExpression(tokens, cursor, delimiters, unaryMinusBindingPower)
+			if !ok {
+				helpMessage(tokens, cursor, "Expected operand")
+				return nil, initialCursor, false
+			}
+			cursor = newCursor
+
+			exp = &expression{
+				unary: &unaryExpression{
+					*a,
+					*op,
+				},
+				kind: unaryKind,
+			}
+		}
 	} else {
 		exp, cursor, ok = parseLiteralExpression(tokens, cursor)
 		if !ok {
@@ -369,6 +1336,8 @@ func parseExpression(tokens []*token, initialCursor uint, delimiters []token, mi
 		}
 	}
 
+	isToken := Is.toToken()
+	inToken := In.toToken()
 	lastCursor := cursor
 outer:
 	for cursor < uint(len(tokens)) {
@@ -386,6 +1355,27 @@ outer:
 			XEqual.toToken(),
 			Comma.toToken(),
 			Plus.toToken(),
+			Minus.toToken(),
+			Asterisk.toToken(),
+			Slash.toToken(),
+			Percent.toToken(),
+			Concat.toToken(),
+			Greater.toToken(),
+			GreaterOrEqual.toToken(),
+			Less.toToken(),
+			LessOrEqual.toToken(),
+			Is.toToken(),
+			inToken,
+		}
+
+		// NOT is only found after an operand in NOT IN
+		not, newCursor, isNot := parseToken(tokens, cursor, Not.toToken())
+		if isNot {
+			if _, _, ok := parseToken(tokens, newCursor, inToken); ok {
+				cursor = newCursor
+			} else {
+				not = nil
+			}
 		}
 
 		var op *token = nil
@@ -409,7 +1399,72 @@ outer:
 			break
 		}
 
-		b, newCursor, ok := parseExpression(tokens, cursor, delimiters, bp)
+		// IS [NOT] NULL is postfix, and kept as a comparison with NULL
+		if op.equals(&isToken) {
+			not, newCursor, isNot := parseToken(tokens, cursor, Not.toToken())
+			if isNot {
+				cursor = newCursor
+			}
+
+			null, newCursor, ok := parseTokenKind(tokens, cursor, NullKind)
+			if !ok {
+				helpMessage(tokens, cursor, "Expected NULL")
+				return nil, initialCursor, false
+			}
+			cursor = newCursor
+
+			exp = &expression{
+				binary: &binaryExpression{
+					*exp,
+					expression{literal: null, kind: literal},
+					*op,
+				},
+				kind: binaryKind,
+			}
+			if isNot {
+				exp = &expression{
+					unary: &unaryExpression{
+						*exp,
+						*not,
+					},
+					kind: unaryKind,
+				}
+			}
+
+			lastCursor = cursor
+			continue
+		}
+
+		// [NOT] IN is followed by a subquery rather than an operand
+		if op.equals(&inToken) {
+			slct, newCursor, ok := parseSubquery(tokens, cursor)
+			if !ok {
+				helpMessage(tokens, cursor, "Expected subquery after IN")
+				return nil, initialCursor, false
+			}
+			cursor = newCursor
+
+			exp = &expression{
+				subquery: &subquery{form: inSubquery, slct: slct, a: exp},
+				kind:     subqueryKind,
+			}
+			if not != nil {
+				exp = &expression{
+					unary: &unaryExpression{
+						*exp,
+						*not,
+					},
+					kind: unaryKind,
+				}
+			}
+
+			lastCursor = cursor
+			continue
+		}
+
+		// Operators are left-associative, so the right operand only takes
+		// operators that bind tighter.
+		b, newCursor, ok := parseExpression(tokens, cursor, delimiters, bp+1)
 		if !ok {
 			helpMessage(tokens, cursor, "Expected right operand")
 			return nil, initialCursor, false
@@ -431,9 +1486,68 @@ outer:
 	return exp, cursor, true
 }
 
+// parseSubquery parses a SELECT in parens.
+func parseSubquery(tokens []*token, initialCursor uint) (*SelectStatement, uint, bool) {
+	_, cursor, ok := parseToken(tokens, initialCursor, LeftParen.toToken())
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	rightParenToken := RightParen.toToken()
+	slct, cursor, ok := parseSelectStatement(tokens, cursor, rightParenToken)
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, rightParenToken)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected closing paren")
+		return nil, initialCursor, false
+	}
+
+	return slct, cursor, true
+}
+
 func parseLiteralExpression(tokens []*token, initialCursor uint) (*expression, uint, bool) {
 	cursor := initialCursor
-	kinds := []tokenKind{IdentifierKind, NumericKind, StringKind, BoolKind}
+
+	if ide, newCursor, ok := parseTokenKind(tokens, cursor, IdentifierKind); ok {
+		// An identifier followed by an opening paren is a function call
+		if _, _, ok := parseToken(tokens, newCursor, LeftParen.toToken()); ok {
+			return parseFunctionCall(tokens, cursor)
+		}
+
+		// A date or time type name followed by a string is a literal of
+		// that type
+		if _, ok := datetimeTypes[ide.value]; ok {
+			if value, newCursor, ok := parseTokenKind(tokens, newCursor, StringKind); ok {
+				return &expression{
+					typed: &typedLiteral{typ: *ide, value: *value},
+					kind:  typedLiteralKind,
+				}, newCursor, true
+			}
+		}
+
+		// A column qualified by its table is kept as a single identifier
+		if _, newCursor, ok := parseToken(tokens, newCursor, Dot.toToken()); ok {
+			column, newCursor, ok := parseTokenKind(tokens, newCursor, IdentifierKind)
+			if !ok {
+				helpMessage(tokens, newCursor, "Expected column name")
+				return nil, initialCursor, false
+			}
+
+			return &expression{
+				literal: &token{
+					value: ide.value + "." + column.value,
+					kind:  IdentifierKind,
+					loc:   ide.loc,
+				},
+				kind: literal,
+			}, newCursor, true
+		}
+	}
+
+	kinds := []tokenKind{IdentifierKind, NumericKind, StringKind, BoolKind, NullKind}
 	for _, kind := range kinds {
 		t, newCursor, ok := parseTokenKind(tokens, cursor, kind)
 		if ok {
@@ -447,6 +1561,80 @@ func parseLiteralExpression(tokens []*token, initialCursor uint) (*expression, u
 	return nil, initialCursor, false
 }
 
+func parseFunctionCall(tokens []*token, initialCursor uint) (*expression, uint, bool) {
+	name, cursor, ok := parseTokenKind(tokens, initialCursor, IdentifierKind)
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, LeftParen.toToken())
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	rightParenToken := RightParen.toToken()
+
+	fc := functionCall{name: *name}
+	if _, newCursor, ok := parseToken(tokens, cursor, Asterisk.toToken()); ok {
+		fc.star = true
+		cursor = newCursor
+	} else if args, newCursor, ok := parseExtractArgs(tokens, cursor, fc.name); ok {
+		fc.args = args
+		cursor = newCursor
+	} else {
+		args, newCursor, ok := parseExpressions(tokens, cursor, []token{rightParenToken})
+		if !ok {
+			return nil, initialCursor, false
+		}
+		cursor = newCursor
+
+		for _, arg := range *args {
+			fc.args = append(fc.args, *arg)
+		}
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, rightParenToken)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected closing paren")
+		return nil, initialCursor, false
+	}
+
+	return &expression{
+		function: &fc,
+		kind:     functionKind,
+	}, cursor, true
+}
+
+// parseExtractArgs parses the arguments of extract(field FROM source), which
+// are kept as extract('field', source).
+func parseExtractArgs(tokens []*token, initialCursor uint, name token) ([]expression, uint, bool) {
+	if name.value != "extract" {
+		return nil, initialCursor, false
+	}
+
+	field, cursor, ok := parseTokenKind(tokens, initialCursor, IdentifierKind)
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, From.toToken())
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	source, cursor, ok := parseExpression(tokens, cursor, []token{RightParen.toToken()}, 0)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected expression")
+		return nil, initialCursor, false
+	}
+
+	fieldExp := expression{
+		literal: &token{value: field.value, kind: StringKind, loc: field.loc},
+		kind:    literal,
+	}
+	return []expression{fieldExp, *source}, cursor, true
+}
+
 func parseExpressions(tokens []*token, initialCursor uint, delimiters []token) (*[]*expression, uint, bool) {
 	cursor := initialCursor
 
@@ -516,10 +1704,10 @@ func parseTokenKind(tokens []*token, initialCursor uint, kind tokenKind) (*token
 
 func helpMessage(tokens []*token, cursor uint, msg string) {
 	var c *token
-	if cursor+1 < uint(len(tokens)) {
+	if cursor < uint(len(tokens)) {
 		c = tokens[cursor]
 	} else {
-		c = tokens[cursor]
+		c = tokens[len(tokens)-1]
 	}
 
 	fmt.Printf("[%d,%d]: %s, near: %s\n", c.loc.line, c.loc.col, msg, c.value)
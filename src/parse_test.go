@@ -0,0 +1,333 @@
+package src
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseExpression(t *testing.T) {
+	tests := []struct {
+		source string
+		code   string
+	}{
+		{
+			source: "a = 1",
+			code:   `("a" = 1)`,
+		},
+		{
+			source: "a <> 1",
+			code:   `("a" != 1)`,
+		},
+		{
+			source: "a < 1 and b >= 'x'",
+			code:   `(("a" < 1) and ("b" >= 'x'))`,
+		},
+		{
+			source: "a > 1 or b <= 2 and c",
+			code:   `(("a" > 1) or (("b" <= 2) and "c"))`,
+		},
+		{
+			source: "not a = 1 and b",
+			code:   `((not ("a" = 1)) and "b")`,
+		},
+		{
+			source: "not not a",
+			code:   `(not (not "a"))`,
+		},
+		{
+			source: "a is null or b is not null",
+			code:   `(("a" is null) or (not ("b" is null)))`,
+		},
+		{
+			source: "a = null",
+			code:   `("a" = null)`,
+		},
+		{
+			source: "a + 1 < b",
+			code:   `(("a" + 1) < "b")`,
+		},
+		{
+			source: "a + b + c",
+			code:   `(("a" + "b") + "c")`,
+		},
+		{
+			source: "not (a or b)",
+			code:   `(not ("a" or "b"))`,
+		},
+		{
+			source: "a - b - c",
+			code:   `(("a" - "b") - "c")`,
+		},
+		{
+			source: "a + b * c - d / 2 % e",
+			code:   `(("a" + ("b" * "c")) - (("d" / 2) % "e"))`,
+		},
+		{
+			source: "(a + b) * c",
+			code:   `(("a" + "b") * "c")`,
+		},
+		{
+			source: "-5",
+			code:   `-5`,
+		},
+		{
+			source: "a--5",
+			code:   `("a" - -5)`,
+		},
+		{
+			source: "-a * b",
+			code:   `((- "a") * "b")`,
+		},
+		{
+			source: "-(a + 1) < 2 * -3",
+			code:   `((- ("a" + 1)) < (2 * -3))`,
+		},
+		{
+			source: "count(*) + sum(a * 2)",
+			code:   `(count(*) + sum(("a" * 2)))`,
+		},
+		{
+			source: "f() = max(a, 'b') or g((1))",
+			code:   `((f() = max("a", 'b')) or g(1))`,
+		},
+		{
+			source: "ts >= TIMESTAMP '2024-01-01 10:00' - interval '1 day'",
+			code:   `("ts" >= (timestamp '2024-01-01 10:00' - interval '1 day'))`,
+		},
+		{
+			source: "extract(year FROM ts) = date_trunc('year', now())",
+			code:   `(extract('year', "ts") = date_trunc('year', now()))`,
+		},
+		{
+			source: "a in (SELECT b FROM t WHERE c = 1) and not exists (SELECT * FROM u)",
+			code:   `(("a" in (SELECT "b" FROM "t" WHERE ("c" = 1))) and (not (exists (SELECT * FROM "u"))))`,
+		},
+		{
+			source: "a + 1 not in (SELECT t.b AS x FROM t)",
+			code:   `(not (("a" + 1) in (SELECT "t.b" AS "x" FROM "t")))`,
+		},
+		{
+			source: "(SELECT max(b) FROM t) + 1 = a",
+			code:   `(((SELECT max("b") FROM "t") + 1) = "a")`,
+		},
+	}
+
+	for _, test := range tests {
+		exp, err := parseExpressionSource(test.source)
+		assert.Nil(t, err, test.source)
+		if err == nil {
+			assert.Equal(t, test.code, exp.generateCode(), test.source)
+		}
+	}
+}
+
+func TestParseSelectOrderBy(t *testing.T) {
+	a, err := Parse("SELECT a AS x, b FROM t WHERE a > 1 ORDER BY x DESC, b + 1 NULLS FIRST, c ASC NULLS LAST, d DESC NULLS LAST;")
+	assert.Nil(t, err)
+
+	slct := a.Statements[0].Select
+	assert.Equal(t, `("a" > 1)`, slct.where.generateCode())
+
+	expected := []struct {
+		code       string
+		desc       bool
+		nullsFirst bool
+	}{
+		{code: `"x"`, desc: true, nullsFirst: true},
+		{code: `("b" + 1)`, desc: false, nullsFirst: true},
+		{code: `"c"`, desc: false, nullsFirst: false},
+		{code: `"d"`, desc: true, nullsFirst: false},
+	}
+	assert.Equal(t, len(expected), len(*slct.orderBy))
+	for i, o := range *slct.orderBy {
+		assert.Equal(t, expected[i].code, o.exp.generateCode())
+		assert.Equal(t, expected[i].desc, o.desc)
+		assert.Equal(t, expected[i].nullsFirst, o.nullsFirst)
+	}
+
+	_, err = Parse("SELECT a FROM t ORDER a;")
+	assert.NotNil(t, err)
+
+	_, err = Parse("SELECT a FROM t ORDER BY a NULLS;")
+	assert.NotNil(t, err)
+}
+
+func TestParseSelectFrom(t *testing.T) {
+	a, err := Parse("SELECT u.name FROM users AS u LEFT OUTER JOIN orders o ON u.id = o.user_id, items CROSS JOIN tags FULL JOIN x ON true WHERE u.id > 1;")
+	assert.Nil(t, err)
+
+	slct := a.Statements[0].Select
+	assert.Equal(t, `"u.name"`, (*slct.item)[0].exp.generateCode())
+	assert.Equal(t, `("u.id" > 1)`, slct.where.generateCode())
+
+	// Joins nest to the left
+	from := slct.from
+	assert.Equal(t, fullJoin, from.join.kind)
+	assert.Equal(t, "x", from.join.b.table.value)
+	assert.Equal(t, "true", from.join.on.generateCode())
+
+	from = &from.join.a
+	assert.Equal(t, crossJoin, from.join.kind)
+	assert.Equal(t, "tags", from.join.b.table.value)
+	assert.Nil(t, from.join.on)
+
+	from = &from.join.a
+	assert.Equal(t, crossJoin, from.join.kind)
+	assert.Equal(t, "items", from.join.b.table.value)
+	assert.Nil(t, from.join.b.as)
+
+	from = &from.join.a
+	assert.Equal(t, leftJoin, from.join.kind)
+	assert.Equal(t, `("u.id" = "o.user_id")`, from.join.on.generateCode())
+	assert.Equal(t, "users", from.join.a.table.value)
+	assert.Equal(t, "u", from.join.a.as.value)
+	assert.Equal(t, "orders", from.join.b.table.value)
+	assert.Equal(t, "o", from.join.b.as.value)
+
+	a, err = Parse("SELECT *, t.*, t.a FROM t;")
+	assert.Nil(t, err)
+
+	items := *a.Statements[0].Select.item
+	assert.True(t, items[0].asterisk)
+	assert.Nil(t, items[0].table)
+	assert.True(t, items[1].asterisk)
+	assert.Equal(t, "t", items[1].table.value)
+	assert.Equal(t, `"t.a"`, items[2].exp.generateCode())
+
+	a, err = Parse("SELECT s.n FROM (SELECT count(*) AS n FROM t) s JOIN u ON u.id = s.n;")
+	assert.Nil(t, err)
+
+	from = a.Statements[0].Select.from
+	assert.Equal(t, `(SELECT count(*) AS "n" FROM "t") AS "s" JOIN "u" ON ("u.id" = "s.n")`, from.generateCode())
+	assert.Equal(t, "s", from.join.a.as.value)
+	assert.Equal(t, `count(*)`, (*from.join.a.subquery.item)[0].exp.generateCode())
+
+	_, err = Parse("SELECT 1 FROM (SELECT 1);")
+	assert.NotNil(t, err)
+
+	_, err = Parse("SELECT 1 FROM t WHERE a IN 1;")
+	assert.NotNil(t, err)
+
+	_, err = Parse("SELECT 1 FROM t WHERE EXISTS a;")
+	assert.NotNil(t, err)
+
+	_, err = Parse("SELECT 1 FROM a JOIN b;")
+	assert.NotNil(t, err)
+
+	_, err = Parse("SELECT 1 FROM a AS;")
+	assert.NotNil(t, err)
+
+	_, err = Parse("SELECT a. FROM a;")
+	assert.NotNil(t, err)
+}
+
+func TestParseInsert(t *testing.T) {
+	tests := []struct {
+		source string
+		code   string
+	}{
+		{
+			source: `CREATE TABLE "a""b" (c TEXT DEFAULT 'it''s');`,
+			code:   `CREATE TABLE "a""b" ("c" text DEFAULT 'it''s')`,
+		},
+		{
+			source: "CREATE TABLE t (a BIGINT, b DOUBLE, c FLOAT NOT NULL, d BOOLEAN DEFAULT true, e VARCHAR(10), f TEXT (3));",
+			code:   `CREATE TABLE "t" ("a" bigint, "b" double, "c" float NOT NULL, "d" boolean DEFAULT true, "e" varchar(10), "f" text(3))`,
+		},
+		{
+			source: "CREATE TABLE t (a INT DEFAULT -1 NOT NULL, b TEXT NULL DEFAULT 'x' || 'y', c INT DEFAULT NULL PRIMARY KEY);",
+			code:   `CREATE TABLE "t" ("a" int NOT NULL DEFAULT -1, "b" text DEFAULT ('x' || 'y'), "c" int PRIMARY KEY DEFAULT null)`,
+		},
+	}
+
+	for _, test := range tests {
+		a, err := Parse(test.source)
+		assert.Nil(t, err, test.source)
+		if err == nil {
+			assert.Equal(t, test.code, a.Statements[0].generateCode(), test.source)
+		}
+	}
+
+	a, err := Parse("INSERT INTO t (b, a) VALUES ('x', -1), (NULL, 2 + 3);")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(*a.Statements[0].Insert.values))
+	assert.Equal(t, "a", (*a.Statements[0].Insert.columns)[1].value)
+	assert.Equal(t, "(2 + 3)", (*a.Statements[0].Insert.values)[1][1].generateCode())
+
+	a, err = Parse(`INSERT INTO "a""b" VALUES ('it''s');`)
+	assert.Nil(t, err)
+	assert.Equal(t, `a"b`, a.Statements[0].Insert.table.value)
+	assert.Equal(t, "it's", (*a.Statements[0].Insert.values)[0][0].literal.value)
+
+	a, err = Parse("INSERT INTO t (a) SELECT b FROM u WHERE b > 1;")
+	assert.Nil(t, err)
+	assert.Equal(t, "a", (*a.Statements[0].Insert.columns)[0].value)
+	assert.Equal(t, `("b" > 1)`, a.Statements[0].Insert.selection.where.generateCode())
+
+	_, err = Parse("INSERT INTO t (a) (1);")
+	assert.NotNil(t, err)
+
+	_, err = Parse("INSERT INTO t VALUES (1),;")
+	assert.NotNil(t, err)
+
+	_, err = Parse("SELECT extract(year ts);")
+	assert.NotNil(t, err)
+
+	_, err = Parse("CREATE TABLE t (a VARCHAR(x));")
+	assert.NotNil(t, err)
+
+	_, err = Parse("CREATE TABLE t (a VARCHAR(10);")
+	assert.NotNil(t, err)
+}
+
+func TestParseTransaction(t *testing.T) {
+	a, err := Parse("BEGIN; SELECT 1; COMMIT; ROLLBACK;")
+	assert.Nil(t, err)
+
+	kinds := []astKind{}
+	for _, stmt := range a.Statements {
+		kinds = append(kinds, stmt.Kind)
+	}
+	assert.Equal(t, []astKind{BeginAstKind, SelectAstKind, CommitAstKind, RollbackAstKind}, kinds)
+
+	_, err = Parse("COMMIT t;")
+	assert.NotNil(t, err)
+
+	a, err = Parse("SAVEPOINT a; ROLLBACK TO SAVEPOINT a; ROLLBACK TO b; RELEASE SAVEPOINT a; RELEASE c;")
+	assert.Nil(t, err)
+
+	expected := []struct {
+		kind astKind
+		name string
+	}{
+		{SavepointAstKind, "a"},
+		{RollbackToSavepointAstKind, "a"},
+		{RollbackToSavepointAstKind, "b"},
+		{ReleaseSavepointAstKind, "a"},
+		{ReleaseSavepointAstKind, "c"},
+	}
+	assert.Equal(t, len(expected), len(a.Statements))
+	for i, stmt := range a.Statements {
+		assert.Equal(t, expected[i].kind, stmt.Kind)
+		assert.Equal(t, expected[i].name, stmt.Savepoint.name.value)
+	}
+
+	_, err = Parse("SAVEPOINT;")
+	assert.NotNil(t, err)
+
+	_, err = Parse("ROLLBACK TO;")
+	assert.NotNil(t, err)
+
+	a, err = Parse("SELECT a FROM t WHERE a > 1 ORDER BY a LIMIT 1 FOR UPDATE; SELECT a FROM t FOR SHARE; SELECT a FROM t;")
+	assert.Nil(t, err)
+	assert.Equal(t, exclusiveLock, a.Statements[0].Select.lock)
+	assert.Equal(t, sharedLock, a.Statements[1].Select.lock)
+	assert.Equal(t, lockMode(0), a.Statements[2].Select.lock)
+
+	_, err = Parse("SELECT a FROM t FOR;")
+	assert.NotNil(t, err)
+
+	_, err = Parse("SET lock_timeout;")
+	assert.NotNil(t, err)
+}
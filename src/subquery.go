@@ -0,0 +1,536 @@
+package src
+
+// Subqueries run in the scope of the query that evaluates them. A subquery
+// that reads columns of the enclosing query gets them from the row being
+// evaluated, and runs again for every row, while one that does not only runs
+// once per query. EXISTS and IN subqueries that are only correlated by
+// equalities are turned into semi joins instead: the subquery runs once
+// without the equalities, and its rows are hashed by the values they compare,
+// so that each row of the enclosing query looks up the rows it matches.
+
+// A queryScope is what the expressions of a query are evaluated in: the
+// backend and transaction its subqueries run in, and for a subquery, the row
+// of the enclosing query it runs for.
+type queryScope struct {
+	mb       *MemoryBackend
+	tx       *Transaction
+	outer    *table
+	outerRow uint
+
+	// Whether the row of the enclosing query was read
+	correlated bool
+
+	// How the subqueries of the query run, planned the first time they are
+	// evaluated
+	plans map[*subquery]*subqueryPlan
+
+	// The expressions of a probe are only evaluated for their type, so the
+	// queries run in it only return their columns
+	probe bool
+}
+
+func (mb *MemoryBackend) newScope(tx *Transaction) *queryScope {
+	return &queryScope{
+		mb:    mb,
+		tx:    tx,
+		plans: map[*subquery]*subqueryPlan{},
+	}
+}
+
+// probing returns the scope of a probe evaluating expressions in s.
+func (s *queryScope) probing() *queryScope {
+	if s == nil {
+		return nil
+	}
+
+	probe := *s
+	probe.probe = true
+	return &probe
+}
+
+// outerCell evaluates a column that is not in the tables of a subquery on the
+// row of the enclosing query.
+func (s *queryScope) outerCell(exp expression) (memoryCell, string, columnType, error) {
+	s.correlated = true
+	return s.outer.evaluateCell(s.outerRow, exp)
+}
+
+// subqueryScope returns the scope of a subquery run for a row of t.
+func (t *table) subqueryScope(rowIndex uint) *queryScope {
+	scope := t.scope.mb.newScope(t.scope.tx)
+	scope.outer = t
+	scope.outerRow = rowIndex
+	return scope
+}
+
+// A subqueryPlan is how a subquery runs in a scope.
+type subqueryPlan struct {
+	// The results of a subquery that did not read the row of the enclosing
+	// query, which are the same for every row
+	results *Results
+
+	// The semi join an EXISTS or IN subquery was turned into, if any
+	semiJoin *semiJoin
+}
+
+func (t *table) evaluateSubqueryCell(rowIndex uint, exp expression) (memoryCell, string, columnType, error) {
+	if exp.kind != subqueryKind {
+		return nil, "", 0, InvalidCell
+	}
+
+	// Expressions evaluated outside of a query, like defaults, cannot run
+	// one
+	if t.scope == nil {
+		return nil, "", 0, SubqueryNotAllowed
+	}
+
+	sq := exp.subquery
+	name := expressionName(exp)
+
+	var a memoryCell
+	var aType columnType
+	if sq.form == inSubquery {
+		var err error
+		a, _, aType, err = t.evaluateCell(rowIndex, *sq.a)
+		if err != nil {
+			return nil, "", 0, err
+		}
+	}
+
+	if t.scope.probe {
+		scope := t.subqueryScope(rowIndex)
+		scope.probe = true
+		results, err := t.scope.mb.queryIn(scope, sq.slct)
+		if err != nil {
+			return nil, "", 0, err
+		}
+
+		value, typ, err := subqueryValue(sq, results, a, aType)
+		return value, name, typ, err
+	}
+
+	plan, ok := t.scope.plans[sq]
+	if !ok {
+		var err error
+		plan = &subqueryPlan{}
+		plan.semiJoin, err = t.planSemiJoin(sq)
+		if err != nil {
+			return nil, "", 0, err
+		}
+
+		t.scope.plans[sq] = plan
+	}
+
+	if plan.semiJoin != nil {
+		value, err := plan.semiJoin.lookup(t, rowIndex, a, aType)
+		if err != nil {
+			return nil, "", 0, err
+		}
+
+		return value, name, BoolType, nil
+	}
+
+	results := plan.results
+	if results == nil {
+		scope := t.subqueryScope(rowIndex)
+
+		var err error
+		results, err = t.scope.mb.queryIn(scope, sq.selection())
+		if err != nil {
+			return nil, "", 0, err
+		}
+
+		if !scope.correlated {
+			plan.results = results
+		}
+	}
+
+	value, typ, err := subqueryValue(sq, results, a, aType)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	return value, name, typ, nil
+}
+
+// selection returns the SELECT a subquery runs, which for EXISTS stops at the
+// first row.
+func (sq *subquery) selection() *SelectStatement {
+	if sq.form != existsSubquery || sq.slct.limit != nil || sq.slct.offset != nil {
+		return sq.slct
+	}
+
+	slct := *sq.slct
+	slct.limit = &expression{
+		literal: &token{value: "1", kind: NumericKind},
+		kind:    literal,
+	}
+	return &slct
+}
+
+// subqueryValue returns the value of a subquery from its results: the single
+// value of a scalar subquery, or NULL when it has no row, whether there is
+// any row for EXISTS, and whether a is among them for IN.
+func subqueryValue(sq *subquery, results *Results, a memoryCell, aType columnType) (memoryCell, columnType, error) {
+	if sq.form == existsSubquery {
+		if len(results.Rows) > 0 {
+			return trueMemoryCell, BoolType, nil
+		}
+
+		return falseMemoryCell, BoolType, nil
+	}
+
+	if len(results.Columns) != 1 {
+		return nil, 0, SubqueryTooManyColumns
+	}
+
+	typ := results.Columns[0].Type
+	if sq.form == inSubquery {
+		return inResults(a, aType, results.Rows, typ), BoolType, nil
+	}
+
+	switch len(results.Rows) {
+	case 0:
+		return nil, typ, nil
+	case 1:
+		return results.Rows[0][0].(memoryCell), typ, nil
+	}
+
+	return nil, 0, SubqueryTooManyRows
+}
+
+// inResults tells whether a value is among the rows of an IN subquery. Like a
+// chain of equalities joined by OR, it is NULL rather than false when the
+// value or one of the rows is NULL.
+func inResults(a memoryCell, aType columnType, rows [][]Cell, typ columnType) memoryCell {
+	res := falseMemoryCell
+	for _, row := range rows {
+		b := row[0].(memoryCell)
+		if a.IsNull() || b.IsNull() {
+			res = nil
+			continue
+		}
+
+		left, leftType, right, rightType := unifyOperands(a, aType, b, typ)
+		if leftType == rightType && left.compare(right, leftType) == 0 {
+			return trueMemoryCell
+		}
+	}
+
+	return res
+}
+
+// A semiJoin evaluates an EXISTS or IN subquery whose only references to the
+// enclosing query are equalities between an expression of the subquery and
+// one of the enclosing query, its keys. The subquery runs once without them,
+// and its rows are grouped by the values of their side of the keys.
+type semiJoin struct {
+	form      subqueryForm
+	outerKeys []expression
+
+	// The types the values of the keys and of IN are compared as
+	keyTypes  []columnType
+	valueType columnType
+
+	groups map[string]*semiJoinGroup
+}
+
+// A semiJoinGroup stands for the rows of the subquery with the same keys. For
+// IN, it holds the values they return.
+type semiJoinGroup struct {
+	values  map[string]bool
+	hasNull bool
+}
+
+// planSemiJoin turns an EXISTS or IN subquery evaluated on the rows of t into
+// a semi join, or returns nil when it cannot be. That takes a subquery that
+// only filters the rows of its FROM, whose conditions that refer to t are
+// equalities between an expression of each side, and for IN, whose values do
+// not depend on t.
+func (t *table) planSemiJoin(sq *subquery) (*semiJoin, error) {
+	slct := sq.slct
+	if sq.form == scalarSubquery || slct.from == nil || isGroupedSelect(slct) || slct.limit != nil || slct.offset != nil || slct.lock != 0 {
+		return nil, nil
+	}
+
+	mb := t.scope.mb
+	tx := t.scope.tx
+
+	// A subquery that cannot be planned, like one reading t in FROM, runs
+	// for every row, which reports its errors
+	inner, err := mb.fromTable(mb.newScope(tx), slct.from)
+	if _, ok := err.(*lockWait); ok {
+		return nil, err
+	} else if err != nil {
+		return nil, nil
+	}
+
+	sj := &semiJoin{form: sq.form, groups: map[string]*semiJoinGroup{}}
+
+	// The first column of the rows is the value of IN, the others the keys
+	items := []*selectItem{{exp: &expression{literal: &trueToken, kind: literal}}}
+	innerTypes := []columnType{BoolType}
+	if sq.form == inSubquery {
+		if slct.item == nil || len(*slct.item) != 1 || (*slct.item)[0].asterisk {
+			return nil, nil
+		}
+
+		exp := (*slct.item)[0].exp
+		innerType, err := inner.expressionType(*exp)
+		if err != nil {
+			return nil, nil
+		}
+
+		aType, err := t.expressionType(*sq.a)
+		if err != nil {
+			return nil, err
+		}
+
+		typ, ok := hashedType(aType, innerType)
+		if !ok {
+			return nil, nil
+		}
+
+		items[0] = &selectItem{exp: exp}
+		innerTypes[0] = innerType
+		sj.valueType = typ
+	}
+
+	var conditions []expression
+	for _, exp := range conjuncts(slct.where) {
+		if _, err := inner.expressionType(exp); err == nil {
+			conditions = append(conditions, exp)
+			continue
+		}
+
+		if exp.kind != binaryKind || exp.binary.op.value != string(Equal) {
+			return nil, nil
+		}
+
+		a, b := exp.binary.a, exp.binary.b
+		if !onlyIn(inner, t, a) {
+			a, b = b, a
+		}
+
+		if !onlyIn(inner, t, a) || !onlyIn(t, inner, b) {
+			return nil, nil
+		}
+
+		innerType, _ := inner.expressionType(a)
+		outerType, _ := t.expressionType(b)
+		typ, ok := hashedType(outerType, innerType)
+		if !ok {
+			return nil, nil
+		}
+
+		items = append(items, &selectItem{exp: &a})
+		innerTypes = append(innerTypes, innerType)
+		sj.outerKeys = append(sj.outerKeys, b)
+		sj.keyTypes = append(sj.keyTypes, typ)
+	}
+
+	rewritten := *slct
+	rewritten.item = &items
+	rewritten.where = conjunction(conditions)
+	rewritten.orderBy = nil
+	results, err := mb.query(tx, &rewritten)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range results.Rows {
+		values := []memoryCell{}
+		for _, cell := range row {
+			values = append(values, cell.(memoryCell))
+		}
+
+		key, ok, err := sj.key(values[1:], innerTypes[1:])
+		if err != nil {
+			return nil, err
+		}
+
+		if !ok {
+			continue
+		}
+
+		group, ok := sj.groups[string(key)]
+		if !ok {
+			group = &semiJoinGroup{values: map[string]bool{}}
+			sj.groups[string(key)] = group
+		}
+
+		if sq.form != inSubquery {
+			continue
+		}
+
+		if values[0].IsNull() {
+			group.hasNull = true
+			continue
+		}
+
+		value, err := castCell(values[0], innerTypes[0], sj.valueType)
+		if err != nil {
+			return nil, err
+		}
+
+		group.values[string(value)] = true
+	}
+
+	return sj, nil
+}
+
+// hashedType returns the type two values are converted to before their bytes
+// are compared, which only tell equal values apart for values of the same
+// type. Numbers are converted to the widest of their types, while intervals
+// cannot be compared by their bytes at all.
+func hashedType(a columnType, b columnType) (columnType, bool) {
+	if isNumeric(a) && isNumeric(b) {
+		return promotedType(a, b), true
+	}
+
+	if a != b || a == IntervalType || a == NullType {
+		return 0, false
+	}
+
+	return a, true
+}
+
+// key encodes the values of the keys of a row, or returns false if one of
+// them is NULL, as NULL is equal to nothing.
+func (sj *semiJoin) key(values []memoryCell, types []columnType) ([]byte, bool, error) {
+	key := []byte{}
+	for i, value := range values {
+		if value.IsNull() {
+			return nil, false, nil
+		}
+
+		value, err := castCell(value, types[i], sj.keyTypes[i])
+		if err != nil {
+			return nil, false, err
+		}
+
+		key = appendGroupKey(key, value)
+	}
+
+	return key, true, nil
+}
+
+// lookup evaluates a semi join for a row of t, where a is the value searched
+// for by IN. Like inResults, IN is NULL rather than false when a or one of
+// the values of the matching rows is NULL.
+func (sj *semiJoin) lookup(t *table, rowIndex uint, a memoryCell, aType columnType) (memoryCell, error) {
+	values := []memoryCell{}
+	types := []columnType{}
+	for _, exp := range sj.outerKeys {
+		value, _, typ, err := t.evaluateCell(rowIndex, exp)
+		if err != nil {
+			return nil, err
+		}
+
+		values = append(values, value)
+		types = append(types, typ)
+	}
+
+	key, ok, err := sj.key(values, types)
+	if err != nil {
+		return nil, err
+	}
+
+	group := sj.groups[string(key)]
+	if !ok || group == nil {
+		return falseMemoryCell, nil
+	}
+
+	if sj.form == existsSubquery {
+		return trueMemoryCell, nil
+	}
+
+	if a.IsNull() {
+		return nil, nil
+	}
+
+	a, err = castCell(a, aType, sj.valueType)
+	if err != nil {
+		return nil, err
+	}
+
+	if group.values[string(a)] {
+		return trueMemoryCell, nil
+	}
+
+	if group.hasNull {
+		return nil, nil
+	}
+
+	return falseMemoryCell, nil
+}
+
+// conjuncts splits a condition into the conditions joined by AND in it.
+func conjuncts(where *expression) []expression {
+	if where == nil {
+		return nil
+	}
+
+	if where.kind == binaryKind && where.binary.op.value == string(And) {
+		return append(conjuncts(&where.binary.a), conjuncts(&where.binary.b)...)
+	}
+
+	return []expression{*where}
+}
+
+// conjunction joins conditions by AND, or returns nil when there are none.
+func conjunction(conditions []expression) *expression {
+	var where *expression
+	for i := range conditions {
+		if where == nil {
+			where = &conditions[i]
+			continue
+		}
+
+		where = &expression{
+			binary: &binaryExpression{
+				*where,
+				conditions[i],
+				And.toToken(),
+			},
+			kind: binaryKind,
+		}
+	}
+
+	return where
+}
+
+// derivedTable runs a subquery of FROM into a table named by its alias. Its
+// rows are visible to every transaction, like those of any table built by a
+// query. It may read the row of the query enclosing its own, if any.
+func (mb *MemoryBackend) derivedTable(scope *queryScope, from *fromItem) (*table, error) {
+	inner := mb.newScope(scope.tx)
+	inner.outer = scope.outer
+	inner.outerRow = scope.outerRow
+	inner.probe = scope.probe
+	results, err := mb.queryIn(inner, from.subquery)
+	if err != nil {
+		return nil, err
+	}
+
+	if inner.correlated {
+		scope.correlated = true
+	}
+
+	t := &table{name: from.as.value, scope: scope}
+	for _, col := range results.Columns {
+		t.columns = append(t.columns, col.Name)
+		t.columnTypes = append(t.columnTypes, col.Type)
+	}
+
+	for _, result := range results.Rows {
+		row := []memoryCell{}
+		for _, cell := range result {
+			row = append(row, cell.(memoryCell))
+		}
+
+		t.rows = append(t.rows, row)
+	}
+
+	return t, nil
+}
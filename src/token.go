@@ -1,9 +1,7 @@
 package src
 
 import (
-	"bytes"
-	"encoding/binary"
-	"fmt"
+	"errors"
 	"strconv"
 )
 
@@ -16,6 +14,7 @@ const (
 	StringKind
 	NumericKind
 	BoolKind
+	NullKind
 )
 
 type token struct {
@@ -25,54 +24,85 @@ type token struct {
 }
 
 var (
-	trueToken  = token{kind: BoolKind, value: "true"}
-	falseToken = token{kind: BoolKind, value: "false"}
+	trueToken = token{kind: BoolKind, value: "true"}
 
-	trueMemoryCell  = trueToken.literalToMemoryCell()
-	falseMemoryCell = falseToken.literalToMemoryCell()
+	trueMemoryCell  = memoryCell([]byte{1})
+	falseMemoryCell = memoryCell([]byte{0})
 )
 
-func (t *token) literalToMemoryCell() memoryCell {
+// literalToMemoryCell returns the value of a literal. Numbers too large for
+// a double are infinite, which callers reject.
+func (t *token) literalToMemoryCell() (memoryCell, error) {
 	if t.kind == NumericKind {
-		buf := new(bytes.Buffer)
-		i, err := strconv.Atoi(t.value)
-		if err != nil {
-			fmt.Printf("Corrupted data [%s]: %s\n", t.value, err)
+		switch t.numericType() {
+		case IntType:
+			i, _ := strconv.ParseInt(t.value, 10, 32)
+			return intCell(int32(i)), nil
+		case BigIntType:
+			i, _ := strconv.ParseInt(t.value, 10, 64)
+			return bigIntCell(i), nil
 		}
 
-		err = binary.Write(buf, binary.BigEndian, int32(i))
-		if err != nil {
-			fmt.Printf("Corrupted data [%s]: %s\n", string(buf.Bytes()), err)
+		f, err := strconv.ParseFloat(t.value, 64)
+		if err != nil && !errors.Is(err, strconv.ErrRange) {
+			return nil, InvalidNumber
 		}
-		return memoryCell(buf.Bytes())
+		return floatCell(f), nil
 	}
 
 	if t.kind == StringKind {
-		return memoryCell(t.value)
+		return memoryCell(t.value), nil
 	}
 
 	if t.kind == BoolKind {
 		if t.value == "true" {
-			return memoryCell([]byte{1})
+			return trueMemoryCell, nil
 		}
-		return memoryCell(nil)
+		return falseMemoryCell, nil
 	}
 
-	return nil
+	// NULL
+	return nil, nil
+}
+
+// numericType is the type of a numeric literal: the smallest integer type
+// that holds it, or double for decimals and integers too large for a bigint.
+func (t *token) numericType() columnType {
+	if _, err := strconv.ParseInt(t.value, 10, 32); err == nil {
+		return IntType
+	}
+
+	if _, err := strconv.ParseInt(t.value, 10, 64); err == nil {
+		return BigIntType
+	}
+
+	return FloatType
 }
 
 func (t *token) equals(other *token) bool {
 	return t.value == other.value && t.kind == other.kind
 }
 
+// Unary minus binds tighter than any binary operator.
+const unaryMinusBindingPower = 9
+
+// bindingPower orders operators from loosest to tightest, following
+// PostgreSQL: OR, AND, NOT, IS, comparisons, IN, then addition and
+// concatenation, and multiplication.
 func (t *token) bindingPower() uint {
 	switch t.kind {
 	case KeywordKind:
 		switch keyword(t.value) {
-		case And:
-			fallthrough
 		case Or:
 			return 1
+		case And:
+			return 2
+		case Not:
+			return 3
+		case Is:
+			return 4
+		case In:
+			return 6
 		}
 	case SymbolKind:
 		switch symbol(t.value) {
@@ -80,10 +110,26 @@ func (t *token) bindingPower() uint {
 			fallthrough
 		case XEqual:
 			fallthrough
+		case Greater:
+			fallthrough
+		case GreaterOrEqual:
+			fallthrough
+		case Less:
+			fallthrough
+		case LessOrEqual:
+			return 5
 		case Concat:
 			fallthrough
 		case Plus:
-			return 3
+			fallthrough
+		case Minus:
+			return 7
+		case Asterisk:
+			fallthrough
+		case Slash:
+			fallthrough
+		case Percent:
+			return 8
 		}
 	}
 
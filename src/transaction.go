@@ -0,0 +1,346 @@
+package src
+
+import "time"
+
+// A Transaction groups statements that are committed or rolled back
+// together, and reads the tables as they were when it began.
+//
+// Rows are versioned: a row records the transaction that created it and the
+// one that deleted it, and an update creates a new version of the row. A
+// transaction sees the versions created by the transactions that committed
+// before it began, and by itself, unless their deletion is visible too.
+// Deletions are only applied at commit, which fails when another
+// transaction deleted or updated one of the same rows in the meantime.
+//
+// Every change pushes a function that reverts it onto the undo log of the
+// transaction. A statement that fails is undone and aborts the transaction,
+// as in PostgreSQL: every later statement fails with TransactionAborted, and
+// committing rolls it back. A savepoint marks a position in the undo log, and
+// rolling back to it undoes the changes made since and clears the abort.
+type Transaction struct {
+	id uint64
+
+	// How long a statement waits for a lock before it fails with
+	// LockWaitTimeout, forever when 0.
+	LockTimeout time.Duration
+
+	// Transactions with an id from snapshot on, and the concurrent ones, had
+	// not committed when this one began.
+	snapshot   uint64
+	concurrent map[uint64]bool
+
+	// Rows deleted by the transaction, to be marked at commit
+	deleted map[rowRef]bool
+
+	undo       []func()
+	savepoints []savepoint
+	aborted    bool
+	done       bool
+
+	// Changes made by the transaction, in order, which a DiskBackend writes
+	// to its log at commit.
+	changes []change
+}
+
+// A savepoint records the length of the undo log of a transaction when it
+// was set.
+type savepoint struct {
+	name string
+	undo int
+}
+
+// A rowRef is a version of a row, by its position in a table.
+type rowRef struct {
+	table    *table
+	rowIndex uint
+}
+
+// A deadRow is a version of a row deleted by a committed transaction. The
+// transactions with an id from since on began once the deletion committed,
+// so they do not see the version.
+type deadRow struct {
+	rowRef
+	since uint64
+}
+
+// A change is a version of a row inserted or deleted by a transaction, or
+// the code of a statement that changed the definition of the tables.
+type change struct {
+	row       rowRef
+	deleted   bool
+	statement string
+}
+
+// Begin starts a transaction, which sees the changes of the transactions
+// committed so far.
+func (mb *MemoryBackend) Begin() (*Transaction, error) {
+	mb.latch.Lock()
+	defer mb.latch.Unlock()
+
+	tx := &Transaction{
+		id:         mb.nextID,
+		snapshot:   mb.nextID,
+		concurrent: map[uint64]bool{},
+		deleted:    map[rowRef]bool{},
+	}
+	for id := range mb.active {
+		tx.concurrent[id] = true
+	}
+
+	mb.nextID++
+	mb.active[tx.id] = true
+	return tx, nil
+}
+
+// Commit makes the changes of a transaction visible to the transactions
+// that begin after it, or rolls them back if the transaction is aborted or
+// conflicts with another one.
+func (mb *MemoryBackend) Commit(tx *Transaction) error {
+	if tx.done {
+		return TransactionClosed
+	}
+
+	if tx.aborted {
+		mb.Rollback(tx)
+		return TransactionAborted
+	}
+
+	mb.latch.Lock()
+	if tx.conflicts() {
+		mb.latch.Unlock()
+		mb.Rollback(tx)
+		return SerializationFailure
+	}
+
+	for ref := range tx.deleted {
+		ref.table.latch.Lock()
+		ref.table.xmax[ref.rowIndex] = tx.id
+		ref.table.latch.Unlock()
+		mb.dead = append(mb.dead, deadRow{rowRef: ref, since: mb.nextID})
+	}
+
+	tx.undo = nil
+	mb.end(tx)
+	mb.latch.Unlock()
+	return nil
+}
+
+// conflicts tells whether tx deleted a row that a transaction which
+// committed in the meantime deleted too. The first transaction to commit a
+// change to a row wins.
+func (mb *MemoryBackend) conflicts(tx *Transaction) bool {
+	mb.latch.RLock()
+	defer mb.latch.RUnlock()
+
+	return tx.conflicts()
+}
+
+func (tx *Transaction) conflicts() bool {
+	for ref := range tx.deleted {
+		ref.table.latch.RLock()
+		deleted := ref.table.rows[ref.rowIndex] == nil || ref.table.xmax[ref.rowIndex] != 0
+		ref.table.latch.RUnlock()
+
+		if deleted {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Rollback undoes every change of a transaction.
+func (mb *MemoryBackend) Rollback(tx *Transaction) error {
+	if tx.done {
+		return TransactionClosed
+	}
+
+	tx.rollbackTo(0)
+
+	mb.latch.Lock()
+	defer mb.latch.Unlock()
+
+	mb.end(tx)
+	return nil
+}
+
+// end retires a transaction whose changes were committed or undone. The
+// rows deleted before the oldest running transaction began can no longer be
+// seen and are purged. The latch of the backend must be held.
+func (mb *MemoryBackend) end(tx *Transaction) {
+	tx.done = true
+	delete(mb.active, tx.id)
+	mb.locks.releaseAll(tx.id)
+
+	oldest := mb.nextID
+	for id := range mb.active {
+		if id < oldest {
+			oldest = id
+		}
+	}
+
+	purged := 0
+	for purged < len(mb.dead) && mb.dead[purged].since <= oldest {
+		mb.dead[purged].table.purgeRow(mb.dead[purged].rowIndex)
+		purged++
+	}
+	mb.dead = mb.dead[purged:]
+}
+
+// run runs a statement in tx, or in a transaction of its own when tx is nil.
+// Statements run alongside each other, and latch the tables and rows they
+// touch only while they touch them. A statement that has to wait for a lock
+// is undone, and runs again once it holds the lock. The changes of a
+// statement that fails are undone, and it aborts tx.
+func (mb *MemoryBackend) run(tx *Transaction, fn func(tx *Transaction) error) error {
+	if tx == nil {
+		tx, _ = mb.Begin()
+		err := mb.run(tx, fn)
+		if err != nil {
+			mb.Rollback(tx)
+			return err
+		}
+
+		return mb.Commit(tx)
+	}
+
+	if tx.done {
+		return TransactionClosed
+	}
+
+	if tx.aborted {
+		return TransactionAborted
+	}
+
+	for {
+		mark := len(tx.undo)
+		err := fn(tx)
+		if err != nil {
+			tx.rollbackTo(mark)
+		}
+
+		if w, ok := err.(*lockWait); ok {
+			err = mb.locks.wait(tx, w.tag, w.mode)
+			if err == nil {
+				continue
+			}
+		}
+
+		if err != nil {
+			tx.aborted = true
+		}
+
+		return err
+	}
+}
+
+// Savepoint sets a savepoint in tx. A savepoint may have the name of an
+// earlier one, which it hides until it is released.
+func (mb *MemoryBackend) Savepoint(tx *Transaction, sp *SavepointStatement) error {
+	if tx == nil {
+		return NotInTransaction
+	}
+
+	return mb.run(tx, func(tx *Transaction) error {
+		tx.savepoints = append(tx.savepoints, savepoint{
+			name: sp.name.value,
+			undo: len(tx.undo),
+		})
+		return nil
+	})
+}
+
+// RollbackToSavepoint undoes the changes made in tx since a savepoint was
+// set, and forgets the savepoints set after it. It works in an aborted
+// transaction, which can go on afterwards.
+func (mb *MemoryBackend) RollbackToSavepoint(tx *Transaction, sp *SavepointStatement) error {
+	if tx == nil {
+		return NotInTransaction
+	}
+
+	if tx.done {
+		return TransactionClosed
+	}
+
+	i := tx.findSavepoint(sp.name.value)
+	if i < 0 {
+		tx.aborted = true
+		return SavepointDoesNotExist
+	}
+
+	tx.rollbackTo(tx.savepoints[i].undo)
+	tx.savepoints = tx.savepoints[:i+1]
+	tx.aborted = false
+	return nil
+}
+
+// ReleaseSavepoint forgets a savepoint and the ones set after it, keeping
+// the changes made since.
+func (mb *MemoryBackend) ReleaseSavepoint(tx *Transaction, sp *SavepointStatement) error {
+	if tx == nil {
+		return NotInTransaction
+	}
+
+	return mb.run(tx, func(tx *Transaction) error {
+		i := tx.findSavepoint(sp.name.value)
+		if i < 0 {
+			return SavepointDoesNotExist
+		}
+
+		tx.savepoints = tx.savepoints[:i]
+		return nil
+	})
+}
+
+// findSavepoint returns the position of the latest savepoint with a name, or
+// -1 if there is none.
+func (tx *Transaction) findSavepoint(name string) int {
+	for i := len(tx.savepoints) - 1; i >= 0; i-- {
+		if tx.savepoints[i].name == name {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// sees tells whether the changes of a transaction are visible to tx. Rows
+// created by transactions that rolled back are gone, so a transaction that
+// ended before tx began committed.
+func (tx *Transaction) sees(id uint64) bool {
+	return id == tx.id || (id < tx.snapshot && !tx.concurrent[id])
+}
+
+// deleteRow deletes a version of a row, as of the commit of tx.
+func (tx *Transaction) deleteRow(t *table, rowIndex uint) {
+	ref := rowRef{table: t, rowIndex: rowIndex}
+	tx.deleted[ref] = true
+	tx.onRollback(func() {
+		delete(tx.deleted, ref)
+	})
+	tx.change(change{row: ref, deleted: true})
+}
+
+// change records a change made by tx.
+func (tx *Transaction) change(c change) {
+	tx.changes = append(tx.changes, c)
+	n := len(tx.changes) - 1
+	tx.onRollback(func() {
+		tx.changes = tx.changes[:n]
+	})
+}
+
+// onRollback registers a function that reverts a change made in tx.
+func (tx *Transaction) onRollback(fn func()) {
+	tx.undo = append(tx.undo, fn)
+}
+
+// rollbackTo reverts the changes registered since the undo log had the given
+// length, latest first.
+func (tx *Transaction) rollbackTo(mark int) {
+	for i := len(tx.undo) - 1; i >= mark; i-- {
+		tx.undo[i]()
+	}
+
+	tx.undo = tx.undo[:mark]
+}
@@ -0,0 +1,149 @@
+package src
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// length (uint32), checksum (uint32), lsn (uint64)
+const walEntryHeaderSize = 16
+
+// A wal is an append-only log of the statements applied to a backend. Every
+// entry is synced to disk before append returns, and carries a checksum so
+// that a torn write at the end of the log can be told apart from a complete
+// entry.
+type wal struct {
+	file *os.File
+	lsn  uint64 // lsn of the last entry written
+	size int64  // offset of the end of the last complete entry
+}
+
+func openWal(path string) (*wal, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &wal{file: f}, nil
+}
+
+// replay calls fn with every complete entry in the log, oldest first. It
+// stops at the first truncated or corrupted entry and cuts the log there, so
+// that new entries are appended right after the last complete one.
+func (w *wal) replay(fn func(lsn uint64, payload []byte) error) error {
+	info, err := w.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	_, err = w.file.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	offset := int64(0)
+	last := uint64(0)
+	header := make([]byte, walEntryHeaderSize)
+	for {
+		_, err = io.ReadFull(w.file, header)
+		if err != nil {
+			break
+		}
+
+		length := binary.BigEndian.Uint32(header)
+		checksum := binary.BigEndian.Uint32(header[4:])
+		lsn := binary.BigEndian.Uint64(header[8:])
+		if offset+walEntryHeaderSize+int64(length) > info.Size() {
+			break
+		}
+
+		payload := make([]byte, length)
+		_, err = io.ReadFull(w.file, payload)
+		if err != nil {
+			break
+		}
+
+		if walChecksum(header[8:], payload) != checksum || lsn <= last {
+			break
+		}
+
+		err = fn(lsn, payload)
+		if err != nil {
+			return err
+		}
+
+		last = lsn
+		offset += walEntryHeaderSize + int64(length)
+	}
+
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+
+	if last > w.lsn {
+		w.lsn = last
+	}
+
+	w.size = offset
+	err = w.file.Truncate(offset)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.file.Seek(offset, io.SeekStart)
+	return err
+}
+
+// append writes a new entry and syncs it to disk, returning its lsn.
+func (w *wal) append(payload []byte) (uint64, error) {
+	lsn := w.lsn + 1
+
+	buf := new(bytes.Buffer)
+	header := make([]byte, walEntryHeaderSize)
+	binary.BigEndian.PutUint32(header, uint32(len(payload)))
+	binary.BigEndian.PutUint64(header[8:], lsn)
+	binary.BigEndian.PutUint32(header[4:], walChecksum(header[8:], payload))
+	buf.Write(header)
+	buf.Write(payload)
+
+	_, err := w.file.WriteAt(buf.Bytes(), w.size)
+	if err != nil {
+		return 0, err
+	}
+
+	err = w.file.Sync()
+	if err != nil {
+		return 0, err
+	}
+
+	w.lsn = lsn
+	w.size += int64(buf.Len())
+	return lsn, nil
+}
+
+// reset empties the log once its entries are safely in the data file. Entry
+// numbering carries on from the last lsn.
+func (w *wal) reset() error {
+	err := w.file.Truncate(0)
+	if err != nil {
+		return err
+	}
+
+	w.size = 0
+	return w.file.Sync()
+}
+
+func (w *wal) close() error {
+	return w.file.Close()
+}
+
+func walChecksum(lsn []byte, payload []byte) uint32 {
+	crc := crc32.NewIEEE()
+	crc.Write(lsn)
+	crc.Write(payload)
+	return crc.Sum32()
+}